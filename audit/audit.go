@@ -0,0 +1,219 @@
+// Package audit records who changed what in the audit log and serves it to
+// bookkeepers.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang_project/auth"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// SystemActor names the server in entries of changes it makes by itself, such
+// as purging the trash
+const SystemActor = "system"
+
+// redacted lists the fields that are never written to the audit log
+var redacted = map[string]bool{"password": true}
+
+// Change is the value of a field before and after an action. Before is absent
+// for created entities and After for deleted ones.
+type Change struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Log appends an entry to the audit log for every change made through the API
+type Log struct {
+	entries store.AuditRepository
+}
+
+// NewLog returns a Log backed by entries
+func NewLog(entries store.AuditRepository) *Log {
+	return &Log{entries: entries}
+}
+
+// Record logs that request r performed action on the entity with the given ID.
+// before and after are the entity's state around the action, nil when it did
+// not exist. The actor is taken from the request's claims, if any. Failures
+// are logged rather than returned, as the change has already been made.
+func (l *Log) Record(r *http.Request, action, entity string, id int, before, after interface{}) {
+	actorID, actor := Actor(r)
+	l.record(r, actorID, actor, action, entity, id, before, after)
+}
+
+// RecordAs is Record for requests made on behalf of user without a token,
+// such as following a link mailed to them or signing in at an identity
+// provider
+func (l *Log) RecordAs(r *http.Request, user models.User, action, entity string, id int, before, after interface{}) {
+	actorID := user.ID
+	l.record(r, &actorID, user.Email, action, entity, id, before, after)
+}
+
+// RecordSystem logs a change the server made by itself, outside of any
+// request, as made by SystemActor
+func (l *Log) RecordSystem(action, entity string, id int, before, after interface{}) {
+	l.record(nil, nil, SystemActor, action, entity, id, before, after)
+}
+
+// record appends an entry for the change, taking the client IP, request ID
+// and API key from r unless it is nil
+func (l *Log) record(r *http.Request, actorID *int, actor, action, entity string, id int, before, after interface{}) {
+	changes, err := Diff(before, after)
+	if err != nil {
+		log.Printf("audit %s of %s %d: %v", action, entity, id, err)
+		return
+	}
+
+	entry := models.AuditEntry{
+		ActorID:   actorID,
+		Actor:     actor,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  id,
+		Changes:   changes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if r != nil {
+		entry.IP = auth.ClientIP(r)
+		entry.RequestID = problem.RequestIDFromContext(r.Context())
+		if claims, ok := auth.ClaimsFromContext(r.Context()); ok && claims.APIKeyID != 0 {
+			keyID := claims.APIKeyID
+			entry.APIKeyID = &keyID
+		}
+	}
+	if err := l.entries.Append(&entry); err != nil {
+		log.Printf("audit %s of %s %d: %v", action, entity, id, err)
+	}
+}
+
+// Actor returns the ID and name of the account that made request r, or nil
+// and an empty name when it was made anonymously
+func Actor(r *http.Request) (*int, string) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		return nil, ""
+	}
+	id := claims.UserID
+	return &id, claims.Username
+}
+
+// Diff returns the fields of before and after that differ, as a JSON object
+// mapping each field to its Change. Both must marshal to JSON objects, or be
+// nil. Redacted fields are left out.
+func Diff(before, after interface{}) (json.RawMessage, error) {
+	old, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]Change)
+	for name, value := range old {
+		if !bytes.Equal(value, updated[name]) {
+			changes[name] = Change{Before: value, After: updated[name]}
+		}
+	}
+	for name, value := range updated {
+		if _, ok := old[name]; !ok {
+			changes[name] = Change{After: value}
+		}
+	}
+	for name := range redacted {
+		delete(changes, name)
+	}
+	return json.Marshal(changes)
+}
+
+// fields returns the fields of v, which marshals to a JSON object
+func fields(v interface{}) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// ListEntries handles the request to read the audit log
+// @Summary Read the audit log
+// @Description Get the most recent entries of the audit log, newest first. Each entry records who created,
+// @Description updated, deleted, restored or purged which entity, from which address and in which request, with the
+// @Description fields that changed. Pass the ID of the last entry as before to get older entries.
+// @Tags audit
+// @Produce json
+// @Param actor_id query int false "ID of the account that made the change"
+// @Param action query string false "create, update, delete, restore or purge"
+// @Param entity query string false "book, copy, user, bookkeeper, loan, hold, renewal_policy, ledger_entry, api_key, two_factor, lockout, password or identity"
+// @Param entity_id query int false "ID of the changed entity"
+// @Param since query string false "Earliest time, RFC 3339"
+// @Param until query string false "Time before which entries were made, RFC 3339"
+// @Param before query int false "Only entries older than the entry with this ID"
+// @Param limit query int false "Maximum number of entries, 50 by default"
+// @Success 200 {array} models.AuditEntry
+// @Failure 400 {object} problem.Problem "Invalid filter"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Router /audit [get]
+func (l *Log) ListEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := store.AuditFilter{
+		Action: query.Get("action"),
+		Entity: query.Get("entity"),
+		Limit:  50,
+	}
+	for name, dst := range map[string]*int{"actor_id": &filter.ActorID, "entity_id": &filter.EntityID, "before": &filter.BeforeID} {
+		if raw := query.Get(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 {
+				problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid "+name)
+				return
+			}
+			*dst = n
+		}
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if raw := query.Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid "+name+", expected RFC 3339")
+				return
+			}
+			*dst = t.UTC()
+		}
+	}
+	if raw := query.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Limit must be between 1 and 500")
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := l.entries.List(filter)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
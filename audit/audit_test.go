@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"golang_project/models"
+)
+
+func TestDiff(t *testing.T) {
+	before := models.User{ID: 1, Name: "Jane", Email: "jane@example.com", Password: "hash", Role: "user"}
+	after := before
+	after.Name = "Jane Doe"
+	after.Password = "new hash"
+
+	tests := []struct {
+		name          string
+		before, after interface{}
+		want          string
+	}{
+		{"update", before, after, `{"name":{"before":"Jane","after":"Jane Doe"}}`},
+		{"unchanged", before, before, `{}`},
+		{"create", nil, models.Book{ID: 2, Title: "Dune"}, `{"author":{"after":""},"available_copies":{"after":0},"genre":{"after":""},"id":{"after":2},"isbn":{"after":""},"published_year":{"after":0},"title":{"after":"Dune"},"total_copies":{"after":0},"version":{"after":0}}`},
+	}
+	for _, tt := range tests {
+		got, err := Diff(tt.before, tt.after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s want %s", tt.name, got, tt.want)
+		}
+	}
+
+	got, err := Diff(before, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes map[string]Change
+	if err := json.Unmarshal(got, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := changes["password"]; ok || string(changes["email"].Before) != `"jane@example.com"` || changes["email"].After != nil {
+		t.Errorf("delete: got %s", got)
+	}
+}
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// APIKeyHeader carries an API key in place of a token
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to spot
+const apiKeyPrefix = "lib_"
+
+var errInvalidAPIKey = errors.New("auth: invalid API key")
+
+// APIKeyRequest describes an API key to create. Without an expiry the key
+// works until it is revoked.
+type APIKeyRequest struct {
+	Name      string       `json:"name"`
+	Scopes    []Permission `json:"scopes"`
+	ExpiresAt *time.Time   `json:"expires_at,omitempty"`
+}
+
+// APIKeyResponse returns a new API key. The key itself is only shown once.
+type APIKeyResponse struct {
+	Key    string        `json:"key"`
+	APIKey models.APIKey `json:"api_key"`
+}
+
+// apiKeyStores are what AuthMiddleware needs to check an API key
+type apiKeyStores struct {
+	keys  store.APIKeyRepository
+	users store.UserRepository
+}
+
+// WithAPIKeys returns a middleware that lets AuthMiddleware accept API keys
+// sent in the X-API-Key header. A key acts on behalf of the account that
+// created it, limited to its scopes.
+func WithAPIKeys(keys store.APIKeyRepository, users store.UserRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), apiKeysKey, apiKeyStores{keys: keys, users: users})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// apiKeyClaims returns the claims of the account that created the API key
+// raw, restricted to the key's scopes, and records the key as used
+func apiKeyClaims(ctx context.Context, raw string) (*Claims, error) {
+	stores, ok := ctx.Value(apiKeysKey).(apiKeyStores)
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+
+	prefix, _, ok := strings.Cut(raw, ".")
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+	key, err := stores.keys.GetByPrefix(prefix)
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(raw)), []byte(key.KeyHash)) != 1 {
+		return nil, errInvalidAPIKey
+	}
+	now := time.Now().UTC()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, errInvalidAPIKey
+	}
+	owner, err := stores.users.Get(key.CreatedBy, "")
+	if err != nil {
+		return nil, errInvalidAPIKey
+	}
+
+	if err := stores.keys.Touch(key.ID, now); err != nil {
+		log.Printf("recording use of API key %s: %v", key.Prefix, err)
+	}
+	claims := &Claims{UserID: owner.ID, Username: owner.Email, Role: owner.Role, APIKeyID: key.ID}
+	for _, scope := range key.Scopes {
+		claims.Scopes = append(claims.Scopes, Permission(scope))
+	}
+	return claims, nil
+}
+
+// grantable reports whether an API key may be scoped to perm. Keys cannot
+// manage keys, so that a leaked key cannot mint more.
+func grantable(perm Permission) bool {
+	switch perm {
+	case PermAccountRead, PermBooksWrite, PermFinesWrite, PermHoldsWrite, PermLoansRenew, PermLoansWrite, PermUsersWrite, PermUsersAdmin, PermAuditRead:
+		return true
+	}
+	return false
+}
+
+// CreateAPIKey handles the request to create an API key
+// @Summary Create an API key
+// @Description Create a key for scripts and services to send in the X-API-Key header. The key acts on
+// @Description behalf of the creating account and only holds the permissions listed in scopes that the
+// @Description account's role also holds. The key is returned once and only its hash is stored.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body auth.APIKeyRequest true "Name, scopes and optional expiry"
+// @Success 201 {object} auth.APIKeyResponse
+// @Failure 400 {object} problem.Problem "Missing name, unknown scope or expiry in the past"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Router /apikeys/create [post]
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Scopes) == 0 {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Name and scopes are required")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !grantable(scope) {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, fmt.Sprintf("Unknown scope %q", scope))
+			return
+		}
+	}
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Expiry must be in the future")
+		return
+	}
+
+	prefix, err := randomToken(6)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating key")
+		return
+	}
+	secret, err := randomToken(32)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating key")
+		return
+	}
+	raw := apiKeyPrefix + prefix + "." + secret
+
+	key := models.APIKey{
+		Name:      req.Name,
+		Prefix:    apiKeyPrefix + prefix,
+		KeyHash:   hashToken(raw),
+		CreatedBy: claims.UserID,
+		CreatedAt: now,
+		ExpiresAt: req.ExpiresAt,
+	}
+	for _, scope := range req.Scopes {
+		key.Scopes = append(key.Scopes, string(scope))
+	}
+	if err := h.apiKeys.Create(&key); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error saving key")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "api_key", key.ID, nil, key)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIKeyResponse{Key: raw, APIKey: key})
+}
+
+// ListAPIKeys handles the request to list API keys
+// @Summary List API keys
+// @Description Get every API key, newest first, with its prefix, scopes, expiry and when it was last used.
+// @Description The keys themselves are not stored and cannot be shown.
+// @Tags auth
+// @Produce json
+// @Success 200 {array} models.APIKey
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Router /apikeys [get]
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	keys, err := h.apiKeys.List()
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey handles the request to revoke an API key
+// @Summary Revoke an API key
+// @Description Revoke an API key. Requests using it are refused from then on.
+// @Tags auth
+// @Produce plain
+// @Param id query int true "API key ID"
+// @Success 200 {string} string "API key revoked"
+// @Failure 400 {object} problem.Problem "Invalid ID"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "API key not found or already revoked"
+// @Router /apikeys/revoke [post]
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid ID")
+		return
+	}
+	before, err := h.apiKeys.Get(id)
+	if err != nil {
+		problem.StoreError(w, r, err, "API key not found")
+		return
+	}
+	if err := h.apiKeys.Revoke(id, time.Now().UTC()); err != nil {
+		problem.StoreError(w, r, err, "API key not found")
+		return
+	}
+	if after, err := h.apiKeys.Get(id); err == nil {
+		h.audit.Record(r, models.AuditUpdate, "api_key", id, before, after)
+	}
+	w.Write([]byte("API key revoked"))
+}
@@ -1,238 +1,327 @@
 package auth
 
 import (
-	"database/sql"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
+	"strings"
 	"time"
 
+	"golang_project/mail"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+
 	"github.com/golang-jwt/jwt/v4"
-	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var secret = []byte("monkey")
-
+// Credentials identify an account by email. Username is the older name of the
+// field and is used when email is empty.
 type Credentials struct {
-	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Username string `json:"username,omitempty"`
 	Password string `json:"password"`
+	// OTP is a TOTP or recovery code, required once two-factor authentication is on
+	OTP string `json:"otp,omitempty"`
+}
+
+// login returns the name the credentials identify the account by
+func (c Credentials) login() string {
+	if c.Email != "" {
+		return c.Email
+	}
+	return c.Username
 }
 
 type Claims struct {
+	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	// TwoFactor is true when the session was started with a second factor
+	TwoFactor bool `json:"tfa,omitempty"`
+	// APIKeyID and Scopes are set when the request used an API key, which
+	// only holds the permissions in Scopes
+	APIKeyID int          `json:"-"`
+	Scopes   []Permission `json:"-"`
 	jwt.RegisteredClaims
 }
 
-// LoginUser handles user login
-// @Summary User login
-// @Description Authenticate a user and return a JWT token
-// @Tags auth
-// @Accept json
-// @Produce json
-// @Param credentials body auth.Credentials true "User credentials"
-// @Success 200 {string} string "Login successful"
-// @Failure 401 {string} string "Unauthorized"
-// @Router /login [post]
-func LoginUser(w http.ResponseWriter, r *http.Request) {
-	var creds Credentials
-	err := json.NewDecoder(r.Body).Decode(&creds)
-	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		return
-	}
+type contextKey int
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	if err != nil {
-		http.Error(w, "Database connection error", http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
+const (
+	claimsKey contextKey = iota
+	sessionsKey
+	apiKeysKey
+)
 
-	var storedCreds Credentials
-	err = db.QueryRow("SELECT username, password FROM users WHERE username = ?", creds.Username).Scan(&storedCreds.Username, &storedCreds.Password)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
-		return
-	}
+// tokenTTL is how long an issued token stays valid
+const tokenTTL = 5 * time.Minute
 
-	err = bcrypt.CompareHashAndPassword([]byte(storedCreds.Password), []byte(creds.Password))
-	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
-		return
-	}
+// IssueToken signs a token identifying user and carrying their role with the
+// active key (see SetKeys). The
+// session ID becomes the token's jti, which AuthMiddleware checks for revocation.
+func IssueToken(user models.User, sessionID string) (string, time.Time, error) {
+	return issueToken(user, sessionID, false)
+}
 
-	expirationTime := time.Now().Add(5 * time.Minute)
+func issueToken(user models.User, sessionID string, twoFactor bool) (string, time.Time, error) {
+	expirationTime := time.Now().Add(tokenTTL)
 	claims := &Claims{
-		Username: creds.Username,
+		UserID:    user.ID,
+		Username:  user.Email,
+		Role:      user.Role,
+		TwoFactor: twoFactor,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secret)
-	if err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
-		return
+	tokenString, err := keys.Load().Sign(claims)
+	return tokenString, expirationTime, err
+}
+
+// ClaimsFromContext returns the claims stored by AuthMiddleware
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// sessionClaims returns the claims stored by AuthMiddleware unless the request
+// used an API key. Managing an account's sessions, password, second factor and
+// keys takes a login.
+func sessionClaims(ctx context.Context) (*Claims, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.APIKeyID != 0 {
+		return nil, false
 	}
+	return claims, true
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Login successful"))
+// Handler serves the login, session, password and email verification endpoints
+type Handler struct {
+	users         store.UserRepository
+	sessions      store.SessionRepository
+	resets        store.OneTimeTokenRepository
+	verifications store.OneTimeTokenRepository
+	twoFactor     store.TwoFactorRepository
+	apiKeys       store.APIKeyRepository
+	limiter       *Limiter
+	mailer        mail.Mailer
+	appURL        string
+	audit         Auditor
+}
+
+// Auditor records changes to accounts and their credentials in the audit log.
+// Record takes the actor from the request's claims; RecordAs names the
+// account a request without a token acted for.
+type Auditor interface {
+	Record(r *http.Request, action, entity string, id int, before, after interface{})
+	RecordAs(r *http.Request, user models.User, action, entity string, id int, before, after interface{})
+}
+
+// accountEntity names accounts with role in the audit log, as the user and
+// bookkeeper endpoints do: patrons are users and staff are bookkeepers
+func accountEntity(role string) string {
+	if role == "user" {
+		return "user"
+	}
+	return "bookkeeper"
+}
+
+// NewHandler returns a Handler backed by the given repositories. Links in the
+// emails it sends through mailer point to appURL, and changes to accounts are
+// recorded through auditor.
+func NewHandler(users store.UserRepository, sessions store.SessionRepository, resets, verifications store.OneTimeTokenRepository, twoFactor store.TwoFactorRepository, apiKeys store.APIKeyRepository, limiter *Limiter, mailer mail.Mailer, appURL string, auditor Auditor) *Handler {
+	return &Handler{
+		users:         users,
+		sessions:      sessions,
+		resets:        resets,
+		verifications: verifications,
+		twoFactor:     twoFactor,
+		apiKeys:       apiKeys,
+		limiter:       limiter,
+		mailer:        mailer,
+		appURL:        strings.TrimSuffix(appURL, "/"),
+		audit:         auditor,
+	}
 }
 
-// LoginBookkeeper handles bookkeeper login
-// @Summary Bookkeeper login
-// @Description Authenticate a bookkeeper and return a JWT token
+// Token handles login
+// @Summary Log in
+// @Description Authenticate an account by email and start a session. The tokens are returned in the body and set as cookies.
+// @Description The session carries the role stored on the account. Patrons must have verified their email address.
+// @Description The legacy username field is still accepted in place of email. POST /login and /login/bookkeepers are aliases.
 // @Tags auth
 // @Accept json
 // @Produce json
-// @Param credentials body auth.Credentials true "Bookkeeper credentials"
-// @Success 200 {string} string "Login successful"
-// @Failure 401 {string} string "Unauthorized"
-// @Router /login/bookkeepers [post]
-func LoginBookkeeper(w http.ResponseWriter, r *http.Request) {
+// @Param credentials body auth.Credentials true "Account credentials"
+// @Success 200 {object} auth.TokenResponse
+// @Failure 401 {object} problem.Problem "Invalid credentials, or a missing or wrong two-factor code"
+// @Failure 403 {object} problem.Problem "Email address not verified, once both factors are checked"
+// @Failure 429 {object} problem.Problem "Too many failed logins, or account locked"
+// @Router /auth/token [post]
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
 	var creds Credentials
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
 		return
 	}
+	login := creds.login()
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	if err != nil {
-		http.Error(w, "Database connection error", http.StatusInternalServerError)
+	if !h.allowLogin(w, r, login) {
 		return
 	}
-	defer db.Close()
 
-	var storedCreds Credentials
-	err = db.QueryRow("SELECT email, password FROM users WHERE email = ? AND role = 'admin'", creds.Username).Scan(&storedCreds.Username, &storedCreds.Password)
+	user, err := h.findAccount(login)
 	if err != nil {
-		fmt.Fprintf(w, "email: %s ", creds.Username)
-		http.Error(w, "Bookkeeper not found", http.StatusUnauthorized)
+		h.loginFailed(w, r, login, nil, models.LoginUnknownAccount, "Account not found")
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(storedCreds.Password), []byte(creds.Password))
+	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
 	if err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		h.loginFailed(w, r, login, &user, models.LoginWrongPassword, "Invalid credentials")
 		return
 	}
 
-	expirationTime := time.Now().Add(5 * time.Minute)
-	claims := &Claims{
-		Username: creds.Username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
+	twoFactor, ok := h.checkSecondFactor(w, r, user, creds.OTP)
+	if !ok {
+		return
+	}
+	// Only tell whether the address is verified to a caller who passed both factors
+	if mustVerify(user) {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeEmailUnverified, "Email address not verified")
+		return
 	}
+	h.loginSucceeded(user.Email)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secret)
-	if err != nil {
-		http.Error(w, "Error generating token", http.StatusInternalServerError)
+	if err := h.startSession(w, r, user, twoFactor); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating token")
 		return
 	}
+}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Login successful"))
+// mustVerify reports whether user has to confirm their email address before
+// logging in. Staff accounts are created by an administrator rather than
+// registered, so only patrons have to.
+func mustVerify(user models.User) bool {
+	return user.Role == "user" && user.EmailVerifiedAt == nil
+}
+
+// findAccount returns the account that login names. Logins holding an @ are
+// emails, anything else a username, or else the email of an older account
+// registered before addresses were checked.
+func (h *Handler) findAccount(login string) (models.User, error) {
+	if strings.Contains(login, "@") {
+		return h.users.GetByEmail(login)
+	}
+	user, err := h.users.GetByUsername(login)
+	if errors.Is(err, store.ErrNotFound) {
+		return h.users.GetByEmail(login)
+	}
+	return user, err
 }
 
-// AuthMiddleware is a middleware for authenticating users
+// AuthMiddleware is a middleware for authenticating users. The token is read
+// from an "Authorization: Bearer" header, or else from the token cookie, in
+// which case unsafe methods must also pass the CSRF check. When the request
+// context carries a session repository (see WithSessions), tokens whose
+// session has been revoked or has expired are rejected. Requests with an
+// X-API-Key header are authenticated by that key instead (see WithAPIKeys).
 // @Summary User authentication middleware
-// @Description Middleware to authenticate users using JWT token
+// @Description Middleware to authenticate users using a JWT sent as a bearer token or in the token cookie,
+// @Description or an API key sent in the X-API-Key header
 // @Tags auth
 // @Produce json
 // @Success 200 {string} string "Authenticated"
-// @Failure 401 {string} string "Unauthorized"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "CSRF check failed"
 // @Router /user [get]
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if raw := r.Header.Get(APIKeyHeader); raw != "" {
+			claims, err := apiKeyClaims(r.Context(), raw)
+			if err != nil {
+				problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Invalid API key")
 				return
 			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
 			return
 		}
 
-		tokenStr := c.Value
-		claims := &Claims{}
+		tokenStr, fromCookie, err := tokenFromRequest(r)
+		if err != nil {
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+			return
+		}
+		if fromCookie && !csrfValid(r) {
+			problem.Write(w, r, http.StatusForbidden, problem.CodeCSRFFailed, "Missing or invalid CSRF token")
+			return
+		}
 
-		tkn, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return secret, nil
-		})
+		claims := &Claims{}
+		tkn, err := jwt.ParseWithClaims(tokenStr, claims, keys.Load().Keyfunc)
 		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			if errors.Is(err, jwt.ErrSignatureInvalid) || errors.Is(err, ErrUnknownKey) {
+				problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
 				return
 			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Bad request")
 			return
 		}
 		if !tkn.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		if sessions, ok := r.Context().Value(sessionsKey).(store.SessionRepository); ok && !sessionActive(sessions, claims.ID) {
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Session revoked")
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// tokenFromRequest returns the bearer token of the Authorization header, or
+// the token cookie when there is no such header
+func tokenFromRequest(r *http.Request) (token string, fromCookie bool, err error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", false, errors.New("auth: malformed Authorization header")
+		}
+		return strings.TrimSpace(token), false, nil
+	}
+
+	c, err := r.Cookie("token")
+	if err != nil {
+		return "", false, err
+	}
+	return c.Value, true, nil
+}
+
 // BookkeeperMiddleware is a middleware for authenticating bookkeepers
 // @Summary Bookkeeper authentication middleware
 // @Description Middleware to authenticate bookkeepers using JWT token
 // @Tags auth
 // @Produce json
 // @Success 200 {string} string "Authenticated"
-// @Failure 401 {string} string "Unauthorized"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 403 {object} problem.Problem "Forbidden"
 // @Router /admin [get]
 func BookkeeperMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
-			return
-		}
-
-		tokenStr := c.Value
-		claims := &Claims{}
-
-		tkn, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return secret, nil
-		})
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
-			return
-		}
-		if !tkn.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+	return DefaultPolicy.RequirePermission(PermBooksWrite)(next)
 }
 
 // AdminHandler handles requests to the admin page
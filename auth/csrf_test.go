@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCookieAuthRequiresCSRF(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	token, refresh := login(t, router)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header string
+		want   int
+	}{
+		{"safe method", "GET", "/sessions", "", http.StatusOK},
+		{"missing header", "POST", "/logout", "", http.StatusForbidden},
+		{"wrong header", "POST", "/logout", "forged", http.StatusForbidden},
+		{"refresh without header", "POST", "/refresh", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies := token
+			if tt.target == "/refresh" {
+				cookies = refresh
+			}
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Signing algorithms a key may use
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// ErrUnknownKey is returned when a token names a key that is not in the key set
+var ErrUnknownKey = errors.New("auth: unknown signing key")
+
+// Key is a named key for one signing algorithm. Keys loaded from a public
+// key file can only verify tokens.
+type Key struct {
+	ID        string
+	Algorithm string
+	sign      interface{}
+	verify    interface{}
+}
+
+// NewHMACKey returns an HS256 key using secret for both signing and verification
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{ID: id, Algorithm: AlgHS256, sign: secret, verify: secret}
+}
+
+// NewRSAKey returns an RS256 key. Without a private key it can only verify.
+func NewRSAKey(id string, private *rsa.PrivateKey, public *rsa.PublicKey) *Key {
+	key := &Key{ID: id, Algorithm: AlgRS256, verify: public}
+	if private != nil {
+		key.sign = private
+		key.verify = &private.PublicKey
+	}
+	return key
+}
+
+// NewEdDSAKey returns an Ed25519 key. Without a private key it can only verify.
+func NewEdDSAKey(id string, private ed25519.PrivateKey, public ed25519.PublicKey) *Key {
+	key := &Key{ID: id, Algorithm: AlgEdDSA, verify: public}
+	if private != nil {
+		key.sign = private
+		key.verify = private.Public()
+	}
+	return key
+}
+
+// CanSign reports whether the key holds the private part needed to sign tokens
+func (k *Key) CanSign() bool {
+	return k.sign != nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// KeySet holds the key tokens are signed with and every key tokens are
+// verified against. Keeping retired keys in the set lets tokens they signed
+// stay valid while keys are rotated.
+type KeySet struct {
+	active *Key
+	keys   map[string]*Key
+}
+
+// NewKeySet returns a key set that signs with active and verifies with
+// active and every key in others
+func NewKeySet(active *Key, others ...*Key) (*KeySet, error) {
+	if active == nil || !active.CanSign() {
+		return nil, errors.New("auth: the active key must be able to sign")
+	}
+
+	ks := &KeySet{active: active, keys: make(map[string]*Key)}
+	for _, key := range append([]*Key{active}, others...) {
+		if key.ID == "" {
+			return nil, errors.New("auth: key without an ID")
+		}
+		if key.method() == nil {
+			return nil, fmt.Errorf("auth: key %q uses unsupported algorithm %q", key.ID, key.Algorithm)
+		}
+		if _, dup := ks.keys[key.ID]; dup {
+			return nil, fmt.Errorf("auth: duplicate key ID %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+	return ks, nil
+}
+
+// Sign signs claims with the active key, naming it in the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method(), claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.sign)
+}
+
+// Keyfunc returns the key named by the token's kid header, refusing tokens
+// signed with another algorithm than the key's. Tokens without a kid are
+// checked against the active key.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	key := ks.active
+	if kid, ok := token.Header["kid"].(string); ok {
+		key = ks.keys[kid]
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return key.verify, nil
+}
+
+// JWK is the public part of a key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set. HS256 keys are shared secrets and
+// are never published.
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range ks.ids() {
+		key := ks.keys[id]
+		switch public := key.verify.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				N:         base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Use:       "sig",
+				Algorithm: key.Algorithm,
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
+
+// ids returns the key IDs with the active key first and the rest in a stable order
+func (ks *KeySet) ids() []string {
+	ids := []string{ks.active.ID}
+	var rest []string
+	for id := range ks.keys {
+		if id != ks.active.ID {
+			rest = append(rest, id)
+		}
+	}
+	sort.Strings(rest)
+	return append(ids, rest...)
+}
+
+// KeyConfig describes one key of a keys file. Asymmetric keys are read from
+// PEM files; an HS256 secret is given inline or read from a file.
+type KeyConfig struct {
+	ID        string `json:"kid"`
+	Algorithm string `json:"alg"`
+	File      string `json:"file,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+}
+
+// KeysConfig is the content of a keys file
+type KeysConfig struct {
+	Active string      `json:"active"`
+	Keys   []KeyConfig `json:"keys"`
+}
+
+// LoadKeys reads a keys file from path. Relative key file paths are resolved
+// against the directory of path.
+func LoadKeys(path string) (*KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config KeysConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	var active *Key
+	var others []*Key
+	for _, kc := range config.Keys {
+		if kc.File != "" && !filepath.IsAbs(kc.File) {
+			kc.File = filepath.Join(filepath.Dir(path), kc.File)
+		}
+		key, err := kc.load()
+		if err != nil {
+			return nil, fmt.Errorf("auth: key %q: %w", kc.ID, err)
+		}
+		if kc.ID == config.Active {
+			active = key
+		} else {
+			others = append(others, key)
+		}
+	}
+	if active == nil {
+		return nil, fmt.Errorf("auth: active key %q is not configured", config.Active)
+	}
+	return NewKeySet(active, others...)
+}
+
+func (kc KeyConfig) load() (*Key, error) {
+	var data []byte
+	if kc.File != "" {
+		var err error
+		if data, err = os.ReadFile(kc.File); err != nil {
+			return nil, err
+		}
+	}
+
+	switch kc.Algorithm {
+	case AlgHS256:
+		secret := []byte(kc.Secret)
+		if kc.File != "" {
+			secret = data
+		}
+		if len(secret) < 32 {
+			return nil, errors.New("HS256 secret must be at least 32 bytes")
+		}
+		return NewHMACKey(kc.ID, secret), nil
+	case AlgRS256:
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+			return NewRSAKey(kc.ID, private, nil), nil
+		}
+		public, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, errors.New("file holds no RSA key")
+		}
+		return NewRSAKey(kc.ID, nil, public), nil
+	case AlgEdDSA:
+		if private, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+			return NewEdDSAKey(kc.ID, private.(ed25519.PrivateKey), nil), nil
+		}
+		public, err := jwt.ParseEdPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, errors.New("file holds no Ed25519 key")
+		}
+		return NewEdDSAKey(kc.ID, nil, public.(ed25519.PublicKey)), nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+}
+
+// KeysFromEnv loads the keys file named by KEYS_FILE. Without it, JWT_SECRET
+// is used as a single HS256 key, and without that a random key is generated,
+// so tokens do not survive a restart.
+func KeysFromEnv() (*KeySet, error) {
+	if path := os.Getenv("KEYS_FILE"); path != "" {
+		return LoadKeys(path)
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		key, err := KeyConfig{ID: "default", Algorithm: AlgHS256, Secret: secret}.load()
+		if err != nil {
+			return nil, fmt.Errorf("auth: JWT_SECRET: %w", err)
+		}
+		return NewKeySet(key)
+	}
+	log.Print("auth: neither KEYS_FILE nor JWT_SECRET is set, signing tokens with a random key")
+	return randomKeySet(), nil
+}
+
+func randomKeySet() *KeySet {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	ks, err := NewKeySet(NewHMACKey("ephemeral", secret))
+	if err != nil {
+		panic(err)
+	}
+	return ks
+}
+
+// keys is the key set tokens are signed and verified with
+var keys atomic.Pointer[KeySet]
+
+func init() {
+	keys.Store(randomKeySet())
+}
+
+// SetKeys replaces the key set tokens are signed and verified with
+func SetKeys(ks *KeySet) {
+	keys.Store(ks)
+}
+
+// ServeJWKS handles the request for the public verification keys
+// @Summary JSON Web Key Set
+// @Description Get the public keys tokens issued by this API can be verified with, in JWKS format (RFC 7517).
+// @Description Shared HS256 secrets are not published.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} auth.JWKS
+// @Router /.well-known/jwks.json [get]
+func ServeJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(keys.Load().JWKS())
+}
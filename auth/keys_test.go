@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func parse(ks *KeySet, token string) error {
+	_, err := jwt.ParseWithClaims(token, &Claims{}, ks.Keyfunc)
+	return err
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "ed25519.pem"), "PRIVATE KEY", der)
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "rsa.pub.pem"), "PUBLIC KEY", der)
+
+	config := `{
+		"active": "2024-06",
+		"keys": [
+			{"kid": "2024-06", "alg": "EdDSA", "file": "ed25519.pem"},
+			{"kid": "2024-01", "alg": "RS256", "file": "rsa.pub.pem"},
+			{"kid": "legacy", "alg": "HS256", "secret": "0123456789abcdef0123456789abcdef"}
+		]
+	}`
+	path := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err := LoadKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := ks.Sign(&Claims{Role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(ks, token); err != nil {
+		t.Errorf("token signed with the active key: %v", err)
+	}
+
+	// A token signed before the RSA key was retired still verifies
+	retired, err := NewKeySet(NewRSAKey("2024-01", rsaPrivate, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err = retired.Sign(&Claims{Role: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(ks, token); err != nil {
+		t.Errorf("token signed with a retired key: %v", err)
+	}
+
+	jwks := ks.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != "2024-06" || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[1].KeyType != "RSA" {
+		t.Errorf("expected the Ed25519 and RSA public keys only: %+v", jwks.Keys)
+	}
+}
+
+func TestLoadKeysRequiresSigningActiveKey(t *testing.T) {
+	dir := t.TempDir()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, "ed25519.pub.pem"), "PUBLIC KEY", der)
+
+	path := filepath.Join(dir, "keys.json")
+	config := `{"active": "pub", "keys": [{"kid": "pub", "alg": "EdDSA", "file": "ed25519.pub.pem"}]}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKeys(path); err == nil {
+		t.Error("expected a public-only active key to be refused")
+	}
+}
+
+func TestKeyfuncRefusesUnknownAndMismatchedKeys(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	ks, err := NewKeySet(NewHMACKey("current", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewKeySet(NewHMACKey("other", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := other.Sign(&Claims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(ks, token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown kid: got %v want %v", err, ErrUnknownKey)
+	}
+
+	// An HS384 token signed with the same secret must not pass as HS256
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS384, &Claims{})
+	forged.Header["kid"] = "current"
+	token, err = forged.SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parse(ks, token); !errors.Is(err, jwt.ErrSignatureInvalid) {
+		t.Errorf("mismatched algorithm: got %v want %v", err, jwt.ErrSignatureInvalid)
+	}
+}
+
+func TestServeJWKS(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks, err := NewKeySet(NewEdDSAKey("ed", private, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := keys.Load()
+	SetKeys(ks)
+	t.Cleanup(func() { SetKeys(previous) })
+
+	rr := httptest.NewRecorder()
+	ServeJWKS(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var jwks JWKS
+	if err := json.Unmarshal(rr.Body.Bytes(), &jwks); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "ed" || jwks.Keys[0].Curve != "Ed25519" {
+		t.Errorf("unexpected key set: %+v", jwks)
+	}
+}
@@ -0,0 +1,644 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OIDCLoginTTL is how long a user has to sign in at the identity provider
+const OIDCLoginTTL = 10 * time.Minute
+
+// oidcStateCookie ties a login to the browser that started it, so that a
+// callback link made for someone else's login is refused. It is only sent to
+// /login/oidc and the callback under it.
+const (
+	oidcStateCookie = "oidc_state"
+	oidcPath        = "/login/oidc"
+)
+
+// RoleRule gives accounts whose role claim holds Value the role Role
+type RoleRule struct {
+	Value string `json:"value"`
+	Role  string `json:"role"`
+}
+
+// OIDCConfig describes the OpenID Connect identity provider patrons can sign
+// in with. The role of a new account comes from the first rule in Roles whose
+// value is in the RoleClaim claim of the ID token, or else from DefaultRole.
+type OIDCConfig struct {
+	Issuer       string     `json:"issuer"`
+	ClientID     string     `json:"client_id"`
+	ClientSecret string     `json:"client_secret,omitempty"`
+	RedirectURL  string     `json:"redirect_url"`
+	Scopes       []string   `json:"scopes,omitempty"`
+	RoleClaim    string     `json:"role_claim,omitempty"`
+	Roles        []RoleRule `json:"roles,omitempty"`
+	DefaultRole  string     `json:"default_role,omitempty"`
+}
+
+// LoadOIDCConfig reads an OIDC configuration from path
+func LoadOIDCConfig(path string) (OIDCConfig, error) {
+	var config OIDCConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return config, errors.New("auth: OIDC issuer, client_id and redirect_url are required")
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if config.DefaultRole == "" {
+		config.DefaultRole = "user"
+	}
+	return config, nil
+}
+
+// OIDCFromEnv returns a provider for the configuration named by OIDC_FILE, or
+// nil when OIDC login is not configured
+func OIDCFromEnv() (*OIDCProvider, error) {
+	path := os.Getenv("OIDC_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	config, err := LoadOIDCConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewOIDCProvider(config, nil), nil
+}
+
+// role returns the role claims grant according to the configuration
+func (c OIDCConfig) role(claims jwt.MapClaims) string {
+	var values []string
+	switch v := claims[c.RoleClaim].(type) {
+	case string:
+		values = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	for _, rule := range c.Roles {
+		for _, value := range values {
+			if value == rule.Value {
+				return rule.Role
+			}
+		}
+	}
+	return c.DefaultRole
+}
+
+// oidcMetadata is the part of the provider's discovery document the login uses
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider talks to an OpenID Connect identity provider. Its discovery
+// document and keys are fetched when first needed; the keys are fetched again
+// when an ID token names an unknown one.
+type OIDCProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *oidcMetadata
+	keys     map[string]interface{}
+}
+
+// NewOIDCProvider returns a provider for config making requests with client,
+// or http.DefaultClient when client is nil
+func NewOIDCProvider(config OIDCConfig, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &OIDCProvider{config: config, client: client}
+}
+
+func (p *OIDCProvider) getJSON(target string, v interface{}) error {
+	resp, err := p.client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth: GET %s: %s", target, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OIDCProvider) discover() (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	if err := p.getJSON(strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("auth: OIDC discovery names issuer %q, want %q", metadata.Issuer, p.config.Issuer)
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthCodeURL returns the address to send the user to for signing in
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	return metadata.AuthorizationEndpoint + "?" + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for an ID token
+func (p *OIDCProvider) Exchange(code, verifier string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("auth: OIDC token endpoint: %s", resp.Status)
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.IDToken == "" {
+		return "", errors.New("auth: OIDC token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and returns its claims
+func (p *OIDCProvider) Verify(idToken, nonce string, now time.Time) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}))
+	if _, err := parser.ParseWithClaims(idToken, claims, p.keyfunc); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !claims.VerifyIssuer(p.config.Issuer, true):
+		return nil, errors.New("auth: ID token from another issuer")
+	case !claims.VerifyAudience(p.config.ClientID, true):
+		return nil, errors.New("auth: ID token for another client")
+	case !claims.VerifyExpiresAt(now.Unix(), true):
+		return nil, errors.New("auth: ID token expired")
+	case claims["nonce"] != nonce:
+		return nil, errors.New("auth: ID token nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("auth: ID token has no subject")
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	var set JWKS
+	if err := p.getJSON(metadata.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if public, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = public
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// publicKey decodes an RSA or Ed25519 public key
+func (k JWK) publicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("auth: unsupported OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("auth: unsupported key type %q", k.KeyType)
+}
+
+var (
+	errNoEmail    = errors.New("auth: ID token has no email")
+	errEmailTaken = errors.New("auth: email belongs to an account the identity is not linked to")
+	errStaffLink  = errors.New("auth: email belongs to a staff account, which is only linked by its owner")
+)
+
+// OIDCHandler serves the OpenID Connect login
+type OIDCHandler struct {
+	auth     *Handler
+	provider *OIDCProvider
+	oidc     store.OIDCRepository
+}
+
+// NewOIDCHandler returns an OIDCHandler signing users in at provider and
+// starting their sessions through h
+func NewOIDCHandler(h *Handler, provider *OIDCProvider, oidc store.OIDCRepository) *OIDCHandler {
+	return &OIDCHandler{auth: h, provider: provider, oidc: oidc}
+}
+
+// Login handles the request to sign in at the identity provider
+// @Summary Start an OpenID Connect login
+// @Description Redirect to the identity provider to sign in, using the authorization code flow with PKCE.
+// @Description The provider sends the user back to /login/oidc/callback.
+// @Tags auth
+// @Success 302 {string} string "Redirect to the identity provider"
+// @Failure 502 {object} problem.Problem "Identity provider unavailable"
+// @Router /login/oidc [get]
+func (o *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+	o.redirect(w, r, nil)
+}
+
+// Link handles the request of a signed-in account to link an identity at the
+// identity provider to it
+// @Summary Link an OpenID Connect identity
+// @Description Redirect to the identity provider as /login/oidc does, but link the identity the user
+// @Description signs in as to the caller's account instead of signing in with it. Staff accounts are
+// @Description only linked this way. Needs a login, not an API key.
+// @Tags auth
+// @Success 302 {string} string "Redirect to the identity provider"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 502 {object} problem.Problem "Identity provider unavailable"
+// @Router /login/oidc/link [get]
+func (o *OIDCHandler) Link(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	o.redirect(w, r, &claims.UserID)
+}
+
+// redirect records a login and sends the user to sign in at the identity
+// provider. The identity is linked to the account linkUserID when it is set.
+func (o *OIDCHandler) redirect(w http.ResponseWriter, r *http.Request, linkUserID *int) {
+	var secrets [3]string
+	for i := range secrets {
+		token, err := randomToken(32)
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating state")
+			return
+		}
+		secrets[i] = token
+	}
+	state, nonce, verifier := secrets[0], secrets[1], secrets[2]
+
+	target, err := o.provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Printf("OIDC discovery: %v", err)
+		problem.Write(w, r, http.StatusBadGateway, problem.CodeInternal, "Identity provider unavailable")
+		return
+	}
+
+	now := time.Now().UTC()
+	login := models.OIDCLogin{StateHash: hashToken(state), CodeVerifier: verifier, Nonce: nonce, LinkUserID: linkUserID, CreatedAt: now, ExpiresAt: now.Add(OIDCLoginTTL)}
+	if err := o.oidc.CreateLogin(login); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error saving login")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcPath,
+		MaxAge:   int(OIDCLoginTTL.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// Callback handles the identity provider sending the user back
+// @Summary Finish an OpenID Connect login
+// @Description Exchange the authorization code for an ID token and start a session. The first login
+// @Description creates an account from the token's claims, or links an existing patron account with the
+// @Description same verified email. Accounts with two-factor authentication on are refused unless the
+// @Description token's amr claim includes mfa. Logins started at /login/oidc/link link the identity to the
+// @Description caller's account instead. Patrons whose email the provider has not verified are mailed a
+// @Description verification link and refused until they open it. The callback must come back to the
+// @Description browser that started the login. The tokens are returned in the body and set as cookies.
+// @Tags auth
+// @Produce json
+// @Param code query string true "Authorization code"
+// @Param state query string true "State sent to the identity provider"
+// @Success 200 {object} auth.TokenResponse
+// @Failure 400 {object} problem.Problem "Missing code, unknown or expired state, or state of another browser"
+// @Failure 401 {object} problem.Problem "Sign-in refused, ID token invalid or second factor required"
+// @Failure 403 {object} problem.Problem "Email address not verified"
+// @Failure 409 {object} problem.Problem "Email belongs to another account, or identity linked to another account"
+// @Router /login/oidc/callback [get]
+func (o *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Sign-in refused: "+query.Get("error"))
+		return
+	}
+	code, state := query.Get("code"), query.Get("state")
+	if code == "" || state == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing code or state")
+		return
+	}
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Login was not started in this browser")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: oidcPath, MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+
+	now := time.Now().UTC()
+	login, err := o.oidc.ConsumeLogin(hashToken(state), now)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Unknown or expired login")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	idToken, err := o.provider.Exchange(code, login.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange: %v", err)
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Authorization code refused")
+		return
+	}
+	claims, err := o.provider.Verify(idToken, login.Nonce, now)
+	if err != nil {
+		log.Printf("OIDC ID token: %v", err)
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid ID token")
+		return
+	}
+	if login.LinkUserID != nil {
+		o.link(w, r, *login.LinkUserID, claims, now)
+		return
+	}
+
+	user, created, err := o.provision(r, claims, now)
+	switch {
+	case errors.Is(err, errNoEmail):
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "The identity provider did not share an email address")
+		return
+	case errors.Is(err, errEmailTaken):
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "An account with this email exists, log in with its password")
+		return
+	case errors.Is(err, errStaffLink):
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "A staff account with this email exists, log in with its password and link the identity at /login/oidc/link")
+		return
+	case err != nil:
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error provisioning account")
+		return
+	}
+
+	// Patrons the identity provider has not vouched for confirm their address
+	// as registered ones do
+	if mustVerify(user) {
+		if created {
+			if err := o.auth.SendVerification(user); err != nil {
+				log.Printf("verification email for %s: %v", user.Email, err)
+			}
+		}
+		problem.Write(w, r, http.StatusForbidden, problem.CodeEmailUnverified, "Email address not verified")
+		return
+	}
+
+	// The identity provider stands in for the password, not for the second
+	// factor the account has turned on
+	twoFactor := amrHasMFA(claims)
+	if !twoFactor {
+		on, err := o.auth.twoFactorOn(user.ID)
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+			return
+		}
+		if on {
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeTwoFactorRequired, "Two-factor authentication is on for this account, sign in with multi-factor authentication at the identity provider or with the password and code")
+			return
+		}
+	}
+
+	if err := o.auth.startSession(w, r, user, twoFactor); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating token")
+	}
+}
+
+// link links the identity in claims to the account userID, which started the
+// login from /login/oidc/link
+func (o *OIDCHandler) link(w http.ResponseWriter, r *http.Request, userID int, claims jwt.MapClaims, now time.Time) {
+	user, err := o.auth.users.Get(userID, "")
+	if err != nil {
+		problem.StoreError(w, r, err, "User not found")
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+	identity := models.Identity{Issuer: o.provider.config.Issuer, Subject: subject, UserID: user.ID, CreatedAt: now}
+	if linked, err := o.oidc.GetIdentity(identity.Issuer, identity.Subject); err == nil && linked.UserID == user.ID {
+		w.Write([]byte("Identity already linked"))
+		return
+	}
+	err = o.oidc.LinkIdentity(identity)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "The identity is linked to another account")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error linking identity")
+		return
+	}
+	o.auth.audit.RecordAs(r, user, models.AuditCreate, "identity", user.ID, nil, identity)
+	w.Write([]byte("Identity linked"))
+}
+
+// provision returns the account linked to the identity in claims, linking a
+// patron account with the same verified email, which marks it verified, or
+// creating one on first login. Staff accounts are never linked here, as that
+// would let the identity provider stand in for their password. New accounts, links and verifications are recorded in
+// the audit log as made by the account.
+// created reports whether the account was created.
+func (o *OIDCHandler) provision(r *http.Request, claims jwt.MapClaims, now time.Time) (user models.User, created bool, err error) {
+	issuer := o.provider.config.Issuer
+	subject, _ := claims["sub"].(string)
+
+	identity, err := o.oidc.GetIdentity(issuer, subject)
+	if err == nil {
+		user, err = o.auth.users.Get(identity.UserID, "")
+		return user, false, err
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return models.User{}, false, err
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return models.User{}, false, errNoEmail
+	}
+	verified, _ := claims["email_verified"].(bool)
+
+	user, err = o.auth.users.GetByEmail(email)
+	switch {
+	case err == nil && user.Role != "user":
+		return models.User{}, false, errStaffLink
+	case err == nil && !verified:
+		return models.User{}, false, errEmailTaken
+	case err == nil && user.EmailVerifiedAt == nil:
+		// The identity provider vouches for the address the account was
+		// registered with, as the verification link would have
+		before := user
+		if err := o.auth.users.MarkEmailVerified(user.ID, now); err != nil {
+			return models.User{}, false, err
+		}
+		if user, err = o.auth.users.Get(user.ID, ""); err != nil {
+			return models.User{}, false, err
+		}
+		o.auth.audit.RecordAs(r, user, models.AuditUpdate, accountEntity(user.Role), user.ID, before, user)
+	case errors.Is(err, store.ErrNotFound):
+		name, _ := claims["name"].(string)
+		if name == "" {
+			name = email
+		}
+		user = models.User{Name: name, Email: email, MembershipDate: now.Format("2006-01-02"), IsActive: true, Role: o.provider.config.role(claims)}
+		if verified {
+			user.EmailVerifiedAt = &now
+		}
+		if err := o.auth.users.Create(&user); err != nil {
+			if errors.Is(err, store.ErrConflict) {
+				return models.User{}, false, errEmailTaken
+			}
+			return models.User{}, false, err
+		}
+		o.auth.audit.RecordAs(r, user, models.AuditCreate, accountEntity(user.Role), user.ID, nil, user)
+		created = true
+	case err != nil:
+		return models.User{}, false, err
+	}
+
+	link := models.Identity{Issuer: issuer, Subject: subject, UserID: user.ID, CreatedAt: now}
+	if err := o.oidc.LinkIdentity(link); err != nil {
+		return models.User{}, false, err
+	}
+	o.auth.audit.RecordAs(r, user, models.AuditCreate, "identity", user.ID, nil, link)
+	return user, created, nil
+}
+
+// amrHasMFA reports whether the identity provider says the user signed in
+// with more than one factor
+func amrHasMFA(claims jwt.MapClaims) bool {
+	methods, _ := claims["amr"].([]interface{})
+	for _, method := range methods {
+		if method == "mfa" {
+			return true
+		}
+	}
+	return false
+}
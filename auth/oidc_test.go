@@ -0,0 +1,454 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang_project/mail"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+	"golang_project/totp"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testProvider is a stand-in OpenID Connect provider that signs in whoever
+// Claims describes without asking
+type testProvider struct {
+	*httptest.Server
+	keys *KeySet
+
+	mu       sync.Mutex
+	Claims   jwt.MapClaims
+	Audience string
+	codes    map[string]pendingCode
+}
+
+type pendingCode struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(NewRSAKey("idp", private, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &testProvider{keys: keys, codes: make(map[string]pendingCode)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcMetadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(p.keys.JWKS())
+	})
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *testProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != "library" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	code, _ := randomToken(16)
+	p.mu.Lock()
+	p.codes[code] = pendingCode{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), claims: p.Claims}
+	p.mu.Unlock()
+
+	back := query.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, back, http.StatusFound)
+}
+
+func (p *testProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	pending, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	audience := p.Audience
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || base64.RawURLEncoding.EncodeToString(sum[:]) != pending.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	if audience == "" {
+		audience = "library"
+	}
+
+	claims := jwt.MapClaims{"iss": p.URL, "aud": audience, "nonce": pending.nonce, "exp": time.Now().Add(time.Minute).Unix()}
+	for k, v := range pending.claims {
+		claims[k] = v
+	}
+	idToken, err := p.keys.Sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "unused", "token_type": "Bearer", "id_token": idToken})
+}
+
+func newOIDCRouter(t *testing.T, p *testProvider) (http.Handler, *sql.DB) {
+	router, db, _, _ := newAuditedOIDCRouter(t, p)
+	return router, db
+}
+
+// newAuditedOIDCRouter is newOIDCRouter that also returns the changes
+// recorded for the audit log and the mailer emails are sent through
+func newAuditedOIDCRouter(t *testing.T, p *testProvider) (http.Handler, *sql.DB, *memoryAuditor, *mail.MemoryMailer) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	users := store.NewSQLiteUserRepository(db)
+	sessions := store.NewSQLiteSessionRepository(db)
+	auditor := &memoryAuditor{}
+	mailer := &mail.MemoryMailer{}
+	h := NewHandler(users, sessions, store.NewSQLitePasswordResetRepository(db), store.NewSQLiteEmailVerificationRepository(db), store.NewSQLiteTwoFactorRepository(db), store.NewSQLiteAPIKeyRepository(db), NewLimiter(store.NewSQLiteLoginAttemptRepository(db), testThrottle), mailer, "http://library.test", auditor)
+	provider := NewOIDCProvider(OIDCConfig{
+		Issuer:      p.URL,
+		ClientID:    "library",
+		RedirectURL: "http://library.test/login/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+		RoleClaim:   "groups",
+		Roles:       []RoleRule{{Value: "library-staff", Role: "bookkeeper"}},
+		DefaultRole: "user",
+	}, p.Client())
+	o := NewOIDCHandler(h, provider, store.NewSQLiteOIDCRepository(db))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oidc", o.Login)
+	mux.HandleFunc("/login/oidc/callback", o.Callback)
+	mux.Handle("/login/oidc/link", AuthMiddleware(http.HandlerFunc(o.Link)))
+	return mux, db, auditor, mailer
+}
+
+// signIn goes through the login with the stand-in provider and returns the
+// response to the callback, the callback's address and the cookies the login
+// set in the browser
+func signIn(t *testing.T, router http.Handler, p *testProvider) (*httptest.ResponseRecorder, string, []*http.Cookie) {
+	callback, state := startOIDCLogin(t, router, p)
+	return serve(router, "GET", callback, nil, state), callback, state
+}
+
+// startOIDCLogin starts a login and signs in at the stand-in provider,
+// returning the callback's address and the cookies the login set
+func startOIDCLogin(t *testing.T, router http.Handler, p *testProvider) (string, []*http.Cookie) {
+	return authorize(t, p, serve(router, "GET", "/login/oidc", nil, nil))
+}
+
+// authorize follows rr, the redirect starting a login, to the stand-in
+// provider and returns the callback's address and the cookies rr set
+func authorize(t *testing.T, p *testProvider, rr *httptest.ResponseRecorder) (string, []*http.Cookie) {
+	if rr.Code != http.StatusFound {
+		t.Fatalf("start login: got %v want %v", rr.Code, http.StatusFound)
+	}
+	state := rr.Result().Cookies()
+
+	client := p.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	resp, err := client.Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got %v want %v", resp.StatusCode, http.StatusFound)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.RequestURI(), state
+}
+
+func TestOIDCLoginProvisionsAccount(t *testing.T) {
+	p := newTestProvider(t)
+	router, db, auditor, _ := newAuditedOIDCRouter(t, p)
+	users := store.NewSQLiteUserRepository(db)
+
+	p.Claims = jwt.MapClaims{"sub": "s-1", "email": "ada@uni.example", "email_verified": true, "name": "Ada Lovelace", "groups": []string{"students", "library-staff"}}
+	rr, callback, state := signIn(t, router, p)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("callback: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var tokens TokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil || tokens.AccessToken == "" {
+		t.Fatalf("token response: %+v, %v", tokens, err)
+	}
+
+	user, err := users.GetByEmail("ada@uni.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "Ada Lovelace" || user.Role != "bookkeeper" || !user.IsActive || user.EmailVerifiedAt == nil {
+		t.Errorf("unexpected provisioned user %+v", user)
+	}
+	if record, ok := auditor.find(models.AuditCreate, "bookkeeper"); !ok || record.actor != "ada@uni.example" || record.id != user.ID || record.before != nil {
+		t.Errorf("audited account: got %+v", record)
+	}
+	if record, ok := auditor.find(models.AuditCreate, "identity"); !ok || record.id != user.ID || record.after.(models.Identity).Subject != "s-1" {
+		t.Errorf("audited identity: got %+v", record)
+	}
+
+	if rr := serve(router, "GET", callback, nil, state); rr.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	p.Claims = jwt.MapClaims{"sub": "s-1", "email": "ada@elsewhere.example"}
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusOK {
+		t.Fatalf("second login: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if _, err := users.GetByEmail("ada@elsewhere.example"); err == nil {
+		t.Errorf("second login created another account")
+	}
+
+	p.Claims = jwt.MapClaims{"sub": "s-2", "email": "grace@uni.example", "email_verified": true}
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusOK {
+		t.Fatalf("login without groups: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if user, err := users.GetByEmail("grace@uni.example"); err != nil || user.Role != "user" {
+		t.Errorf("default role: got %+v, %v", user, err)
+	}
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	p := newTestProvider(t)
+	router, db := newOIDCRouter(t, p)
+	existing := models.User{Name: "Jane Doe", Email: "jane@uni.example", IsActive: true, Role: "user"}
+	if err := store.NewSQLiteUserRepository(db).Create(&existing); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Claims = jwt.MapClaims{"sub": "s-3", "email": "jane@uni.example", "email_verified": false}
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusConflict {
+		t.Errorf("unverified email of an existing account: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	p.Claims["email_verified"] = true
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusOK {
+		t.Fatalf("verified email of an existing account: got %v want %v", rr.Code, http.StatusOK)
+	}
+	identity, err := store.NewSQLiteOIDCRepository(db).GetIdentity(p.URL, "s-3")
+	if err != nil || identity.UserID != existing.ID {
+		t.Errorf("linked identity: got %+v, %v want user %d", identity, err, existing.ID)
+	}
+	if user, err := store.NewSQLiteUserRepository(db).Get(existing.ID, ""); err != nil || user.EmailVerifiedAt == nil {
+		t.Errorf("linked account: got %+v, %v want it verified", user, err)
+	}
+}
+
+func TestOIDCLoginLinksStaffOnlyOnRequest(t *testing.T) {
+	p := newTestProvider(t)
+	router, db, auditor, _ := newAuditedOIDCRouter(t, p)
+	users := store.NewSQLiteUserRepository(db)
+	now := time.Now().UTC()
+	staff := models.User{Name: "Kim Park", Email: "kim@uni.example", IsActive: true, Role: "bookkeeper", EmailVerifiedAt: &now}
+	if err := users.Create(&staff); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Claims = jwt.MapClaims{"sub": "s-7", "email": "kim@uni.example", "email_verified": true}
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusConflict {
+		t.Errorf("verified email of a staff account: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if _, err := store.NewSQLiteOIDCRepository(db).GetIdentity(p.URL, "s-7"); err == nil {
+		t.Fatalf("identity linked to a staff account by email")
+	}
+
+	if rr := serve(router, "GET", "/login/oidc/link", nil, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("link without a login: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	token, _, err := IssueToken(staff, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedIn := []*http.Cookie{{Name: "token", Value: token}}
+	callback, state := authorize(t, p, serve(router, "GET", "/login/oidc/link", nil, signedIn))
+	if rr := serve(router, "GET", callback, nil, state); rr.Code != http.StatusOK {
+		t.Fatalf("link: got %v %s want %v", rr.Code, rr.Body.String(), http.StatusOK)
+	}
+	if identity, err := store.NewSQLiteOIDCRepository(db).GetIdentity(p.URL, "s-7"); err != nil || identity.UserID != staff.ID {
+		t.Errorf("linked identity: got %+v, %v want user %d", identity, err, staff.ID)
+	}
+	if record, ok := auditor.find(models.AuditCreate, "identity"); !ok || record.actor != "kim@uni.example" || record.id != staff.ID {
+		t.Errorf("audited link: got %+v", record)
+	}
+
+	other := models.User{Name: "Lee Park", Email: "lee@uni.example", IsActive: true, Role: "bookkeeper", EmailVerifiedAt: &now}
+	if err := users.Create(&other); err != nil {
+		t.Fatal(err)
+	}
+	if token, _, err = IssueToken(other, ""); err != nil {
+		t.Fatal(err)
+	}
+	callback, state = authorize(t, p, serve(router, "GET", "/login/oidc/link", nil, []*http.Cookie{{Name: "token", Value: token}}))
+	if rr := serve(router, "GET", callback, nil, state); rr.Code != http.StatusConflict {
+		t.Errorf("link an identity linked to another account: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusOK {
+		t.Errorf("login with the linked identity: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestOIDCLoginRequiresSecondFactor(t *testing.T) {
+	p := newTestProvider(t)
+	router, db := newOIDCRouter(t, p)
+	p.Claims = jwt.MapClaims{"sub": "s-8", "email": "max@uni.example", "email_verified": true}
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusOK {
+		t.Fatalf("first login: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	user, err := store.NewSQLiteUserRepository(db).GetByEmail("max@uni.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoFactor := store.NewSQLiteTwoFactorRepository(db)
+	if err := twoFactor.Begin(user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := twoFactor.Confirm(user.ID, totp.Step(time.Now()), time.Now().UTC(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	rr, _, _ := signIn(t, router, p)
+	if rr.Code != http.StatusUnauthorized || problemCode(t, rr) != problem.CodeTwoFactorRequired {
+		t.Errorf("login without mfa: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	p.Claims["amr"] = []string{"pwd", "otp", "mfa"}
+	rr, _, _ = signIn(t, router, p)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login with mfa: got %v want %v", rr.Code, http.StatusOK)
+	}
+	token, _ := cookies(t, rr)
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(token[0].Value, claims, keys.Load().Keyfunc); err != nil || !claims.TwoFactor {
+		t.Errorf("session of an mfa login: got %+v, %v want it two-factor", claims, err)
+	}
+}
+
+func TestOIDCLoginRequiresVerifiedPatrons(t *testing.T) {
+	p := newTestProvider(t)
+	router, db, _, mailer := newAuditedOIDCRouter(t, p)
+	users := store.NewSQLiteUserRepository(db)
+
+	p.Claims = jwt.MapClaims{"sub": "s-5", "email": "bob@uni.example", "email_verified": false}
+	rr, _, _ := signIn(t, router, p)
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), string(problem.CodeEmailUnverified)) {
+		t.Fatalf("unverified patron: got %v %s want %v", rr.Code, rr.Body.String(), http.StatusForbidden)
+	}
+	for _, c := range rr.Result().Cookies() {
+		if c.Name != oidcStateCookie {
+			t.Errorf("unverified patron got a session: %v", rr.Header().Values("Set-Cookie"))
+		}
+	}
+	user, err := users.GetByEmail("bob@uni.example")
+	if err != nil || user.EmailVerifiedAt != nil {
+		t.Fatalf("provisioned patron: got %+v, %v want it unverified", user, err)
+	}
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To != "bob@uni.example" || !strings.Contains(messages[0].Body, "/users/verify?token=") {
+		t.Fatalf("expected a verification email: %+v", messages)
+	}
+
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusForbidden {
+		t.Errorf("second unverified login: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if messages := mailer.Messages(); len(messages) != 1 {
+		t.Errorf("expected no email on later logins, got %d", len(messages))
+	}
+
+	if err := users.MarkEmailVerified(user.ID, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusOK {
+		t.Errorf("login after verification: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	p := newTestProvider(t)
+	router, _ := newOIDCRouter(t, p)
+	p.Claims = jwt.MapClaims{"sub": "s-6", "email": "mallory@uni.example", "email_verified": true}
+
+	callback, state := startOIDCLogin(t, router, p)
+	if len(state) != 1 || state[0].Name != oidcStateCookie || !state[0].HttpOnly || state[0].Path != "/login/oidc" || state[0].SameSite != http.SameSiteLaxMode {
+		t.Fatalf("state cookie: got %+v", state)
+	}
+
+	if rr := serve(router, "GET", callback, nil, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("callback without the state cookie: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	_, other := startOIDCLogin(t, router, p)
+	if rr := serve(router, "GET", callback, nil, other); rr.Code != http.StatusBadRequest {
+		t.Errorf("callback with another login's state cookie: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	rr := serve(router, "GET", callback, nil, state)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("callback in the browser that started the login: got %v want %v", rr.Code, http.StatusOK)
+	}
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == oidcStateCookie && c.MaxAge >= 0 {
+			t.Errorf("state cookie not cleared: %+v", c)
+		}
+	}
+}
+
+func TestOIDCLoginRejectsInvalidTokens(t *testing.T) {
+	p := newTestProvider(t)
+	router, _ := newOIDCRouter(t, p)
+
+	p.Claims = jwt.MapClaims{"sub": "s-4", "email": "eve@uni.example", "email_verified": true}
+	p.Audience = "another-client"
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusUnauthorized {
+		t.Errorf("token for another client: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	p.Audience = ""
+	delete(p.Claims, "email")
+	if rr, _, _ := signIn(t, router, p); rr.Code != http.StatusUnauthorized {
+		t.Errorf("token without email: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	unknown := []*http.Cookie{{Name: oidcStateCookie, Value: "unknown"}}
+	if rr := serve(router, "GET", "/login/oidc/callback?code=x&state=unknown", nil, unknown); rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown state: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := serve(router, "GET", "/login/oidc/callback?error=access_denied", nil, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refused sign-in: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
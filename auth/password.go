@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang_project/mail"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ResetTTL is how long a password reset link stays valid
+const ResetTTL = time.Hour
+
+// MinPasswordLength is the shortest password accepted when one is changed
+const MinPasswordLength = 8
+
+// EmailRequest names the account a password reset or verification link is mailed to
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetConfirmation sets a new password with a reset token
+type ResetConfirmation struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// PasswordChange replaces the caller's password
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// passwordState is what the audit log shows of a new password: how and when
+// it was set. Passwords and their hashes are never logged.
+type passwordState struct {
+	Method    string    `json:"method"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// resetForm is the page a reset link opens. It posts the token and the new
+// password to ConfirmPasswordReset.
+var resetForm = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Reset your library password</title>
+</head>
+<body>
+<h1>Reset your library password</h1>
+<form method="post" action="/password/reset/confirm">
+<input type="hidden" name="token" value="{{.Token}}">
+<label>New password <input type="password" name="password" minlength="{{.MinLength}}" autocomplete="new-password" required></label>
+<button type="submit">Set password</button>
+</form>
+</body>
+</html>
+`))
+
+func validPassword(password string) bool {
+	return len(password) >= MinPasswordLength
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// RequestPasswordReset handles the request to email a password reset link
+// @Summary Request a password reset
+// @Description Email a single-use link to reset the password of the account registered with the email.
+// @Description The response is the same whether or not the account exists.
+// @Tags auth
+// @Accept json
+// @Produce plain
+// @Param request body auth.EmailRequest true "Account email"
+// @Success 202 {string} string "If the account exists, a reset link has been sent"
+// @Failure 400 {object} problem.Problem "Invalid request payload"
+// @Router /password/reset/request [post]
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	user, err := h.users.GetByEmail(req.Email)
+	if err == nil {
+		err = h.sendResetLink(user)
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("password reset for %s: %v", req.Email, err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the account exists, a reset link has been sent"))
+}
+
+func (h *Handler) sendResetLink(user models.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	reset := models.OneTimeToken{TokenHash: hashToken(token), UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(ResetTTL)}
+	if err := h.resets.Create(&reset); err != nil {
+		return err
+	}
+
+	link := h.appURL + "/password/reset?token=" + url.QueryEscape(token)
+	return h.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your library password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your library account.\n\n"+
+			"To choose a new password, open:\n\n%s\n\n"+
+			"The link can be used once and expires in %d minutes. If you did not ask for this, you can ignore this email.\n",
+			link, int(ResetTTL.Minutes())),
+	})
+}
+
+// ResetPasswordForm handles the page a password reset link opens
+// @Summary Password reset form
+// @Description The page the emailed reset link opens: a form asking for the new password, which it
+// @Description posts with the token to /password/reset/confirm.
+// @Tags auth
+// @Produce html
+// @Param token query string true "Reset token from the emailed link"
+// @Success 200 {string} string "Password reset form"
+// @Failure 400 {object} problem.Problem "Missing reset token"
+// @Router /password/reset [get]
+func (h *Handler) ResetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing reset token")
+		return
+	}
+
+	// The token is in the URL, so the page must not be cached or leak it to other sites
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	resetForm.Execute(w, struct {
+		Token     string
+		MinLength int
+	}{token, MinPasswordLength})
+}
+
+// ConfirmPasswordReset handles the request to set a new password with a reset token
+// @Summary Reset a password
+// @Description Set a new password with the token from a reset link. The token can be used once.
+// @Description Every session of the account is signed out. The token and password may also be
+// @Description posted as a form, as the page at /password/reset does.
+// @Tags auth
+// @Accept json,x-www-form-urlencoded
+// @Produce plain
+// @Param request body auth.ResetConfirmation true "Reset token and new password"
+// @Success 200 {string} string "Password updated"
+// @Failure 400 {object} problem.Problem "Invalid or expired token, or password too short"
+// @Router /password/reset/confirm [post]
+func (h *Handler) ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req ResetConfirmation
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		req = ResetConfirmation{Token: r.PostFormValue("token"), Password: r.PostFormValue("password")}
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&req)
+	}
+	if err != nil || req.Token == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	if !validPassword(req.Password) {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, fmt.Sprintf("Password must be at least %d characters", MinPasswordLength))
+		return
+	}
+
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error hashing password")
+		return
+	}
+
+	now := time.Now().UTC()
+	userID, err := h.resets.Consume(hashToken(req.Token), now)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	if err := h.setPassword(userID, hash, "", now); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating password")
+		return
+	}
+	if user, err := h.users.Get(userID, ""); err == nil {
+		h.audit.RecordAs(r, user, models.AuditUpdate, "password", userID, nil, passwordState{Method: "reset", ChangedAt: now})
+	}
+	w.Write([]byte("Password updated"))
+}
+
+// ChangePassword handles the request to replace the caller's password
+// @Summary Change my password
+// @Description Replace the logged-in account's password. Every other session of the account is signed out.
+// @Tags auth
+// @Accept json
+// @Produce plain
+// @Param request body auth.PasswordChange true "Current and new password"
+// @Success 200 {string} string "Password updated"
+// @Failure 400 {object} problem.Problem "Password too short"
+// @Failure 401 {object} problem.Problem "Wrong current password"
+// @Router /password/change [post]
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req PasswordChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+	if !validPassword(req.NewPassword) {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, fmt.Sprintf("Password must be at least %d characters", MinPasswordLength))
+		return
+	}
+
+	user, err := h.users.Get(claims.UserID, "")
+	if err != nil {
+		problem.StoreError(w, r, err, "User not found")
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)) != nil {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid credentials")
+		return
+	}
+
+	hash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error hashing password")
+		return
+	}
+	// The caller proved they know the password, so their own session survives
+	now := time.Now().UTC()
+	if err := h.setPassword(user.ID, hash, claims.ID, now); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating password")
+		return
+	}
+	h.audit.Record(r, models.AuditUpdate, "password", user.ID, nil, passwordState{Method: "change", ChangedAt: now})
+	w.Write([]byte("Password updated"))
+}
+
+// setPassword stores a new password hash and signs out every session of the
+// user but keepSession
+func (h *Handler) setPassword(userID int, hash, keepSession string, at time.Time) error {
+	if err := h.users.SetPassword(userID, hash); err != nil {
+		return err
+	}
+	return h.sessions.RevokeAllForUser(userID, keepSession, at)
+}
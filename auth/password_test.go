@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang_project/models"
+)
+
+func postJSON(t *testing.T, router http.Handler, target string, body interface{}, cookies []*http.Cookie) int {
+	jsonPayload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serve(router, "POST", target, bytes.NewBuffer(jsonPayload), cookies).Code
+}
+
+// resetToken returns the token of the reset link in the last mailed message
+func resetToken(t *testing.T, body string) string {
+	start := strings.Index(body, "http://")
+	if start < 0 {
+		t.Fatalf("no link in %q", body)
+	}
+	link, err := url.Parse(strings.Fields(body[start:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Path != "/password/reset" {
+		t.Errorf("unexpected reset link %s", link)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordReset(t *testing.T) {
+	router, mailer, auditor := newAuditedSessionRouter(t)
+	token, _ := login(t, router)
+
+	if code := postJSON(t, router, "/password/reset/request", EmailRequest{Email: "nobody@example.com"}, nil); code != http.StatusAccepted {
+		t.Errorf("unknown email: got %v want %v", code, http.StatusAccepted)
+	}
+	if code := postJSON(t, router, "/password/reset/request", EmailRequest{Email: "amir@gmail.com"}, nil); code != http.StatusAccepted {
+		t.Fatalf("request: got %v want %v", code, http.StatusAccepted)
+	}
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To != "amir@gmail.com" {
+		t.Fatalf("expected one reset email to the account: %+v", messages)
+	}
+	reset := resetToken(t, messages[0].Body)
+
+	if code := postJSON(t, router, "/password/reset/confirm", ResetConfirmation{Token: reset, Password: "short"}, nil); code != http.StatusBadRequest {
+		t.Errorf("short password: got %v want %v", code, http.StatusBadRequest)
+	}
+	if code := postJSON(t, router, "/password/reset/confirm", ResetConfirmation{Token: reset, Password: "correct horse"}, nil); code != http.StatusOK {
+		t.Fatalf("confirm: got %v want %v", code, http.StatusOK)
+	}
+	if code := postJSON(t, router, "/password/reset/confirm", ResetConfirmation{Token: reset, Password: "battery staple"}, nil); code != http.StatusBadRequest {
+		t.Errorf("reused token: got %v want %v", code, http.StatusBadRequest)
+	}
+	if record, ok := auditor.find(models.AuditUpdate, "password"); !ok || record.actor != "amir@gmail.com" || record.id != 1 || record.after.(passwordState).Method != "reset" {
+		t.Errorf("audited reset: got %+v", record)
+	}
+
+	if rr := serve(router, "GET", "/sessions", nil, token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("session from before the reset: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if code := postJSON(t, router, "/login/bookkeepers", Credentials{Username: "amir@gmail.com", Password: "correct horse"}, nil); code != http.StatusOK {
+		t.Errorf("login with new password: got %v want %v", code, http.StatusOK)
+	}
+}
+
+func TestResetPasswordForm(t *testing.T) {
+	router, _ := newSessionRouter(t)
+
+	rr := serve(router, "GET", "/password/reset?token=%3Cscript%3E", nil, nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("form: got %v with Cache-Control %q", rr.Code, rr.Header().Get("Cache-Control"))
+	}
+	if body := rr.Body.String(); strings.Contains(body, "<script>") || !strings.Contains(body, `value="&lt;script&gt;"`) {
+		t.Errorf("expected the token to be escaped: %s", body)
+	}
+	if rr := serve(router, "GET", "/password/reset", nil, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("missing token: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := serve(router, "POST", "/password/reset?token=abc", nil, nil); rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("post: got %v want %v", rr.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	router, _, auditor := newAuditedSessionRouter(t)
+	laptop, _ := login(t, router)
+	phone, _ := login(t, router)
+
+	if code := postJSON(t, router, "/password/change", PasswordChange{CurrentPassword: "wrong", NewPassword: "correct horse"}, laptop); code != http.StatusUnauthorized {
+		t.Errorf("wrong current password: got %v want %v", code, http.StatusUnauthorized)
+	}
+	if code := postJSON(t, router, "/password/change", PasswordChange{CurrentPassword: "1234", NewPassword: "correct horse"}, laptop); code != http.StatusOK {
+		t.Fatalf("change: got %v want %v", code, http.StatusOK)
+	}
+	if record, ok := auditor.find(models.AuditUpdate, "password"); !ok || record.actor != "amir@gmail.com" || record.after.(passwordState).Method != "change" {
+		t.Errorf("audited change: got %+v", record)
+	}
+
+	if rr := serve(router, "GET", "/sessions", nil, laptop); rr.Code != http.StatusOK {
+		t.Errorf("session that changed the password: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serve(router, "GET", "/sessions", nil, phone); rr.Code != http.StatusUnauthorized {
+		t.Errorf("other session: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"golang_project/problem"
+)
+
+// Permission names an action a role may perform
+type Permission string
+
+const (
+	// PermAccountRead allows viewing the caller's own account pages
+	PermAccountRead Permission = "account:read"
+	// PermBooksWrite allows creating, updating and deleting books
+	PermBooksWrite Permission = "books:write"
+	// PermFinesWrite allows viewing any patron's fines and recording payments and waivers
+	PermFinesWrite Permission = "fines:write"
+	// PermHoldsWrite allows placing and cancelling the caller's own holds
+	PermHoldsWrite Permission = "holds:write"
+	// PermLoansRenew allows renewing the caller's own loans
+	PermLoansRenew Permission = "loans:renew"
+	// PermLoansWrite allows lending and receiving books for any patron
+	PermLoansWrite Permission = "loans:write"
+	// PermUsersWrite allows updating and deleting patrons
+	PermUsersWrite Permission = "users:write"
+	// PermUsersAdmin allows managing bookkeepers and the admin pages
+	PermUsersAdmin Permission = "users:admin"
+	// PermAuditRead allows reading the audit log
+	PermAuditRead Permission = "audit:read"
+	// PermAPIKeysWrite allows creating, listing and revoking API keys
+	PermAPIKeysWrite Permission = "apikeys:write"
+	// PermTwoFactorRequired grants nothing. Roles holding it must log in with a
+	// second factor before any permission of theirs is honoured.
+	PermTwoFactorRequired Permission = "2fa:required"
+)
+
+// Policy maps a role to the permissions it is granted
+type Policy map[string][]Permission
+
+// DefaultPolicy is used when no permissions file is configured
+var DefaultPolicy = Policy{
+	"admin":      {PermAccountRead, PermBooksWrite, PermFinesWrite, PermHoldsWrite, PermLoansRenew, PermLoansWrite, PermUsersWrite, PermUsersAdmin, PermAuditRead, PermAPIKeysWrite},
+	"bookkeeper": {PermAccountRead, PermBooksWrite, PermFinesWrite, PermHoldsWrite, PermLoansRenew, PermLoansWrite, PermUsersWrite, PermAuditRead, PermAPIKeysWrite},
+	"user":       {PermAccountRead, PermHoldsWrite, PermLoansRenew},
+}
+
+// LoadPolicy reads a JSON object of role to permission list from path
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// PolicyFromEnv loads the policy named by PERMISSIONS_FILE, falling back to DefaultPolicy
+func PolicyFromEnv() (Policy, error) {
+	path := os.Getenv("PERMISSIONS_FILE")
+	if path == "" {
+		return DefaultPolicy, nil
+	}
+	return LoadPolicy(path)
+}
+
+// Allows reports whether role holds every permission in perms
+func (p Policy) Allows(role string, perms ...Permission) bool {
+	for _, perm := range perms {
+		granted := false
+		for _, held := range p[role] {
+			if held == perm {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
+}
+
+// RequirePermission returns a middleware that authenticates the request and
+// rejects it with 403 unless the caller's role holds every permission in perms,
+// and so does the API key if one was used. Sessions must have used a second
+// factor if the role requires one.
+func (p Policy) RequirePermission(perms ...Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok || !p.Allows(claims.Role, perms...) || !claims.scoped(perms...) {
+				problem.Write(w, r, http.StatusForbidden, problem.CodeForbidden, "Forbidden")
+				return
+			}
+			if claims.APIKeyID == 0 && !claims.TwoFactor && p.Allows(claims.Role, PermTwoFactorRequired) {
+				problem.Write(w, r, http.StatusForbidden, problem.CodeTwoFactorRequired, "Your role requires two-factor authentication")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// scoped reports whether the API key the request used, if any, holds every
+// permission in perms
+func (c *Claims) scoped(perms ...Permission) bool {
+	if c.APIKeyID == 0 {
+		return true
+	}
+	return Policy{"": c.Scopes}.Allows("", perms...)
+}
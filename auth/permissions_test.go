@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "permissions.json")
+	err := os.WriteFile(path, []byte(`{"librarian": ["books:write"], "user": []}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !policy.Allows("librarian", PermBooksWrite) {
+		t.Errorf("expected librarian to hold %s", PermBooksWrite)
+	}
+	if policy.Allows("librarian", PermBooksWrite, PermUsersAdmin) {
+		t.Errorf("expected librarian not to hold %s", PermUsersAdmin)
+	}
+	if policy.Allows("admin", PermBooksWrite) {
+		t.Errorf("expected roles missing from the file to hold no permissions")
+	}
+}
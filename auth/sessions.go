@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// RefreshTTL is how long a session lasts without being refreshed
+const RefreshTTL = 30 * 24 * time.Hour
+
+// refreshCookie holds the refresh token. It is only sent to /refresh.
+const (
+	refreshCookie = "refresh_token"
+	refreshPath   = "/refresh"
+)
+
+// TokenResponse is returned by the login and refresh endpoints
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshRequest carries a refresh token for clients that do not keep cookies
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// WithSessions returns a middleware that lets AuthMiddleware check tokens
+// against sessions, so that logged out and revoked sessions stop working
+// before their access token expires
+func WithSessions(sessions store.SessionRepository) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), sessionsKey, sessions)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// sessionActive reports whether the session with the given ID exists and has
+// been neither revoked nor left to expire
+func sessionActive(sessions store.SessionRepository, id string) bool {
+	if id == "" {
+		return false
+	}
+	session, err := sessions.Get(id)
+	if err != nil {
+		return false
+	}
+	return session.RevokedAt == nil && time.Now().UTC().Before(session.ExpiresAt)
+}
+
+// hashToken returns the hex SHA-256 digest under which a token is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomToken returns n random bytes encoded for use in a cookie or URL
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// startSession records a new session for user, sets its token cookies and
+// writes the tokens as a TokenResponse
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user models.User, twoFactor bool) error {
+	id, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	refresh, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	session := models.Session{
+		ID:          id,
+		UserID:      user.ID,
+		RefreshHash: hashToken(refresh),
+		UserAgent:   r.UserAgent(),
+		IP:          ClientIP(r),
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(RefreshTTL),
+		TwoFactor:   twoFactor,
+	}
+	if err := h.sessions.Create(&session); err != nil {
+		return err
+	}
+	return writeTokens(w, r, user, session, refresh, true)
+}
+
+// writeTokens issues an access token for the session and writes it with the
+// refresh token, setting them as cookies too when setCookies is true
+func writeTokens(w http.ResponseWriter, r *http.Request, user models.User, session models.Session, refresh string, setCookies bool) error {
+	tokenString, expirationTime, err := issueToken(user, session.ID, session.TwoFactor)
+	if err != nil {
+		return err
+	}
+
+	if setCookies {
+		if err := setTokenCookies(w, r, tokenString, expirationTime, refresh, session.ExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	return json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:  tokenString,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(tokenTTL.Seconds()),
+		RefreshToken: refresh,
+	})
+}
+
+// setTokenCookies sets the access and refresh tokens and a new CSRF token as
+// cookies. Scripts can only read the CSRF token, and over TLS the cookies are
+// only sent back over TLS.
+func setTokenCookies(w http.ResponseWriter, r *http.Request, tokenString string, expirationTime time.Time, refresh string, refreshExpires time.Time) error {
+	csrf, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	secure := r.TLS != nil
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    tokenString,
+		Expires:  expirationTime,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    refresh,
+		Path:     refreshPath,
+		Expires:  refreshExpires,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:    csrfCookie,
+		Value:   csrf,
+		Path:    "/",
+		Expires: refreshExpires,
+		Secure:  secure,
+	})
+	return nil
+}
+
+func clearTokenCookies(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{Name: "token", Value: "", MaxAge: -1, HttpOnly: true})
+	http.SetCookie(w, &http.Cookie{Name: refreshCookie, Value: "", Path: refreshPath, MaxAge: -1, HttpOnly: true})
+	http.SetCookie(w, &http.Cookie{Name: csrfCookie, Value: "", Path: "/", MaxAge: -1})
+}
+
+// ClientIP returns the address r came from, without its port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Refresh handles the request to exchange a refresh token for a new access token
+// @Summary Refresh the access token
+// @Description Exchange a refresh token for a new access token. The refresh token is read from the body,
+// @Description or else from the refresh_token cookie, which requires the CSRF header. The refresh token is
+// @Description rotated: the old one stops working and a new one is returned, extending the session.
+// @Description Presenting a rotated refresh token again revokes the session.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body auth.RefreshRequest false "Refresh token, when not sent as a cookie"
+// @Success 200 {object} auth.TokenResponse
+// @Failure 401 {object} problem.Problem "Missing, unknown, revoked or expired refresh token"
+// @Failure 403 {object} problem.Problem "CSRF check failed"
+// @Router /refresh [post]
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req RefreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+			return
+		}
+	}
+	fromCookie := false
+	if req.RefreshToken == "" {
+		c, err := r.Cookie(refreshCookie)
+		if err != nil {
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Missing refresh token")
+			return
+		}
+		if !csrfValid(r) {
+			problem.Write(w, r, http.StatusForbidden, problem.CodeCSRFFailed, "Missing or invalid CSRF token")
+			return
+		}
+		req.RefreshToken, fromCookie = c.Value, true
+	}
+
+	now := time.Now().UTC()
+	hash := hashToken(req.RefreshToken)
+	session, err := h.sessions.GetByRefreshHash(hash)
+	if errors.Is(err, store.ErrNotFound) {
+		// A refresh token that was already rotated away is being replayed, so
+		// whoever holds the current one may have stolen it
+		if err := h.sessions.RevokeRotated(hash, now); err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("revoking the session of a replayed refresh token: %v", err)
+		}
+	}
+	if err != nil || session.RevokedAt != nil || !now.Before(session.ExpiresAt) {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	user, err := h.users.Get(session.UserID, "")
+	if err != nil {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Invalid refresh token")
+		return
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating token")
+		return
+	}
+	expiresAt := now.Add(RefreshTTL)
+	err = h.sessions.Rotate(session.ID, hash, hashToken(refresh), now, expiresAt)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Invalid refresh token")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	session.ExpiresAt = expiresAt
+	if err := writeTokens(w, r, user, session, refresh, fromCookie); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating token")
+	}
+}
+
+// Logout handles the request to end the caller's session
+// @Summary Log out
+// @Description Revoke the current session and clear the token cookies
+// @Tags auth
+// @Produce plain
+// @Success 200 {string} string "Logged out"
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Router /logout [post]
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := h.sessions.Revoke(claims.ID, time.Now().UTC()); err != nil && !errors.Is(err, store.ErrNotFound) {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error revoking session")
+		return
+	}
+	clearTokenCookies(w)
+	w.Write([]byte("Logged out"))
+}
+
+// ListSessions handles the request to list the caller's active sessions
+// @Summary List my sessions
+// @Description Get the logged-in account's sessions that are neither revoked nor expired,
+// @Description most recently used first. The session making the request is marked current.
+// @Tags auth
+// @Produce json
+// @Success 200 {array} models.Session
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Router /sessions [get]
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessions, err := h.sessions.ListActiveByUser(claims.UserID, time.Now().UTC())
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RevokeSession handles the request to end one of the caller's sessions
+// @Summary Revoke a session
+// @Description Revoke one of the logged-in account's sessions, signing that device out
+// @Tags auth
+// @Produce plain
+// @Param id query string true "Session ID"
+// @Success 200 {string} string "Session revoked"
+// @Failure 400 {object} problem.Problem "Missing session ID"
+// @Failure 404 {object} problem.Problem "Session not found"
+// @Router /sessions/revoke [post]
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing session ID")
+		return
+	}
+
+	session, err := h.sessions.Get(id)
+	if err == nil && session.UserID != claims.UserID {
+		err = store.ErrNotFound
+	}
+	if err == nil {
+		err = h.sessions.Revoke(id, time.Now().UTC())
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, "Session not found")
+		return
+	}
+	if id == claims.ID {
+		clearTokenCookies(w)
+	}
+	w.Write([]byte("Session revoked"))
+}
@@ -0,0 +1,392 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang_project/mail"
+	"golang_project/models"
+	"golang_project/store"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// auditRecord is a change recorded through memoryAuditor
+type auditRecord struct {
+	actor          string
+	action, entity string
+	id             int
+	before, after  interface{}
+}
+
+// memoryAuditor keeps the changes the handlers under test record
+type memoryAuditor struct {
+	mu      sync.Mutex
+	records []auditRecord
+}
+
+func (a *memoryAuditor) Record(r *http.Request, action, entity string, id int, before, after interface{}) {
+	var actor string
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		actor = claims.Username
+	}
+	a.add(auditRecord{actor: actor, action: action, entity: entity, id: id, before: before, after: after})
+}
+
+func (a *memoryAuditor) RecordAs(r *http.Request, user models.User, action, entity string, id int, before, after interface{}) {
+	a.add(auditRecord{actor: user.Email, action: action, entity: entity, id: id, before: before, after: after})
+}
+
+func (a *memoryAuditor) add(record auditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.records = append(a.records, record)
+}
+
+// find returns the last record of action on entity
+func (a *memoryAuditor) find(action, entity string) (auditRecord, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := len(a.records) - 1; i >= 0; i-- {
+		if a.records[i].action == action && a.records[i].entity == entity {
+			return a.records[i], true
+		}
+	}
+	return auditRecord{}, false
+}
+
+// newSessionRouter serves the login, session and password endpoints over a
+// fresh database holding a single bookkeeper, mailing through the returned mailer
+func newSessionRouter(t *testing.T) (http.Handler, *mail.MemoryMailer) {
+	router, mailer, _ := newAuditedSessionRouter(t)
+	return router, mailer
+}
+
+// newAuditedSessionRouter is newSessionRouter that also returns the changes
+// recorded for the audit log
+func newAuditedSessionRouter(t *testing.T) (http.Handler, *mail.MemoryMailer, *memoryAuditor) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	users := store.NewSQLiteUserRepository(db)
+	sessions := store.NewSQLiteSessionRepository(db)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookkeeper := models.User{Name: "amir rahimi", Email: "amir@gmail.com", IsActive: true, Password: string(hashedPassword), Role: "admin"}
+	if err := users.Create(&bookkeeper); err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := &mail.MemoryMailer{}
+	auditor := &memoryAuditor{}
+	h := NewHandler(users, sessions, store.NewSQLitePasswordResetRepository(db), store.NewSQLiteEmailVerificationRepository(db), store.NewSQLiteTwoFactorRepository(db), store.NewSQLiteAPIKeyRepository(db), NewLimiter(store.NewSQLiteLoginAttemptRepository(db), testThrottle), mailer, "http://localhost:9000/", auditor)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/bookkeepers", h.Token)
+	mux.HandleFunc("/refresh", h.Refresh)
+	mux.Handle("/logout", AuthMiddleware(http.HandlerFunc(h.Logout)))
+	mux.Handle("/sessions", AuthMiddleware(http.HandlerFunc(h.ListSessions)))
+	mux.Handle("/sessions/revoke", AuthMiddleware(http.HandlerFunc(h.RevokeSession)))
+	mux.HandleFunc("/password/reset", h.ResetPasswordForm)
+	mux.HandleFunc("/password/reset/request", h.RequestPasswordReset)
+	mux.HandleFunc("/password/reset/confirm", h.ConfirmPasswordReset)
+	mux.Handle("/password/change", AuthMiddleware(http.HandlerFunc(h.ChangePassword)))
+	mux.Handle("/2fa/enroll", AuthMiddleware(http.HandlerFunc(h.EnrollTwoFactor)))
+	mux.Handle("/2fa/confirm", AuthMiddleware(http.HandlerFunc(h.ConfirmTwoFactor)))
+	mux.Handle("/2fa/disable", AuthMiddleware(http.HandlerFunc(h.DisableTwoFactor)))
+	mux.Handle("/2fa/reset", AuthMiddleware(http.HandlerFunc(h.ResetTwoFactor)))
+	mux.Handle("/users/unlock", AuthMiddleware(http.HandlerFunc(h.UnlockAccount)))
+	mux.Handle("/login/failures", AuthMiddleware(http.HandlerFunc(h.ListLoginFailures)))
+	mux.Handle("/user", twoFactorPolicy.RequirePermission(PermAccountRead)(http.HandlerFunc(UserHandler)))
+	return WithSessions(sessions)(mux), mailer, auditor
+}
+
+// login returns the token and refresh token cookies of a new session, each
+// paired with its CSRF cookie
+func login(t *testing.T, router http.Handler) (token, refresh []*http.Cookie) {
+	jsonPayload, err := json.Marshal(Credentials{Username: "amir@gmail.com", Password: "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := serve(router, "POST", "/login/bookkeepers", bytes.NewBuffer(jsonPayload), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login: got %v want %v", rr.Code, http.StatusOK)
+	}
+	return cookies(t, rr)
+}
+
+func cookies(t *testing.T, rr *httptest.ResponseRecorder) (token, refresh []*http.Cookie) {
+	var csrf *http.Cookie
+	for _, cookie := range rr.Result().Cookies() {
+		switch cookie.Name {
+		case "token":
+			token = append(token, cookie)
+		case refreshCookie:
+			refresh = append(refresh, cookie)
+		case csrfCookie:
+			csrf = cookie
+		}
+	}
+	if token == nil || refresh == nil || csrf == nil {
+		t.Fatalf("token cookies not found: %v", rr.Result().Cookies())
+	}
+	return append(token, csrf), append(refresh, csrf)
+}
+
+// serve sends a request with cookies, echoing the CSRF cookie in CSRFHeader
+func serve(router http.Handler, method, target string, body *bytes.Buffer, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	if body == nil {
+		body = &bytes.Buffer{}
+	}
+	req := httptest.NewRequest(method, target, body)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+		if cookie.Name == csrfCookie {
+			req.Header.Set(CSRFHeader, cookie.Value)
+		}
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTokenCookieAttributes(t *testing.T) {
+	router, _ := newSessionRouter(t)
+
+	for target, secure := range map[string]bool{"/login/bookkeepers": false, "https://localhost/login/bookkeepers": true} {
+		rr := serve(router, "POST", target, bytes.NewBufferString(`{"username":"amir@gmail.com","password":"1234"}`), nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("login at %s: got %v want %v", target, rr.Code, http.StatusOK)
+		}
+		for _, cookie := range rr.Result().Cookies() {
+			if cookie.Secure != secure {
+				t.Errorf("%s cookie at %s: got Secure %v want %v", cookie.Name, target, cookie.Secure, secure)
+			}
+			if cookie.Name == "token" && (!cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode) {
+				t.Errorf("token cookie at %s: got %+v want HttpOnly and SameSite=Lax", target, cookie)
+			}
+		}
+	}
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	_, refresh := login(t, router)
+
+	rr := serve(router, "POST", "/refresh", nil, refresh)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("refresh: got %v want %v", rr.Code, http.StatusOK)
+	}
+	token, rotated := cookies(t, rr)
+	if rotated[0].Value == refresh[0].Value || !rotated[0].HttpOnly {
+		t.Errorf("expected a new HttpOnly refresh token: %+v", rotated[0])
+	}
+
+	if rr := serve(router, "GET", "/sessions", nil, token); rr.Code != http.StatusOK {
+		t.Errorf("refreshed access token: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRefreshReplayRevokesSession(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	_, refresh := login(t, router)
+
+	rr := serve(router, "POST", "/refresh", nil, refresh)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("refresh: got %v want %v", rr.Code, http.StatusOK)
+	}
+	token, rotated := cookies(t, rr)
+
+	if rr := serve(router, "POST", "/refresh", nil, refresh); rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed refresh token: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serve(router, "POST", "/refresh", nil, rotated); rr.Code != http.StatusUnauthorized {
+		t.Errorf("current refresh token after a replay: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serve(router, "GET", "/sessions", nil, token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token after a replay: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestConcurrentRefreshesRotateOnce(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	_, refresh := login(t, router)
+
+	const n = 8
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serve(router, "POST", "/refresh", nil, refresh).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	ok := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			ok++
+		case http.StatusUnauthorized:
+		default:
+			t.Errorf("concurrent refresh: got %v", code)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("got %d successful refreshes with one token want 1", ok)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	token, refresh := login(t, router)
+
+	if rr := serve(router, "POST", "/logout", nil, token); rr.Code != http.StatusOK {
+		t.Fatalf("logout: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serve(router, "GET", "/sessions", nil, token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("access token after logout: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serve(router, "POST", "/refresh", nil, refresh); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token after logout: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRevokeOtherSession(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	laptop, _ := login(t, router)
+	phone, _ := login(t, router)
+
+	rr := serve(router, "GET", "/sessions", nil, laptop)
+	var sessions []models.Session
+	if err := json.Unmarshal(rr.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("expected two sessions, got %+v", sessions)
+	}
+
+	var other string
+	for _, session := range sessions {
+		if !session.Current {
+			other = session.ID
+		}
+	}
+	if other == "" {
+		t.Fatalf("expected exactly one current session: %+v", sessions)
+	}
+
+	if rr := serve(router, "POST", "/sessions/revoke?id="+other, nil, laptop); rr.Code != http.StatusOK {
+		t.Fatalf("revoke: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serve(router, "GET", "/sessions", nil, phone); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serve(router, "POST", "/sessions/revoke?id=unknown", nil, laptop); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown session: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestLoginReturnsBearerToken(t *testing.T) {
+	router, _ := newSessionRouter(t)
+
+	jsonPayload, err := json.Marshal(Credentials{Username: "amir@gmail.com", Password: "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := serve(router, "POST", "/login/bookkeepers", bytes.NewBuffer(jsonPayload), nil)
+	var tokens TokenResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.TokenType != "Bearer" || tokens.ExpiresIn != int(tokenTTL.Seconds()) || tokens.RefreshToken == "" {
+		t.Fatalf("unexpected token response: %+v", tokens)
+	}
+
+	// Bearer requests carry no cookies and need no CSRF token
+	req := httptest.NewRequest("POST", "/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("bearer logout: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	jsonPayload, err = json.Marshal(RefreshRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := serve(router, "POST", "/refresh", bytes.NewBuffer(jsonPayload), nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+// Accounts registered before emails were checked may have an address without
+// an @, which the login form sends as a username
+func TestLoginWithLegacyEmail(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	users := store.NewSQLiteUserRepository(db)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := models.User{Name: "legacy admin", Email: "admin", IsActive: true, Password: string(hashedPassword), Role: "admin"}
+	if err := users.Create(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(users, store.NewSQLiteSessionRepository(db), store.NewSQLitePasswordResetRepository(db), store.NewSQLiteEmailVerificationRepository(db), store.NewSQLiteTwoFactorRepository(db), store.NewSQLiteAPIKeyRepository(db), NewLimiter(store.NewSQLiteLoginAttemptRepository(db), testThrottle), &mail.MemoryMailer{}, "http://localhost:9000/", &memoryAuditor{})
+
+	tests := []struct {
+		name  string
+		creds Credentials
+		want  int
+	}{
+		{"username", Credentials{Username: "admin", Password: "1234"}, http.StatusOK},
+		{"email field", Credentials{Email: "admin", Password: "1234"}, http.StatusOK},
+		{"wrong password", Credentials{Username: "admin", Password: "wrong"}, http.StatusUnauthorized},
+		{"unknown account", Credentials{Username: "nobody", Password: "1234"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		jsonPayload, err := json.Marshal(tt.creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rr := serve(http.HandlerFunc(h.Token), "POST", "/login/bookkeepers", bytes.NewBuffer(jsonPayload), nil); rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+	}
+}
+
+func TestRefreshWithBody(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	_, refresh := login(t, router)
+
+	jsonPayload, err := json.Marshal(RefreshRequest{RefreshToken: refresh[0].Value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := serve(router, "POST", "/refresh", bytes.NewBuffer(jsonPayload), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("refresh: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if len(rr.Result().Cookies()) != 0 {
+		t.Errorf("refresh by body should not set cookies: %v", rr.Result().Cookies())
+	}
+}
@@ -0,0 +1,306 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// Throttle sets how failed logins slow down further attempts. After each
+// failure the next attempt must wait BaseDelay, doubling with every further
+// failure up to MaxDelay. An account is locked for Lockout after MaxFailures
+// failures, and so is an IP address after MaxIPFailures. Failures older than
+// Lockout are forgotten.
+type Throttle struct {
+	MaxFailures   int
+	MaxIPFailures int
+	Lockout       time.Duration
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+}
+
+// DefaultThrottle is used when LOGIN_MAX_FAILURES and LOGIN_LOCKOUT are not set
+var DefaultThrottle = Throttle{
+	MaxFailures:   5,
+	MaxIPFailures: 20,
+	Lockout:       15 * time.Minute,
+	BaseDelay:     time.Second,
+	MaxDelay:      time.Minute,
+}
+
+// ThrottleFromEnv returns DefaultThrottle with the number of failures before
+// an account is locked taken from LOGIN_MAX_FAILURES and the lockout duration
+// from LOGIN_LOCKOUT, such as "30m"
+func ThrottleFromEnv() (Throttle, error) {
+	throttle := DefaultThrottle
+	if raw := os.Getenv("LOGIN_MAX_FAILURES"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return throttle, errors.New("auth: LOGIN_MAX_FAILURES must be a positive number")
+		}
+		throttle.MaxFailures = n
+	}
+	if raw := os.Getenv("LOGIN_LOCKOUT"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return throttle, errors.New("auth: LOGIN_LOCKOUT must be a positive duration")
+		}
+		throttle.Lockout = d
+	}
+	return throttle, nil
+}
+
+// delay returns how long to wait after the given number of failures
+func (t Throttle) delay(failures int) time.Duration {
+	if failures < 1 || t.BaseDelay <= 0 {
+		return 0
+	}
+	d := float64(t.BaseDelay) * math.Pow(2, float64(failures-1))
+	if d > float64(t.MaxDelay) {
+		return t.MaxDelay
+	}
+	return time.Duration(d)
+}
+
+// Limiter tracks failed logins per account and per IP address and records
+// each one
+type Limiter struct {
+	attempts store.LoginAttemptRepository
+	throttle Throttle
+}
+
+// NewLimiter returns a Limiter storing its counters and records in attempts
+func NewLimiter(attempts store.LoginAttemptRepository, throttle Throttle) *Limiter {
+	return &Limiter{attempts: attempts, throttle: throttle}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Wait returns how long a login to email from ip must wait at now, and
+// whether that is because the account or address is locked
+func (l *Limiter) Wait(email, ip string, now time.Time) (wait time.Duration, locked bool, err error) {
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		counter, err := l.attempts.GetCounter(key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+
+		if counter.LockedUntil != nil && now.Before(*counter.LockedUntil) {
+			if d := counter.LockedUntil.Sub(now); d > wait || !locked {
+				wait, locked = d, true
+			}
+			continue
+		}
+		if locked || l.stale(counter, now) {
+			continue
+		}
+		if d := counter.LastFailureAt.Add(l.throttle.delay(counter.Failures)).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, locked, nil
+}
+
+// stale reports whether the failures of counter no longer count at now
+func (l *Limiter) stale(counter models.LoginCounter, now time.Time) bool {
+	if counter.LockedUntil != nil {
+		return !now.Before(*counter.LockedUntil)
+	}
+	return now.Sub(counter.LastFailureAt) > l.throttle.Lockout
+}
+
+// Fail counts a failed login to email from ip at now, locking the account or
+// address once it reaches its limit
+func (l *Limiter) Fail(email, ip string, now time.Time) error {
+	limits := map[string]int{accountKey(email): l.throttle.MaxFailures, ipKey(ip): l.throttle.MaxIPFailures}
+	for key, max := range limits {
+		if _, err := l.attempts.AddFailure(key, now, now.Add(-l.throttle.Lockout), max, now.Add(l.throttle.Lockout)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Succeed forgets the failed logins of the account of email. Failures from the
+// IP address still count, so that one known password cannot be used to keep
+// guessing others.
+func (l *Limiter) Succeed(email string) error {
+	err := l.attempts.ResetCounter(accountKey(email))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// Unlock forgets the failed logins of the account of email, lifting a lockout,
+// and returns the counter it cleared. It returns store.ErrNotFound when the
+// account has none.
+func (l *Limiter) Unlock(email string) (models.LoginCounter, error) {
+	counter, err := l.attempts.GetCounter(accountKey(email))
+	if err != nil {
+		return counter, err
+	}
+	return counter, l.attempts.ResetCounter(accountKey(email))
+}
+
+// lockoutState is what the audit log shows of an account's failed logins
+type lockoutState struct {
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// Record appends a failed or refused login to the record of failed logins
+func (l *Limiter) Record(r *http.Request, email string, user *models.User, reason string, at time.Time) error {
+	failure := models.LoginFailure{
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		IP:        ClientIP(r),
+		Reason:    reason,
+		RequestID: problem.RequestIDFromContext(r.Context()),
+		CreatedAt: at,
+	}
+	if user != nil {
+		failure.UserID = &user.ID
+	}
+	return l.attempts.RecordFailure(&failure)
+}
+
+// allowLogin refuses a login that must wait because of earlier failures,
+// recording the refusal. It reports whether the login may go on; when it may
+// not, the response has been written.
+func (h *Handler) allowLogin(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now().UTC()
+	wait, locked, err := h.limiter.Wait(email, ClientIP(r), now)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return false
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	reason, code, detail := models.LoginThrottled, problem.CodeTooManyAttempts, "Too many failed logins, try again later"
+	if locked {
+		reason, code, detail = models.LoginLocked, problem.CodeAccountLocked, "Account temporarily locked after too many failed logins"
+	}
+	if err := h.limiter.Record(r, email, nil, reason, now); err != nil {
+		log.Printf("recording refused login for %s: %v", email, err)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	problem.Write(w, r, http.StatusTooManyRequests, code, detail)
+	return false
+}
+
+// loginFailed counts and records a failed login for email and writes the problem
+func (h *Handler) loginFailed(w http.ResponseWriter, r *http.Request, email string, user *models.User, reason string, detail string) {
+	now := time.Now().UTC()
+	if err := h.limiter.Fail(email, ClientIP(r), now); err != nil {
+		log.Printf("counting failed login for %s: %v", email, err)
+	}
+	if err := h.limiter.Record(r, email, user, reason, now); err != nil {
+		log.Printf("recording failed login for %s: %v", email, err)
+	}
+	problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, detail)
+}
+
+// loginSucceeded forgets the failed logins of the account of email
+func (h *Handler) loginSucceeded(email string) {
+	if err := h.limiter.Succeed(email); err != nil {
+		log.Printf("resetting failed logins for %s: %v", email, err)
+	}
+}
+
+// UnlockAccount handles the request to lift an account's login lockout
+// @Summary Unlock an account
+// @Description Forget the failed logins of an account, lifting its lockout and backoff.
+// @Description Failures counted against IP addresses are kept.
+// @Tags auth
+// @Produce plain
+// @Param id query int true "User ID"
+// @Success 200 {string} string "Account unlocked"
+// @Failure 400 {object} problem.Problem "Invalid ID"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "User not found, or no failed logins to forget"
+// @Router /users/unlock [post]
+func (h *Handler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid ID")
+		return
+	}
+
+	user, err := h.users.Get(id, "")
+	if err != nil {
+		problem.StoreError(w, r, err, "User not found")
+		return
+	}
+	counter, err := h.limiter.Unlock(user.Email)
+	if err != nil {
+		problem.StoreError(w, r, err, "Account has no failed logins")
+		return
+	}
+	h.audit.Record(r, models.AuditDelete, "lockout", user.ID, lockoutState{Failures: counter.Failures, LastFailureAt: counter.LastFailureAt, LockedUntil: counter.LockedUntil}, nil)
+	w.Write([]byte("Account unlocked"))
+}
+
+// ListLoginFailures handles the request to list failed logins
+// @Summary List failed logins
+// @Description Get the most recent failed and refused logins, optionally for one email address.
+// @Description The reason is unknown_account, wrong_password, wrong_code, throttled or locked.
+// @Tags auth
+// @Produce json
+// @Param email query string false "Email address"
+// @Param limit query int false "Maximum number of records, 50 by default"
+// @Success 200 {array} models.LoginFailure
+// @Failure 400 {object} problem.Problem "Invalid limit"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Router /login/failures [get]
+func (h *Handler) ListLoginFailures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	limit := 50
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 500 {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	failures, err := h.limiter.attempts.ListFailures(email, limit)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(failures)
+}
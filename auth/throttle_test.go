@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// testThrottle locks accounts after three failures without slowing down the
+// attempts before that
+var testThrottle = Throttle{MaxFailures: 3, MaxIPFailures: 100, Lockout: time.Minute}
+
+func TestThrottleDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{20, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := DefaultThrottle.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d): got %v want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	limiter := NewLimiter(store.NewSQLiteLoginAttemptRepository(db), DefaultThrottle)
+
+	now := time.Now().UTC()
+	wait := func(email, ip string, at time.Time) (time.Duration, bool) {
+		d, locked, err := limiter.Wait(email, ip, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d, locked
+	}
+
+	if err := limiter.Fail("Jane@example.com", "10.0.0.1", now); err != nil {
+		t.Fatal(err)
+	}
+	if d, locked := wait("jane@example.com", "10.0.0.2", now); d != time.Second || locked {
+		t.Errorf("after one failure: got %v, %v want %v", d, locked, time.Second)
+	}
+	if d, _ := wait("john@example.com", "10.0.0.1", now); d != time.Second {
+		t.Errorf("same address: got %v want %v", d, time.Second)
+	}
+	if d, _ := wait("jane@example.com", "10.0.0.2", now.Add(time.Second)); d != 0 {
+		t.Errorf("after the delay: got %v want 0", d)
+	}
+
+	for i := 1; i < DefaultThrottle.MaxFailures; i++ {
+		if err := limiter.Fail("jane@example.com", "10.0.0.1", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d, locked := wait("jane@example.com", "10.0.0.2", now); d != DefaultThrottle.Lockout || !locked {
+		t.Errorf("after %d failures: got %v, %v want %v, locked", DefaultThrottle.MaxFailures, d, locked, DefaultThrottle.Lockout)
+	}
+	if d, locked := wait("jane@example.com", "10.0.0.2", now.Add(DefaultThrottle.Lockout)); d != 0 || locked {
+		t.Errorf("after the lockout: got %v, %v want 0", d, locked)
+	}
+
+	if err := limiter.Fail("jane@example.com", "10.0.0.2", now.Add(DefaultThrottle.Lockout)); err != nil {
+		t.Fatal(err)
+	}
+	if d, locked := wait("jane@example.com", "10.0.0.3", now.Add(DefaultThrottle.Lockout)); d != time.Second || locked {
+		t.Errorf("failure after the lockout counts from one: got %v, %v want %v", d, locked, time.Second)
+	}
+
+	if err := limiter.Succeed("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := wait("jane@example.com", "10.0.0.3", now.Add(DefaultThrottle.Lockout)); d != 0 {
+		t.Errorf("after a successful login: got %v want 0", d)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	router, _, auditor := newAuditedSessionRouter(t)
+	token, _ := login(t, router)
+
+	wrong, err := json.Marshal(Credentials{Username: "amir@gmail.com", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < testThrottle.MaxFailures; i++ {
+		if rr := serve(router, "POST", "/login/bookkeepers", bytes.NewBuffer(wrong), nil); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong password: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rr := loginWithCode(t, router, "")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" || problemCode(t, rr) != problem.CodeAccountLocked {
+		t.Fatalf("locked account: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+
+	rr = serve(router, "GET", "/login/failures?email=amir@gmail.com", nil, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("list failures: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var failures []models.LoginFailure
+	if err := json.NewDecoder(rr.Body).Decode(&failures); err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != testThrottle.MaxFailures+1 || failures[0].Reason != models.LoginLocked || failures[1].Reason != models.LoginWrongPassword {
+		t.Errorf("unexpected failures %+v", failures)
+	}
+
+	if rr := serve(router, "POST", "/users/unlock?id=1", nil, token); rr.Code != http.StatusOK {
+		t.Fatalf("unlock: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if record, ok := auditor.find(models.AuditDelete, "lockout"); !ok || record.id != 1 || record.before.(lockoutState).Failures != testThrottle.MaxFailures || record.after != nil {
+		t.Errorf("audited unlock: got %+v", record)
+	}
+	if rr := serve(router, "POST", "/users/unlock?id=1", nil, token); rr.Code != http.StatusNotFound {
+		t.Errorf("unlock twice: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := loginWithCode(t, router, ""); rr.Code != http.StatusOK {
+		t.Errorf("login after unlock: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,360 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+	"golang_project/totp"
+)
+
+// TwoFactorIssuer names the library in authenticator apps
+const TwoFactorIssuer = "Library"
+
+// RecoveryCodeCount is how many recovery codes are issued when two-factor
+// authentication is turned on
+const RecoveryCodeCount = 10
+
+// TwoFactorEnrollment is returned when enrolment starts. The URI can be shown
+// as a QR code; the secret is for entering by hand.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TwoFactorCode carries a code from an authenticator app, or a recovery code
+type TwoFactorCode struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes are shown once, when two-factor authentication is turned on
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// twoFactorState is what the audit log shows of an account's two-factor
+// authentication. The secret is never logged.
+type twoFactorState struct {
+	Status      string     `json:"status"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+func auditTwoFactor(tf models.TwoFactor) twoFactorState {
+	if tf.ConfirmedAt == nil {
+		return twoFactorState{Status: "pending"}
+	}
+	return twoFactorState{Status: "on", ConfirmedAt: tf.ConfirmedAt}
+}
+
+var (
+	errInvalidCode = errors.New("auth: invalid two-factor code")
+	errCodeUsed    = errors.New("auth: two-factor code already used")
+)
+
+// checkSecondFactor asks for a second factor when user has turned two-factor
+// authentication on. It reports whether one was given and whether the login
+// may go on; when it may not, the response has been written.
+func (h *Handler) checkSecondFactor(w http.ResponseWriter, r *http.Request, user models.User, code string) (twoFactor, ok bool) {
+	tf, err := h.twoFactor.Get(user.ID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && tf.ConfirmedAt == nil) {
+		return false, true
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return false, false
+	}
+	if code == "" {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeTwoFactorRequired, "Two-factor code required")
+		return false, false
+	}
+
+	err = h.useSecondFactor(tf, code)
+	switch {
+	case errors.Is(err, errCodeUsed):
+		h.loginFailed(w, r, user.Email, &user, models.LoginWrongCode, "Two-factor code already used")
+		return false, false
+	case errors.Is(err, errInvalidCode):
+		h.loginFailed(w, r, user.Email, &user, models.LoginWrongCode, "Invalid two-factor code")
+		return false, false
+	case err != nil:
+		writeSecondFactorError(w, r, err)
+		return false, false
+	}
+	return true, true
+}
+
+// twoFactorOn reports whether the account userID has confirmed two-factor
+// authentication
+func (h *Handler) twoFactorOn(userID int) (bool, error) {
+	tf, err := h.twoFactor.Get(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil && tf.ConfirmedAt != nil, err
+}
+
+// useSecondFactor accepts code if it is the current TOTP code of tf or one of
+// the user's unused recovery codes, and marks it as used
+func (h *Handler) useSecondFactor(tf models.TwoFactor, code string) error {
+	now := time.Now().UTC()
+	if step, ok := totp.Validate(tf.Secret, code, now); ok {
+		err := h.twoFactor.UseStep(tf.UserID, step)
+		if errors.Is(err, store.ErrConflict) {
+			return errCodeUsed
+		}
+		return err
+	}
+
+	err := h.twoFactor.UseRecoveryCode(tf.UserID, hashToken(normalizeRecoveryCode(code)), now)
+	if errors.Is(err, store.ErrNotFound) {
+		return errInvalidCode
+	}
+	return err
+}
+
+func writeSecondFactorError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errCodeUsed):
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Two-factor code already used")
+	case errors.Is(err, errInvalidCode):
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid two-factor code")
+	default:
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+	}
+}
+
+// newRecoveryCodes returns RecoveryCodeCount codes formatted for display and
+// the hashes they are stored under
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))
+		codes = append(codes, raw[0:4]+"-"+raw[4:8]+"-"+raw[8:12]+"-"+raw[12:16])
+		hashes = append(hashes, hashToken(raw))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode drops the separators and case a recovery code may be typed with
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// EnrollTwoFactor handles the request to start turning on two-factor authentication
+// @Summary Start two-factor enrolment
+// @Description Generate a TOTP secret for the logged-in account. Add it to an authenticator app,
+// @Description then confirm it with a code at /2fa/confirm. Starting again replaces a pending secret.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} auth.TwoFactorEnrollment
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Failure 409 {object} problem.Problem "Two-factor authentication is already on"
+// @Router /2fa/enroll [post]
+func (h *Handler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating secret")
+		return
+	}
+	err = h.twoFactor.Begin(claims.UserID, secret)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Two-factor authentication is already on")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error saving secret")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "two_factor", claims.UserID, nil, twoFactorState{Status: "pending"})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TwoFactorEnrollment{
+		Secret: secret,
+		URI:    totp.URI(TwoFactorIssuer, claims.Username, secret),
+	})
+}
+
+// ConfirmTwoFactor handles the request to finish turning on two-factor authentication
+// @Summary Confirm two-factor enrolment
+// @Description Turn on two-factor authentication with a code from the authenticator app.
+// @Description The response lists recovery codes that can each replace a code once; they are not shown again.
+// @Description The current session is not upgraded: log in again with a code to use routes that require it.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body auth.TwoFactorCode true "Code from the authenticator app"
+// @Success 200 {object} auth.RecoveryCodes
+// @Failure 400 {object} problem.Problem "Invalid code"
+// @Failure 404 {object} problem.Problem "No pending enrolment"
+// @Failure 409 {object} problem.Problem "Two-factor authentication is already on"
+// @Router /2fa/confirm [post]
+func (h *Handler) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	tf, err := h.twoFactor.Get(claims.UserID)
+	if err != nil {
+		problem.StoreError(w, r, err, "No pending two-factor enrolment")
+		return
+	}
+	if tf.ConfirmedAt != nil {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Two-factor authentication is already on")
+		return
+	}
+
+	now := time.Now().UTC()
+	step, valid := totp.Validate(tf.Secret, req.Code, now)
+	if !valid {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Invalid two-factor code")
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error generating recovery codes")
+		return
+	}
+	if err := h.twoFactor.Confirm(claims.UserID, step, now, hashes); err != nil {
+		problem.StoreError(w, r, err, "No pending two-factor enrolment")
+		return
+	}
+	confirmed := tf
+	confirmed.ConfirmedAt = &now
+	h.audit.Record(r, models.AuditUpdate, "two_factor", claims.UserID, auditTwoFactor(tf), auditTwoFactor(confirmed))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(RecoveryCodes{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor handles the request to turn off the caller's two-factor authentication
+// @Summary Turn off two-factor authentication
+// @Description Remove the logged-in account's TOTP secret and recovery codes. A current code or an
+// @Description unused recovery code is required. Roles that require two-factor authentication must enrol again.
+// @Tags auth
+// @Accept json
+// @Produce plain
+// @Param request body auth.TwoFactorCode true "Code from the authenticator app, or a recovery code"
+// @Success 200 {string} string "Two-factor authentication turned off"
+// @Failure 401 {object} problem.Problem "Invalid code"
+// @Failure 404 {object} problem.Problem "Two-factor authentication is not on"
+// @Router /2fa/disable [post]
+func (h *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := sessionClaims(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req TwoFactorCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	tf, err := h.twoFactor.Get(claims.UserID)
+	if err == nil && tf.ConfirmedAt == nil {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, "Two-factor authentication is not on")
+		return
+	}
+	if err := h.useSecondFactor(tf, req.Code); err != nil {
+		writeSecondFactorError(w, r, err)
+		return
+	}
+
+	if err := h.twoFactor.Delete(claims.UserID); err != nil {
+		problem.StoreError(w, r, err, "Two-factor authentication is not on")
+		return
+	}
+	h.audit.Record(r, models.AuditDelete, "two_factor", claims.UserID, auditTwoFactor(tf), nil)
+	w.Write([]byte("Two-factor authentication turned off"))
+}
+
+// ResetTwoFactor handles the request to clear another account's two-factor authentication
+// @Summary Reset two-factor authentication
+// @Description Remove an account's TOTP secret and recovery codes, for bookkeepers who lost their
+// @Description authenticator app, and sign out every session of the account
+// @Tags auth
+// @Produce plain
+// @Param id query int true "User ID"
+// @Success 200 {string} string "Two-factor authentication reset"
+// @Failure 400 {object} problem.Problem "Invalid ID"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "Two-factor authentication is not on"
+// @Router /2fa/reset [post]
+func (h *Handler) ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid ID")
+		return
+	}
+
+	tf, err := h.twoFactor.Get(userID)
+	if err != nil {
+		problem.StoreError(w, r, err, "Two-factor authentication is not on")
+		return
+	}
+	if err := h.twoFactor.Delete(userID); err != nil {
+		problem.StoreError(w, r, err, "Two-factor authentication is not on")
+		return
+	}
+	h.audit.Record(r, models.AuditDelete, "two_factor", userID, auditTwoFactor(tf), nil)
+	if err := h.sessions.RevokeAllForUser(userID, "", time.Now().UTC()); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error revoking sessions")
+		return
+	}
+	w.Write([]byte("Two-factor authentication reset"))
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/totp"
+)
+
+// twoFactorPolicy guards /user in the session router and requires admins to
+// log in with a second factor
+var twoFactorPolicy = Policy{"admin": {PermAccountRead, PermTwoFactorRequired}}
+
+func loginWithCode(t *testing.T, router http.Handler, otp string) *httptest.ResponseRecorder {
+	jsonPayload, err := json.Marshal(Credentials{Username: "amir@gmail.com", Password: "1234", OTP: otp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serve(router, "POST", "/login/bookkeepers", bytes.NewBuffer(jsonPayload), nil)
+}
+
+func problemCode(t *testing.T, rr *httptest.ResponseRecorder) problem.Code {
+	var p problem.Problem
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return p.Code
+}
+
+// enable turns on two-factor authentication for the session of token and
+// returns the secret, the step of the code used and the recovery codes
+func enable(t *testing.T, router http.Handler, token []*http.Cookie) (string, int64, []string) {
+	rr := serve(router, "POST", "/2fa/enroll", nil, token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enroll: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var enrollment TwoFactorEnrollment
+	if err := json.NewDecoder(rr.Body).Decode(&enrollment); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/") || !strings.Contains(enrollment.URI, "secret="+enrollment.Secret) {
+		t.Errorf("unexpected otpauth URI %q", enrollment.URI)
+	}
+
+	rr = serve(router, "POST", "/2fa/confirm", bytes.NewBufferString(`{"code":"000000x"}`), token)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("confirm with a wrong code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	step := totp.Step(time.Now())
+	code, err := totp.Code(enrollment.Secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = serve(router, "POST", "/2fa/confirm", bytes.NewBufferString(`{"code":"`+code+`"}`), token)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("confirm: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var recovery RecoveryCodes
+	if err := json.NewDecoder(rr.Body).Decode(&recovery); err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery.RecoveryCodes) != RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes want %d", len(recovery.RecoveryCodes), RecoveryCodeCount)
+	}
+	return enrollment.Secret, step, recovery.RecoveryCodes
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	token, _ := login(t, router)
+
+	rr := serve(router, "GET", "/user", nil, token)
+	if rr.Code != http.StatusForbidden || problemCode(t, rr) != problem.CodeTwoFactorRequired {
+		t.Errorf("role requiring two factors without one: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	secret, step, recovery := enable(t, router, token)
+	if rr := serve(router, "POST", "/2fa/enroll", nil, token); rr.Code != http.StatusConflict {
+		t.Errorf("enroll twice: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	rr = loginWithCode(t, router, "")
+	if rr.Code != http.StatusUnauthorized || problemCode(t, rr) != problem.CodeTwoFactorRequired {
+		t.Errorf("login without a code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := loginWithCode(t, router, "123456x"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	code, err := totp.Code(secret, step+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = loginWithCode(t, router, code)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login with a code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	token, _ = cookies(t, rr)
+	if rr := serve(router, "GET", "/user", nil, token); rr.Code != http.StatusOK {
+		t.Errorf("role requiring two factors with one: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := loginWithCode(t, router, code); rr.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	if rr := loginWithCode(t, router, strings.ToUpper(recovery[0])); rr.Code != http.StatusOK {
+		t.Errorf("recovery code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := loginWithCode(t, router, recovery[0]); rr.Code != http.StatusUnauthorized {
+		t.Errorf("reused recovery code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	router, _ := newSessionRouter(t)
+	token, _ := login(t, router)
+	_, _, recovery := enable(t, router, token)
+
+	if rr := serve(router, "POST", "/2fa/disable", bytes.NewBufferString(`{"code":"aaaa-bbbb-cccc-dddd"}`), token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("disable with a wrong code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := serve(router, "POST", "/2fa/disable", bytes.NewBufferString(`{"code":"`+recovery[1]+`"}`), token); rr.Code != http.StatusOK {
+		t.Fatalf("disable: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := loginWithCode(t, router, ""); rr.Code != http.StatusOK {
+		t.Errorf("login after disabling: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serve(router, "POST", "/2fa/disable", bytes.NewBufferString(`{"code":"`+recovery[2]+`"}`), token); rr.Code != http.StatusNotFound {
+		t.Errorf("disable twice: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestResetTwoFactor(t *testing.T) {
+	router, _, auditor := newAuditedSessionRouter(t)
+	token, _ := login(t, router)
+	enable(t, router, token)
+
+	if rr := serve(router, "POST", "/2fa/reset?id=x", nil, token); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid ID: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := serve(router, "POST", "/2fa/reset?id=1", nil, token); rr.Code != http.StatusOK {
+		t.Fatalf("reset: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if record, ok := auditor.find(models.AuditDelete, "two_factor"); !ok || record.actor != "amir@gmail.com" || record.before.(twoFactorState).Status != "on" {
+		t.Errorf("audited reset: got %+v", record)
+	}
+	if _, ok := auditor.find(models.AuditUpdate, "two_factor"); !ok {
+		t.Error("confirming two-factor authentication was not audited")
+	}
+	if rr := serve(router, "GET", "/sessions", nil, token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("session after reset: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := loginWithCode(t, router, ""); rr.Code != http.StatusOK {
+		t.Errorf("login after reset: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serve(router, "POST", "/2fa/reset?id=1", nil, token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("reset with a revoked session: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang_project/mail"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// VerificationTTL is how long an email verification link stays valid
+const VerificationTTL = 48 * time.Hour
+
+// SendVerification mails user a link to confirm their email address
+func (h *Handler) SendVerification(user models.User) error {
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	verification := models.OneTimeToken{TokenHash: hashToken(token), UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(VerificationTTL)}
+	if err := h.verifications.Create(&verification); err != nil {
+		return err
+	}
+
+	link := h.appURL + "/users/verify?token=" + url.QueryEscape(token)
+	return h.mailer.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your library account",
+		Body: fmt.Sprintf("Welcome to the library, %s.\n\n"+
+			"To confirm your email address and activate your account, open:\n\n%s\n\n"+
+			"The link expires in %d hours. If you did not register, you can ignore this email.\n",
+			user.Name, link, int(VerificationTTL.Hours())),
+	})
+}
+
+// VerifyEmail handles the link mailed at registration
+// @Summary Verify an email address
+// @Description Confirm the email address of a newly registered account with the token from the
+// @Description verification link, which activates the account and allows it to log in
+// @Tags users
+// @Produce plain
+// @Param token query string true "Verification token"
+// @Success 200 {string} string "Email verified"
+// @Failure 400 {object} problem.Problem "Invalid or expired verification token"
+// @Router /users/verify [get]
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing verification token")
+		return
+	}
+
+	now := time.Now().UTC()
+	userID, err := h.verifications.Consume(hashToken(token), now)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid or expired verification token")
+		return
+	}
+	var before models.User
+	if err == nil {
+		before, err = h.users.Get(userID, "")
+	}
+	if err == nil {
+		err = h.users.MarkEmailVerified(userID, now)
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error verifying email")
+		return
+	}
+	if after, err := h.users.Get(userID, ""); err == nil {
+		h.audit.RecordAs(r, after, models.AuditUpdate, accountEntity(after.Role), userID, before, after)
+	}
+	w.Write([]byte("Email verified"))
+}
+
+// ResendVerification handles the request to mail a new verification link
+// @Summary Resend the verification email
+// @Description Mail a new verification link to an account that has not confirmed its email address.
+// @Description Earlier links stop working. The response is the same whether or not such an account exists.
+// @Tags users
+// @Accept json
+// @Produce plain
+// @Param request body auth.EmailRequest true "Account email"
+// @Success 202 {string} string "If the account awaits verification, a link has been sent"
+// @Failure 400 {object} problem.Problem "Invalid request payload"
+// @Router /users/verify/resend [post]
+func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid request payload")
+		return
+	}
+
+	user, err := h.users.GetByEmail(req.Email)
+	if err == nil && user.EmailVerifiedAt == nil {
+		err = h.SendVerification(user)
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("verification for %s: %v", req.Email, err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the account awaits verification, a link has been sent"))
+}
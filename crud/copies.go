@@ -0,0 +1,239 @@
+package crud
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// copyConditions lists the accepted values of models.Copy.Condition
+var copyConditions = map[string]bool{
+	"new":     true,
+	"good":    true,
+	"fair":    true,
+	"poor":    true,
+	"damaged": true,
+}
+
+// validateCopy fills in the default condition and reports what is wrong with c, if anything
+func validateCopy(c *models.Copy) string {
+	if c.Barcode == "" {
+		return "Missing barcode"
+	}
+	if c.Condition == "" {
+		c.Condition = "good"
+	}
+	if !copyConditions[c.Condition] {
+		return "Invalid condition"
+	}
+	return ""
+}
+
+// ListCopies handles the request to list the copies of a book
+// @Summary List the copies of a book
+// @Description Get every physical copy of a book with its availability
+// @Tags copies
+// @Produce json
+// @Param book_id query string true "Book ID"
+// @Success 200 {array} models.Copy
+// @Router /copies [get]
+func (h *Handler) ListCopies(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("book_id")
+	if raw == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing book ID")
+		return
+	}
+	bookID, err := strconv.Atoi(raw)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid book ID")
+		return
+	}
+
+	copies, err := h.copies.ListByBook(bookID)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(copies)
+}
+
+// CreateCopy handles the request to register a new copy of a book
+// @Summary Create a new copy
+// @Description Register a physical copy of an existing book
+// @Tags copies
+// @Accept json
+// @Produce json
+// @Param copy body models.Copy true "Copy"
+// @Success 201 {object} models.Copy
+// @Failure 404 {object} problem.Problem "Book not found"
+// @Failure 409 {object} problem.Problem "Barcode already in use"
+// @Router /copies/create [post]
+func (h *Handler) CreateCopy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var bookCopy models.Copy
+	err := json.NewDecoder(r.Body).Decode(&bookCopy)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	if msg := validateCopy(&bookCopy); msg != "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, msg)
+		return
+	}
+
+	if _, err := h.books.Get(bookCopy.BookID); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Book not found")
+		} else {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		}
+		return
+	}
+
+	err = h.copies.Create(&bookCopy)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Barcode already in use")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error creating copy")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "copy", bookCopy.ID, nil, bookCopy)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(bookCopy)
+}
+
+// ReadCopy handles the request to read a copy by ID
+// @Summary Read a copy by ID
+// @Description Get the details and availability of a copy by its ID
+// @Tags copies
+// @Produce json
+// @Param id query string true "Copy ID"
+// @Success 200 {object} models.Copy
+// @Router /copies/read [get]
+func (h *Handler) ReadCopy(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r, "Missing copy ID")
+	if !ok {
+		return
+	}
+
+	bookCopy, err := h.copies.Get(id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Copy not found")
+		} else {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bookCopy)
+}
+
+// UpdateCopy handles the request to update a copy
+// @Summary Update a copy
+// @Description Update the barcode, condition and shelf location of a copy
+// @Tags copies
+// @Accept json
+// @Produce json
+// @Param copy body models.Copy true "Copy"
+// @Success 200 {string} string "Copy updated successfully"
+// @Failure 404 {object} problem.Problem "Copy not found"
+// @Failure 409 {object} problem.Problem "Barcode already in use"
+// @Router /copies/update [put]
+func (h *Handler) UpdateCopy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var bookCopy models.Copy
+	err := json.NewDecoder(r.Body).Decode(&bookCopy)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	if msg := validateCopy(&bookCopy); msg != "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, msg)
+		return
+	}
+
+	before, err := h.copies.Get(bookCopy.ID)
+	if err != nil {
+		problem.StoreError(w, r, err, "Copy not found")
+		return
+	}
+	err = h.copies.Update(bookCopy)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Copy not found")
+		return
+	}
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Barcode already in use")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating copy")
+		return
+	}
+	if after, err := h.copies.Get(bookCopy.ID); err == nil {
+		h.audit.Record(r, models.AuditUpdate, "copy", bookCopy.ID, before, after)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Copy updated successfully"))
+}
+
+// DeleteCopy handles the request to delete a copy
+// @Summary Delete a copy
+// @Description Delete a copy by its ID. Copies that are on loan cannot be deleted.
+// @Tags copies
+// @Param id query string true "Copy ID"
+// @Success 200 {string} string "Copy deleted successfully"
+// @Failure 404 {object} problem.Problem "Copy not found"
+// @Failure 409 {object} problem.Problem "Copy is on loan"
+// @Router /copies/delete [delete]
+func (h *Handler) DeleteCopy(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r, "Missing copy ID")
+	if !ok {
+		return
+	}
+
+	before, err := h.copies.Get(id)
+	if err != nil {
+		problem.StoreError(w, r, err, "Copy not found")
+		return
+	}
+	err = h.copies.Delete(id)
+	if errors.Is(err, store.ErrNotFound) {
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Copy not found")
+		return
+	}
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Copy is on loan")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error deleting copy")
+		return
+	}
+	h.audit.Record(r, models.AuditDelete, "copy", id, before, nil)
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Copy deleted successfully"))
+}
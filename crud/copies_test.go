@@ -0,0 +1,119 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang_project/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func createCopy(t *testing.T, h *Handler, c models.Copy) *httptest.ResponseRecorder {
+	jsonPayload, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/copies/create", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.CreateCopy).ServeHTTP(rr, req)
+	return rr
+}
+
+func readBook(t *testing.T, h *Handler, id int) models.Book {
+	req, err := http.NewRequest("GET", fmt.Sprintf("/books/read?id=%d", id), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.ReadBook).ServeHTTP(rr, req)
+
+	var book models.Book
+	if err := json.NewDecoder(rr.Body).Decode(&book); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return book
+}
+
+func TestCreateCopy(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	rr := createCopy(t, h, models.Copy{BookID: 1, Barcode: "B-0001", ShelfLocation: "A3"})
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v. Response body: %v",
+			status, http.StatusCreated, rr.Body.String())
+	}
+
+	var created models.Copy
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if created.ID == 0 || created.Condition != "good" || !created.Available {
+		t.Errorf("unexpected copy: %+v", created)
+	}
+
+	tests := []struct {
+		name string
+		copy models.Copy
+		want int
+	}{
+		{"duplicate barcode", models.Copy{BookID: 1, Barcode: "B-0001"}, http.StatusConflict},
+		{"missing barcode", models.Copy{BookID: 1}, http.StatusBadRequest},
+		{"invalid condition", models.Copy{BookID: 1, Barcode: "B-0002", Condition: "soggy"}, http.StatusBadRequest},
+		{"unknown book", models.Copy{BookID: 999, Barcode: "B-0003"}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := createCopy(t, h, tt.copy); rr.Code != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBookAvailability(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	for _, barcode := range []string{"B-0001", "B-0002"} {
+		if rr := createCopy(t, h, models.Copy{BookID: 1, Barcode: barcode}); rr.Code != http.StatusCreated {
+			t.Fatalf("create copy %s: got %v want %v", barcode, rr.Code, http.StatusCreated)
+		}
+	}
+
+	book := readBook(t, h, 1)
+	if book.TotalCopies != 2 || book.AvailableCopies != 2 {
+		t.Errorf("got %d/%d copies available, want 2/2", book.AvailableCopies, book.TotalCopies)
+	}
+}
+
+func TestDeleteCopy(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	rr := createCopy(t, h, models.Copy{BookID: 1, Barcode: "B-0001"})
+	var created models.Copy
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		req, err := http.NewRequest("DELETE", fmt.Sprintf("/copies/delete?id=%d", created.ID), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.DeleteCopy).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, want)
+		}
+	}
+}
@@ -1,64 +1,70 @@
 package crud
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	"golang_project/audit"
 	"golang_project/models"
+	"golang_project/paging"
+	"golang_project/problem"
+	"golang_project/store"
 
-	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// CheckErr checks for an error and panics if it exists
-func CheckErr(err error) {
-	if err != nil {
-		panic(err)
-	}
+// Verifier mails a newly registered user the link that confirms their email address
+type Verifier interface {
+	SendVerification(user models.User) error
+}
+
+// Handler serves the book, copy, user and bookkeeper endpoints
+type Handler struct {
+	books     store.BookRepository
+	users     store.UserRepository
+	copies    store.CopyRepository
+	verifier  Verifier
+	audit     *audit.Log
+	revisions store.BookRevisionRepository
+}
+
+// NewHandler returns a Handler backed by the given repositories. Every
+// create, update and delete is recorded in auditLog, and every version of a
+// book's metadata in revisions.
+func NewHandler(books store.BookRepository, users store.UserRepository, copies store.CopyRepository, verifier Verifier, auditLog *audit.Log, revisions store.BookRevisionRepository) *Handler {
+	return &Handler{books: books, users: users, copies: copies, verifier: verifier, audit: auditLog, revisions: revisions}
 }
 
 // HandleBooks handles the request to list all books
 // @Summary List all books
-// @Description Get a list of all books
+// @Description Get a page of the catalog. The next page is linked from the Link and X-Next-Cursor headers.
 // @Tags books
 // @Produce json
+// @Param limit query int false "Books per page (default 50, at most 100)"
+// @Param cursor query string false "Cursor from X-Next-Cursor of the previous page"
+// @Param sort query string false "Fields to sort by, e.g. genre,-published_year"
+// @Param fields query string false "Fields to include, e.g. id,title"
 // @Success 200 {array} models.Book
+// @Failure 400 {object} problem.Problem "Invalid pagination parameters"
 // @Router /books [get]
-func HandleBooks(w http.ResponseWriter, r *http.Request) {
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
+func (h *Handler) HandleBooks(w http.ResponseWriter, r *http.Request) {
+	params, err := paging.Parse(r, "")
 	if err != nil {
-		http.Error(w, "Database connection error", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
-	defer db.Close()
 
-	rows, err := db.Query("SELECT * FROM books")
+	books, err := h.books.List(params.Page())
 	if err != nil {
-		http.Error(w, "Error querying database", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
 		return
 	}
-	defer rows.Close()
-
-	var books []models.Book
-	for rows.Next() {
-		var book models.Book
-		err = rows.Scan(&book.ID, &book.Title, &book.Author, &book.ISBN, &book.PublishedYear, &book.Genre)
-		if err != nil {
-			http.Error(w, "Error scanning book", http.StatusInternalServerError)
-			return
-		}
-		books = append(books, book)
-	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
-	err = encoder.Encode(books)
-	if err != nil {
-		http.Error(w, "Error encoding JSON", http.StatusInternalServerError)
-	}
+	params.Write(w, r, books)
 }
 
 // CreateBook handles the request to create a new book
@@ -70,27 +76,26 @@ func HandleBooks(w http.ResponseWriter, r *http.Request) {
 // @Param book body models.Book true "Book"
 // @Success 201 {string} string "Book created successfully"
 // @Router /books/create [post]
-func CreateBook(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateBook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
 		return
 	}
 
 	var book models.Book
 	err := json.NewDecoder(r.Body).Decode(&book)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	stmt, err := db.Prepare("INSERT INTO books(Title, Author, ISBN, PublishedYear, Genre) VALUES(?, ?, ?, ?, ?)")
-	CheckErr(err)
-	_, err = stmt.Exec(book.Title, book.Author, book.ISBN, book.PublishedYear, book.Genre)
-	CheckErr(err)
+	revision := newRevision(r)
+	err = h.books.CreateWithRevision(&book, &revision)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error creating book")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "book", book.ID, nil, book)
 
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Book created successfully"))
@@ -102,24 +107,23 @@ func CreateBook(w http.ResponseWriter, r *http.Request) {
 // @Tags books
 // @Produce json
 // @Param id query string true "Book ID"
+// @Param If-None-Match header string false "ETag of the book already held"
 // @Success 200 {object} models.Book
+// @Header 200 {string} ETag "Version of the book"
+// @Success 304 "Not modified"
 // @Router /books/read [get]
-func ReadBook(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "Missing book ID", http.StatusBadRequest)
+func (h *Handler) ReadBook(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r, "Missing book ID")
+	if !ok {
 		return
 	}
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	row := db.QueryRow("SELECT * FROM books WHERE ID = ?", id)
-	var book models.Book
-	err = row.Scan(&book.ID, &book.Title, &book.Author, &book.ISBN, &book.PublishedYear, &book.Genre)
+	book, err := h.books.Get(id)
 	if err != nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+		problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Book not found")
+		return
+	}
+	if notModified(w, r, book.Version) {
 		return
 	}
 
@@ -131,34 +135,77 @@ func ReadBook(w http.ResponseWriter, r *http.Request) {
 
 // UpdateBook handles the request to update a book
 // @Summary Update a book
-// @Description Update the details of an existing book
+// @Description Replace the details of an existing book with PUT, or change some of them with PATCH and
+// @Description a JSON Merge Patch (application/merge-patch+json) or JSON Patch (application/json-patch+json).
+// @Description A PATCH names the book in the id query parameter.
 // @Tags books
 // @Accept json
 // @Produce json
-// @Param book body models.Book true "Book"
+// @Param book body models.Book true "Book, or a patch of it"
+// @Param id query string false "Book ID, for PATCH"
+// @Param If-Match header string true "ETag of the book"
 // @Success 200 {string} string "Book updated successfully"
+// @Failure 400 {object} problem.Problem "Invalid book or patch"
+// @Failure 404 {object} problem.Problem "Book not found"
+// @Failure 409 {object} problem.Problem "Patch test failed"
+// @Failure 412 {object} problem.Problem "Book changed since it was read"
+// @Failure 415 {object} problem.Problem "Unsupported patch format"
+// @Failure 428 {object} problem.Problem "If-Match header required"
 // @Router /books/update [put]
-func UpdateBook(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+// @Router /books/update [patch]
+func (h *Handler) UpdateBook(w http.ResponseWriter, r *http.Request) {
+	var book, before models.Book
+	switch r.Method {
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+			return
+		}
+		var err error
+		if before, err = h.books.Get(book.ID); err != nil {
+			problem.StoreError(w, r, err, "Book not found")
+			return
+		}
+	case http.MethodPatch:
+		id, ok := queryID(w, r, "Missing book ID")
+		if !ok {
+			return
+		}
+		var err error
+		if before, err = h.books.Get(id); err != nil {
+			problem.StoreError(w, r, err, "Book not found")
+			return
+		}
+		if !applyPatch(w, r, before, &book, bookReadOnly) {
+			return
+		}
+		if msg := validateBook(book); msg != "" {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, msg)
+			return
+		}
+	default:
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	var book models.Book
-	err := json.NewDecoder(r.Body).Decode(&book)
+	if !ifMatch(w, r, before.Version) {
+		return
+	}
+	book.Version = before.Version
+	revision := newRevision(r)
+	err := h.books.UpdateWithRevision(book, &revision)
+	if errors.Is(err, store.ErrVersionMismatch) {
+		preconditionFailed(w, r)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating book")
 		return
 	}
-
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	stmt, err := db.Prepare("UPDATE books SET Title = ?, Author = ?, ISBN = ?, PublishedYear = ?, Genre = ? WHERE ID = ?")
-	CheckErr(err)
-	_, err = stmt.Exec(book.Title, book.Author, book.ISBN, book.PublishedYear, book.Genre, book.ID)
-	CheckErr(err)
+	if after, err := h.books.Get(book.ID); err == nil {
+		h.audit.Record(r, models.AuditUpdate, "book", book.ID, before, after)
+		w.Header().Set("ETag", etag(after.Version))
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Book updated successfully"))
@@ -166,71 +213,98 @@ func UpdateBook(w http.ResponseWriter, r *http.Request) {
 
 // DeleteBook handles the request to delete a book
 // @Summary Delete a book
-// @Description Delete a book by its ID
+// @Description Move a book to the trash. It can be restored until the retention period has passed.
 // @Tags books
 // @Param id query string true "Book ID"
+// @Param If-Match header string true "ETag of the book"
 // @Success 200 {string} string "Book deleted successfully"
+// @Failure 404 {object} problem.Problem "Book not found"
+// @Failure 412 {object} problem.Problem "Book changed since it was read"
+// @Failure 428 {object} problem.Problem "If-Match header required"
 // @Router /books/delete [delete]
-func DeleteBook(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "Missing book ID", http.StatusBadRequest)
+func (h *Handler) DeleteBook(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r, "Missing book ID")
+	if !ok {
 		return
 	}
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	stmt, err := db.Prepare("DELETE FROM books WHERE ID = ?")
-	CheckErr(err)
-	_, err = stmt.Exec(id)
-	CheckErr(err)
+	before, err := h.books.Get(id)
+	if err != nil {
+		problem.StoreError(w, r, err, "Book not found")
+		return
+	}
+	if !ifMatch(w, r, before.Version) {
+		return
+	}
+	err = h.books.Delete(id, before.Version, time.Now().UTC())
+	if errors.Is(err, store.ErrVersionMismatch) {
+		preconditionFailed(w, r)
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error deleting book")
+		return
+	}
+	h.audit.Record(r, models.AuditDelete, "book", id, before, nil)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Book deleted successfully"))
 }
 
-// CreateUser handles the request to create a new user
-// @Summary Create a new user
-// @Description Create a new user with the provided details
+// CreateUser handles the request to register a new user
+// @Summary Register a new user
+// @Description Register a patron account. The account always gets the user role and stays inactive
+// @Description until the email address is confirmed through the link mailed to it.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param user body models.User true "User"
-// @Success 201 {string} string "User created successfully"
+// @Success 201 {string} string "User created, check your email to verify it"
+// @Failure 409 {object} problem.Problem "Email already registered"
 // @Router /users/create [post]
-func CreateUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
 		return
 	}
 
 	var user models.User
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
+	// Self-registered accounts are patrons and must prove they own the email
+	user.Role = "user"
+	user.IsActive = false
+	user.EmailVerifiedAt = nil
+
 	// Hash the user's password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error hashing password")
 		return
 	}
+	user.Password = string(hashedPassword)
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
+	err = h.users.Create(&user)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Email already registered")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error creating user")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "user", user.ID, nil, user)
 
-	stmt, err := db.Prepare("INSERT INTO Users(name, email, membershipdate, is_active, password, role) VALUES(?, ?, ?, ?, ?, ?)")
-	CheckErr(err)
-	_, err = stmt.Exec(user.Name, user.Email, user.MembershipDate, user.IsActive, hashedPassword, user.Role)
-	CheckErr(err)
+	if err := h.verifier.SendVerification(user); err != nil {
+		log.Printf("verification for %s: %v", user.Email, err)
+	}
 
 	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("User created successfully"))
+	w.Write([]byte("User created, check your email to verify it"))
 }
 
 // ReadUser handles the request to read a user by ID
@@ -239,30 +313,30 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 // @Tags users
 // @Produce json
 // @Param id query string true "User ID"
+// @Param If-None-Match header string false "ETag of the user already held"
 // @Success 200 {object} models.User
+// @Header 200 {string} ETag "Version of the user"
+// @Success 304 "Not modified"
 // @Router /users/read [get]
-func ReadUser(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "Missing user ID", http.StatusBadRequest)
+func (h *Handler) ReadUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r, "Missing user ID")
+	if !ok {
 		return
 	}
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	row := db.QueryRow("SELECT ID, name, email, membershipdate, is_active FROM Users WHERE ID = ? AND role='user'", id)
-	var user models.User
-	err = row.Scan(&user.ID, &user.Name, &user.Email, &user.MembershipDate, &user.IsActive)
+	user, err := h.users.Get(id, "user")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "User not found", http.StatusNotFound)
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "User not found")
 		} else {
-			http.Error(w, "Error scanning user", http.StatusInternalServerError)
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error scanning user")
 		}
 		return
 	}
+	user.Password = ""
+	if notModified(w, r, user.Version) {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
@@ -272,34 +346,82 @@ func ReadUser(w http.ResponseWriter, r *http.Request) {
 
 // UpdateUser handles the request to update a user
 // @Summary Update a user
-// @Description Update the details of an existing user
+// @Description Replace the details of an existing user with PUT, or change some of them with PATCH and
+// @Description a JSON Merge Patch (application/merge-patch+json) or JSON Patch (application/json-patch+json).
+// @Description A PATCH names the user in the id query parameter. The password and role cannot be patched.
 // @Tags users
 // @Accept json
 // @Produce json
-// @Param user body models.User true "User"
+// @Param user body models.User true "User, or a patch of it"
+// @Param id query string false "User ID, for PATCH"
+// @Param If-Match header string true "ETag of the user"
 // @Success 200 {string} string "User updated successfully"
+// @Failure 400 {object} problem.Problem "Invalid user or patch"
+// @Failure 409 {object} problem.Problem "Email already registered or patch test failed"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 412 {object} problem.Problem "User changed since it was read"
+// @Failure 415 {object} problem.Problem "Unsupported patch format"
+// @Failure 428 {object} problem.Problem "If-Match header required"
 // @Router /users/update [put]
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+// @Router /users/update [patch]
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var user, before models.User
+	switch r.Method {
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+			return
+		}
+		var err error
+		if before, err = h.users.Get(user.ID, "user"); err != nil {
+			problem.StoreError(w, r, err, "User not found")
+			return
+		}
+	case http.MethodPatch:
+		id, ok := queryID(w, r, "Missing user ID")
+		if !ok {
+			return
+		}
+		var err error
+		if before, err = h.users.Get(id, "user"); err != nil {
+			problem.StoreError(w, r, err, "User not found")
+			return
+		}
+		current := before
+		current.Password = ""
+		if !applyPatch(w, r, current, &user, userReadOnly) {
+			return
+		}
+		if msg := validateUser(user); msg != "" {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, msg)
+			return
+		}
+	default:
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	var user models.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	if !ifMatch(w, r, before.Version) {
+		return
+	}
+	user.Version = before.Version
+	err := h.users.Update(user, "user")
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Email already registered")
+		return
+	}
+	if errors.Is(err, store.ErrVersionMismatch) {
+		preconditionFailed(w, r)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating user")
 		return
 	}
-
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	stmt, err := db.Prepare("UPDATE Users SET name = ?, email = ?, membershipdate = ?, is_active = ? WHERE id = ?")
-	CheckErr(err)
-	_, err = stmt.Exec(user.Name, user.Email, user.MembershipDate, user.IsActive, user.ID)
-	CheckErr(err)
+	if after, err := h.users.Get(user.ID, "user"); err == nil {
+		h.audit.Record(r, models.AuditUpdate, "user", user.ID, before, after)
+		w.Header().Set("ETag", etag(after.Version))
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("User updated successfully"))
@@ -307,26 +429,39 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 
 // DeleteUser handles the request to delete a user
 // @Summary Delete a user
-// @Description Delete a user by their ID
+// @Description Move a user to the trash and sign them out. They can be restored until the retention period has passed.
 // @Tags users
 // @Param id query string true "User ID"
+// @Param If-Match header string true "ETag of the user"
 // @Success 200 {string} string "User deleted successfully"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 412 {object} problem.Problem "User changed since it was read"
+// @Failure 428 {object} problem.Problem "If-Match header required"
 // @Router /users/delete [delete]
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "Missing user ID", http.StatusBadRequest)
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r, "Missing user ID")
+	if !ok {
 		return
 	}
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	stmt, err := db.Prepare("DELETE FROM Users WHERE id = ?")
-	CheckErr(err)
-	_, err = stmt.Exec(id)
-	CheckErr(err)
+	before, err := h.users.Get(id, "user")
+	if err != nil {
+		problem.StoreError(w, r, err, "User not found")
+		return
+	}
+	if !ifMatch(w, r, before.Version) {
+		return
+	}
+	err = h.users.Delete(id, "user", before.Version, time.Now().UTC())
+	if errors.Is(err, store.ErrVersionMismatch) {
+		preconditionFailed(w, r)
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error deleting user")
+		return
+	}
+	h.audit.Record(r, models.AuditDelete, "user", id, before, nil)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("User deleted successfully"))
@@ -340,33 +475,42 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Param bookkeeper body models.User true "Bookkeeper"
 // @Success 201 {string} string "Bookkeeper created successfully"
+// @Failure 409 {object} problem.Problem "Email already registered"
 // @Router /bookkeepers/create [post]
-func CreateBookkeeper(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateBookkeeper(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
 		return
 	}
 
 	var bookkeeper models.User
 	err := json.NewDecoder(r.Body).Decode(&bookkeeper)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
 		return
 	}
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(bookkeeper.Password), bcrypt.DefaultCost)
 	if err != nil {
-		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error hashing password")
 		return
 	}
+	bookkeeper.Password = string(hashedPassword)
 
-	stmt, err := db.Prepare("INSERT INTO Users(name, email, is_active, password, role, membershipdate) VALUES(?, ?, ?, ?, ?, ?)")
-	CheckErr(err)
-	_, err = stmt.Exec(bookkeeper.Name, bookkeeper.Email, bookkeeper.IsActive, hashedPassword, bookkeeper.Role, bookkeeper.MembershipDate)
-	CheckErr(err)
+	// Accounts created by an administrator need no email verification
+	now := time.Now().UTC()
+	bookkeeper.EmailVerifiedAt = &now
+
+	err = h.users.Create(&bookkeeper)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Email already registered")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error creating bookkeeper")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "bookkeeper", bookkeeper.ID, nil, bookkeeper)
 
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Bookkeeper created successfully"))
@@ -378,30 +522,30 @@ func CreateBookkeeper(w http.ResponseWriter, r *http.Request) {
 // @Tags bookkeepers
 // @Produce json
 // @Param id query string true "Bookkeeper ID"
+// @Param If-None-Match header string false "ETag of the bookkeeper already held"
 // @Success 200 {object} models.User
+// @Header 200 {string} ETag "Version of the bookkeeper"
+// @Success 304 "Not modified"
 // @Router /bookkeepers/read [get]
-func ReadBookkeeper(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "Missing bookkeeper ID", http.StatusBadRequest)
+func (h *Handler) ReadBookkeeper(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r, "Missing bookkeeper ID")
+	if !ok {
 		return
 	}
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	row := db.QueryRow("SELECT ID, name, email, is_active FROM Users WHERE ID = ? AND role='admin'", id)
-	var bookkeeper models.User
-	err = row.Scan(&bookkeeper.ID, &bookkeeper.Name, &bookkeeper.Email, &bookkeeper.IsActive)
+	bookkeeper, err := h.users.Get(id, "admin")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "Bookkeeper not found", http.StatusNotFound)
+		if errors.Is(err, store.ErrNotFound) {
+			problem.Write(w, r, http.StatusNotFound, problem.CodeNotFound, "Bookkeeper not found")
 		} else {
-			http.Error(w, "Error scanning bookkeeper", http.StatusInternalServerError)
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error scanning bookkeeper")
 		}
 		return
 	}
+	bookkeeper.Password = ""
+	if notModified(w, r, bookkeeper.Version) {
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(bookkeeper)
@@ -411,34 +555,82 @@ func ReadBookkeeper(w http.ResponseWriter, r *http.Request) {
 
 // UpdateBookkeeper handles the request to update a bookkeeper
 // @Summary Update a bookkeeper
-// @Description Update the details of an existing bookkeeper
+// @Description Replace the details of an existing bookkeeper with PUT, or change some of them with PATCH and
+// @Description a JSON Merge Patch (application/merge-patch+json) or JSON Patch (application/json-patch+json).
+// @Description A PATCH names the bookkeeper in the id query parameter. The password and role cannot be patched.
 // @Tags bookkeepers
 // @Accept json
 // @Produce json
-// @Param bookkeeper body models.User true "Bookkeeper"
+// @Param bookkeeper body models.User true "Bookkeeper, or a patch of it"
+// @Param id query string false "Bookkeeper ID, for PATCH"
+// @Param If-Match header string true "ETag of the bookkeeper"
 // @Success 200 {string} string "Bookkeeper updated successfully"
+// @Failure 400 {object} problem.Problem "Invalid bookkeeper or patch"
+// @Failure 409 {object} problem.Problem "Email already registered or patch test failed"
+// @Failure 404 {object} problem.Problem "Bookkeeper not found"
+// @Failure 412 {object} problem.Problem "Bookkeeper changed since it was read"
+// @Failure 415 {object} problem.Problem "Unsupported patch format"
+// @Failure 428 {object} problem.Problem "If-Match header required"
 // @Router /bookkeepers/update [put]
-func UpdateBookkeeper(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPut {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+// @Router /bookkeepers/update [patch]
+func (h *Handler) UpdateBookkeeper(w http.ResponseWriter, r *http.Request) {
+	var bookkeeper, before models.User
+	switch r.Method {
+	case http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&bookkeeper); err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+			return
+		}
+		var err error
+		if before, err = h.users.Get(bookkeeper.ID, "admin"); err != nil {
+			problem.StoreError(w, r, err, "Bookkeeper not found")
+			return
+		}
+	case http.MethodPatch:
+		id, ok := queryID(w, r, "Missing bookkeeper ID")
+		if !ok {
+			return
+		}
+		var err error
+		if before, err = h.users.Get(id, "admin"); err != nil {
+			problem.StoreError(w, r, err, "Bookkeeper not found")
+			return
+		}
+		current := before
+		current.Password = ""
+		if !applyPatch(w, r, current, &bookkeeper, userReadOnly) {
+			return
+		}
+		if msg := validateUser(bookkeeper); msg != "" {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, msg)
+			return
+		}
+	default:
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
 		return
 	}
 
-	var bookkeeper models.User
-	err := json.NewDecoder(r.Body).Decode(&bookkeeper)
+	if !ifMatch(w, r, before.Version) {
+		return
+	}
+	bookkeeper.Version = before.Version
+	err := h.users.Update(bookkeeper, "admin")
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Email already registered")
+		return
+	}
+	if errors.Is(err, store.ErrVersionMismatch) {
+		preconditionFailed(w, r)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating bookkeeper")
 		return
 	}
-
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	stmt, err := db.Prepare("UPDATE Users SET name = ?, email = ?, is_active = ? WHERE ID = ? AND role='admin'")
-	CheckErr(err)
-	_, err = stmt.Exec(bookkeeper.Name, bookkeeper.Email, bookkeeper.IsActive, bookkeeper.ID)
-	CheckErr(err)
+	if after, err := h.users.Get(bookkeeper.ID, "admin"); err == nil {
+		h.audit.Record(r, models.AuditUpdate, "bookkeeper", bookkeeper.ID, before, after)
+		w.Header().Set("ETag", etag(after.Version))
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Bookkeeper updated successfully"))
@@ -446,27 +638,55 @@ func UpdateBookkeeper(w http.ResponseWriter, r *http.Request) {
 
 // DeleteBookkeeper handles the request to delete a bookkeeper
 // @Summary Delete a bookkeeper
-// @Description Delete a bookkeeper by their ID
+// @Description Move a bookkeeper to the trash and sign them out. They can be restored until the retention period has passed.
 // @Tags bookkeepers
 // @Param id query string true "Bookkeeper ID"
+// @Param If-Match header string true "ETag of the bookkeeper"
 // @Success 200 {string} string "Bookkeeper deleted successfully"
+// @Failure 404 {object} problem.Problem "Bookkeeper not found"
+// @Failure 412 {object} problem.Problem "Bookkeeper changed since it was read"
+// @Failure 428 {object} problem.Problem "If-Match header required"
 // @Router /bookkeepers/delete [delete]
-func DeleteBookkeeper(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
-	if id == "" {
-		http.Error(w, "Missing bookkeeper ID", http.StatusBadRequest)
+func (h *Handler) DeleteBookkeeper(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r, "Missing bookkeeper ID")
+	if !ok {
 		return
 	}
 
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
-	stmt, err := db.Prepare("DELETE FROM Users WHERE ID = ? AND role='admin'")
-	CheckErr(err)
-	_, err = stmt.Exec(id)
-	CheckErr(err)
+	before, err := h.users.Get(id, "admin")
+	if err != nil {
+		problem.StoreError(w, r, err, "Bookkeeper not found")
+		return
+	}
+	if !ifMatch(w, r, before.Version) {
+		return
+	}
+	err = h.users.Delete(id, "admin", before.Version, time.Now().UTC())
+	if errors.Is(err, store.ErrVersionMismatch) {
+		preconditionFailed(w, r)
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error deleting bookkeeper")
+		return
+	}
+	h.audit.Record(r, models.AuditDelete, "bookkeeper", id, before, nil)
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Bookkeeper deleted successfully"))
 }
+
+// queryID reads the numeric id query parameter, writing a 400 response when it is missing or invalid
+func queryID(w http.ResponseWriter, r *http.Request, missing string) (int, bool) {
+	raw := r.URL.Query().Get("id")
+	if raw == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, missing)
+		return 0, false
+	}
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid ID")
+		return 0, false
+	}
+	return id, true
+}
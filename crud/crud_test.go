@@ -3,14 +3,64 @@ package crud
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"golang_project/audit"
 	"golang_project/auth"
+	"golang_project/mail"
 	"golang_project/models"
+	"golang_project/store"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-func loginAsBookkeeper(t *testing.T) *http.Cookie {
+func newTestHandlers(t *testing.T) (*Handler, *auth.Handler) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	books := store.NewSQLiteBookRepository(db)
+	users := store.NewSQLiteUserRepository(db)
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookkeeper := models.User{
+		Name:     "amir rahimi",
+		Email:    "amir@gmail.com",
+		IsActive: true,
+		Password: string(hashedPassword),
+		Role:     "admin",
+	}
+	if err := users.Create(&bookkeeper); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 6; i++ {
+		book := models.Book{
+			Title:         fmt.Sprintf("Seed Book %d", i),
+			Author:        "Seed Author",
+			ISBN:          fmt.Sprintf("000000000%d", i),
+			PublishedYear: 2000 + i,
+			Genre:         "Seed Genre",
+		}
+		if err := books.Create(&book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	auditLog := audit.NewLog(store.NewSQLiteAuditRepository(db))
+	authHandler := auth.NewHandler(users, store.NewSQLiteSessionRepository(db), store.NewSQLitePasswordResetRepository(db), store.NewSQLiteEmailVerificationRepository(db), store.NewSQLiteTwoFactorRepository(db), store.NewSQLiteAPIKeyRepository(db), auth.NewLimiter(store.NewSQLiteLoginAttemptRepository(db), auth.DefaultThrottle), &mail.MemoryMailer{}, "http://localhost:9000", auditLog)
+	return NewHandler(books, users, store.NewSQLiteCopyRepository(db), authHandler, auditLog, store.NewSQLiteBookRevisionRepository(db)), authHandler
+}
+
+func loginAsBookkeeper(t *testing.T, authHandler *auth.Handler) *http.Cookie {
 	credentials := auth.Credentials{
 		Username: "amir@gmail.com",
 		Password: "1234",
@@ -27,7 +77,7 @@ func loginAsBookkeeper(t *testing.T) *http.Cookie {
 	req.Header.Set("Content-Type", "application/json")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(auth.LoginBookkeeper)
+	handler := http.HandlerFunc(authHandler.Token)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -47,6 +97,8 @@ func loginAsBookkeeper(t *testing.T) *http.Cookie {
 }
 
 func TestCreateBook(t *testing.T) {
+	h, authHandler := newTestHandlers(t)
+
 	book := models.Book{
 		Title:         "Test Book",
 		Author:        "Test Author",
@@ -66,11 +118,11 @@ func TestCreateBook(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 
 	// Login as bookkeeper
-	cookie := loginAsBookkeeper(t)
+	cookie := loginAsBookkeeper(t, authHandler)
 	req.AddCookie(cookie)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(CreateBook)
+	handler := http.HandlerFunc(h.CreateBook)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusCreated {
@@ -86,13 +138,15 @@ func TestCreateBook(t *testing.T) {
 }
 
 func TestReadBook(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
 	req, err := http.NewRequest("GET", "/books/read?id=6", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(ReadBook)
+	handler := http.HandlerFunc(h.ReadBook)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -102,6 +156,8 @@ func TestReadBook(t *testing.T) {
 }
 
 func TestUpdateBook(t *testing.T) {
+	h, authHandler := newTestHandlers(t)
+
 	book := models.Book{
 		ID:            1,
 		Title:         "Updated Test Book",
@@ -120,13 +176,14 @@ func TestUpdateBook(t *testing.T) {
 		t.Fatal(err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", `"1"`)
 
 	// Login as bookkeeper
-	cookie := loginAsBookkeeper(t)
+	cookie := loginAsBookkeeper(t, authHandler)
 	req.AddCookie(cookie)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(UpdateBook)
+	handler := http.HandlerFunc(h.UpdateBook)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -142,17 +199,20 @@ func TestUpdateBook(t *testing.T) {
 }
 
 func TestDeleteBook(t *testing.T) {
+	h, authHandler := newTestHandlers(t)
+
 	req, err := http.NewRequest("DELETE", "/books/delete?id=1", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("If-Match", `"1"`)
 
 	// Login as bookkeeper
-	cookie := loginAsBookkeeper(t)
+	cookie := loginAsBookkeeper(t, authHandler)
 	req.AddCookie(cookie)
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(DeleteBook)
+	handler := http.HandlerFunc(h.DeleteBook)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -165,4 +225,52 @@ func TestDeleteBook(t *testing.T) {
 		t.Errorf("handler returned unexpected body: got %v want %v",
 			rr.Body.String(), expected)
 	}
-}
\ No newline at end of file
+}
+func TestHandleBooksPagination(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	var years []int
+	next := "/books?limit=4&sort=-published_year"
+	for pages := 0; next != ""; pages++ {
+		if pages > 2 {
+			t.Fatalf("expected two pages, still following %s", next)
+		}
+		req, err := http.NewRequest("GET", next, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(h.HandleBooks).ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		var books []models.Book
+		if err := json.Unmarshal(rr.Body.Bytes(), &books); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		for _, book := range books {
+			years = append(years, book.PublishedYear)
+		}
+
+		next = ""
+		if cursor := rr.Header().Get("X-Next-Cursor"); cursor != "" {
+			next = "/books?limit=4&sort=-published_year&cursor=" + cursor
+		}
+	}
+
+	want := []int{2006, 2005, 2004, 2003, 2002, 2001}
+	if fmt.Sprint(years) != fmt.Sprint(want) {
+		t.Errorf("got years %v, want %v", years, want)
+	}
+
+	req, err := http.NewRequest("GET", "/books?sort=password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.HandleBooks).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("sort outside the whitelist: got %v want %v", status, http.StatusBadRequest)
+	}
+}
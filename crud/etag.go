@@ -0,0 +1,58 @@
+package crud
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang_project/problem"
+)
+
+// etag returns the entity tag of a book or user at version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// notModified sets the ETag of a record at version and answers 304 Not
+// Modified when the request's If-None-Match already names that version
+func notModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	tag := etag(version)
+	w.Header().Set("ETag", tag)
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		// If-None-Match uses the weak comparison
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch checks that the request's If-Match names the record at version,
+// writing 428 when the header is missing and 412 when it names another version
+func ifMatch(w http.ResponseWriter, r *http.Request, version int) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		problem.Write(w, r, http.StatusPreconditionRequired, problem.CodePreconditionRequired, "If-Match header required, send the ETag the record was read with")
+		return false
+	}
+	tag := etag(version)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	preconditionFailed(w, r)
+	return false
+}
+
+// preconditionFailed answers that the record was changed since the client read it
+func preconditionFailed(w http.ResponseWriter, r *http.Request) {
+	problem.Write(w, r, http.StatusPreconditionFailed, problem.CodePreconditionFailed, "Record was changed since it was read")
+}
@@ -0,0 +1,115 @@
+package crud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBookETags(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	serve := func(handler http.HandlerFunc, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := serve(h.ReadBook, "GET", "/books/read?id=1", "", nil)
+	if got := rr.Header().Get("ETag"); got != `"1"` {
+		t.Fatalf("read: got ETag %s want %s", got, `"1"`)
+	}
+
+	reads := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"current version", `"1"`, http.StatusNotModified},
+		{"weak tag", `W/"1"`, http.StatusNotModified},
+		{"one of several", `"7", "1"`, http.StatusNotModified},
+		{"another version", `"2"`, http.StatusOK},
+	}
+	for _, tt := range reads {
+		rr := serve(h.ReadBook, "GET", "/books/read?id=1", "", http.Header{"If-None-Match": {tt.ifNoneMatch}})
+		if rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+		if rr.Code == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s: 304 with body %q", tt.name, rr.Body.String())
+		}
+	}
+
+	update := `{"id":1,"title":"Dune","author":"Frank Herbert"}`
+	writes := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		ifMatch string
+		want    int
+	}{
+		{"update without If-Match", h.UpdateBook, "PUT", "/books/update", "", http.StatusPreconditionRequired},
+		{"update of another version", h.UpdateBook, "PUT", "/books/update", `"2"`, http.StatusPreconditionFailed},
+		{"weak tag", h.UpdateBook, "PUT", "/books/update", `W/"1"`, http.StatusPreconditionFailed},
+		{"update", h.UpdateBook, "PUT", "/books/update", `"1"`, http.StatusOK},
+		{"update of a stale version", h.UpdateBook, "PUT", "/books/update", `"1"`, http.StatusPreconditionFailed},
+		{"delete without If-Match", h.DeleteBook, "DELETE", "/books/delete?id=1", "", http.StatusPreconditionRequired},
+		{"delete of a stale version", h.DeleteBook, "DELETE", "/books/delete?id=1", `"1"`, http.StatusPreconditionFailed},
+		{"delete", h.DeleteBook, "DELETE", "/books/delete?id=1", `"2"`, http.StatusOK},
+	}
+	for _, tt := range writes {
+		header := http.Header{}
+		if tt.ifMatch != "" {
+			header.Set("If-Match", tt.ifMatch)
+		}
+		rr := serve(tt.handler, tt.method, tt.target, update, header)
+		if rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+		if tt.name == "update" && rr.Header().Get("ETag") != `"2"` {
+			t.Errorf("update: got ETag %s want %s", rr.Header().Get("ETag"), `"2"`)
+		}
+	}
+}
+
+func TestUserETags(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	read := httptest.NewRecorder()
+	h.ReadBookkeeper(read, httptest.NewRequest("GET", "/bookkeepers/read?id=1", nil))
+	tag := read.Header().Get("ETag")
+	if tag != `"1"` {
+		t.Fatalf("read: got ETag %s want %s", tag, `"1"`)
+	}
+
+	body := `{"id":1,"name":"Amir Rahimi","email":"amir@gmail.com","is_active":true}`
+	req := httptest.NewRequest("PUT", "/bookkeepers/update", strings.NewReader(body))
+	req.Header.Set("If-Match", tag)
+	rr := httptest.NewRecorder()
+	h.UpdateBookkeeper(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("update: got %v with ETag %s want %v with %s", rr.Code, rr.Header().Get("ETag"), http.StatusOK, `"2"`)
+	}
+
+	req = httptest.NewRequest("PUT", "/bookkeepers/update", strings.NewReader(body))
+	req.Header.Set("If-Match", tag)
+	rr = httptest.NewRecorder()
+	h.UpdateBookkeeper(rr, req)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("update of a stale version: got %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	req = httptest.NewRequest("GET", "/bookkeepers/read?id=1", nil)
+	req.Header.Set("If-None-Match", `"2"`)
+	rr = httptest.NewRecorder()
+	h.ReadBookkeeper(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("read of the current version: got %v want %v", rr.Code, http.StatusNotModified)
+	}
+}
@@ -0,0 +1,209 @@
+package crud
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang_project/audit"
+	"golang_project/models"
+	"golang_project/problem"
+)
+
+// RevisionDiff lists the fields of a book that differ between two of its revisions
+type RevisionDiff struct {
+	From    int             `json:"from"`
+	To      int             `json:"to"`
+	Changes json.RawMessage `json:"changes" swaggertype:"object"`
+}
+
+// ListBookHistory handles the request to list the revisions of a book
+// @Summary List the revisions of a book
+// @Description Get every version of a book's metadata, latest first, with who made it and when
+// @Tags books
+// @Produce json
+// @Param id path int true "Book ID"
+// @Success 200 {array} models.BookRevision
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "Book not found"
+// @Router /books/{id}/history [get]
+func (h *Handler) ListBookHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.books.GetWithDeleted(id); err != nil {
+		problem.StoreError(w, r, err, "Book not found")
+		return
+	}
+	revisions, err := h.revisions.List(id)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	if revisions == nil {
+		revisions = []models.BookRevision{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// DiffBookRevisions handles the request to compare two revisions of a book
+// @Summary Compare two revisions of a book
+// @Description Get the fields that changed from one revision of a book to another, each with its value
+// @Description in both revisions
+// @Tags books
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param from query int true "Earlier revision"
+// @Param to query int true "Later revision"
+// @Success 200 {object} RevisionDiff
+// @Failure 400 {object} problem.Problem "Invalid revision"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "Revision not found"
+// @Router /books/{id}/history/diff [get]
+func (h *Handler) DiffBookRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	from, ok := queryRevision(w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := queryRevision(w, r, "to")
+	if !ok {
+		return
+	}
+
+	old, err := h.revisions.Get(id, from)
+	if err != nil {
+		problem.StoreError(w, r, err, "Revision not found")
+		return
+	}
+	updated, err := h.revisions.Get(id, to)
+	if err != nil {
+		problem.StoreError(w, r, err, "Revision not found")
+		return
+	}
+	changes, err := audit.Diff(revisionBook(old), revisionBook(updated))
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error comparing revisions")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RevisionDiff{From: from, To: to, Changes: changes})
+}
+
+// RevertBook handles the request to restore an earlier revision of a book
+// @Summary Revert a book to an earlier revision
+// @Description Overwrite a book's metadata with that of one of its revisions. The result is recorded
+// @Description as a new revision, so the revert itself can be undone.
+// @Tags books
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param revision query int true "Revision to revert to"
+// @Param If-Match header string true "ETag of the book"
+// @Success 200 {object} models.BookRevision
+// @Failure 400 {object} problem.Problem "Invalid revision"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "Revision not found"
+// @Failure 412 {object} problem.Problem "Book changed since it was read"
+// @Failure 428 {object} problem.Problem "If-Match header required"
+// @Router /books/{id}/revert [post]
+func (h *Handler) RevertBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	number, ok := queryRevision(w, r, "revision")
+	if !ok {
+		return
+	}
+
+	before, err := h.books.Get(id)
+	if err != nil {
+		problem.StoreError(w, r, err, "Book not found")
+		return
+	}
+	if !ifMatch(w, r, before.Version) {
+		return
+	}
+	revision, err := h.revisions.Get(id, number)
+	if err != nil {
+		problem.StoreError(w, r, err, "Revision not found")
+		return
+	}
+	book := revisionBook(revision)
+	book.Version = before.Version
+	latest := newRevision(r)
+	if err := h.books.UpdateWithRevision(book, &latest); err != nil {
+		problem.StoreError(w, r, err, "Book not found")
+		return
+	}
+	after, err := h.books.Get(id)
+	if err != nil {
+		problem.StoreError(w, r, err, "Book not found")
+		return
+	}
+	h.audit.Record(r, models.AuditUpdate, "book", id, before, after)
+
+	w.Header().Set("ETag", etag(after.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(latest)
+}
+
+// newRevision returns a revision made by the caller of r now, for the book
+// repository to fill in with the metadata it saves
+func newRevision(r *http.Request) models.BookRevision {
+	revision := models.BookRevision{CreatedAt: time.Now().UTC()}
+	revision.ActorID, revision.Actor = audit.Actor(r)
+	return revision
+}
+
+// revisionBook returns the book with the metadata of revision
+func revisionBook(revision models.BookRevision) models.Book {
+	return models.Book{
+		ID:            revision.BookID,
+		Title:         revision.Title,
+		Author:        revision.Author,
+		ISBN:          revision.ISBN,
+		PublishedYear: revision.PublishedYear,
+		Genre:         revision.Genre,
+	}
+}
+
+// pathID reads the numeric id path segment, writing a 400 response when it is invalid
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// queryRevision reads the positive revision number in the named query parameter,
+// writing a 400 response when it is missing or invalid
+func queryRevision(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	n, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil || n < 1 {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid "+name+" revision")
+		return 0, false
+	}
+	return n, true
+}
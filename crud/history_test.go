@@ -0,0 +1,95 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang_project/models"
+)
+
+func serveHistory(t *testing.T, h http.HandlerFunc, method, target, id string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, &payload)
+	req.SetPathValue("id", id)
+	req.Header.Set("If-Match", "*")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBookHistory(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	book := models.Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", PublishedYear: 1965, Genre: "Science Fiction"}
+	if rr := serveHistory(t, h.CreateBook, "POST", "/books/create", "", book); rr.Code != http.StatusCreated {
+		t.Fatalf("create: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	book.ID = 7
+	book.Title = "Dune Messiah"
+	book.PublishedYear = 1969
+	if rr := serveHistory(t, h.UpdateBook, "PUT", "/books/update", "", book); rr.Code != http.StatusOK {
+		t.Fatalf("update: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr := serveHistory(t, h.ListBookHistory, "GET", "/books/7/history", "7", nil)
+	var revisions []models.BookRevision
+	if err := json.NewDecoder(rr.Body).Decode(&revisions); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Title != "Dune Messiah" || revisions[1].Title != "Dune" {
+		t.Fatalf("history: got %+v", revisions)
+	}
+
+	rr = serveHistory(t, h.DiffBookRevisions, "GET", "/books/7/history/diff?from=1&to=2", "7", nil)
+	var diff struct {
+		Changes map[string]struct{ Before, After json.RawMessage }
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&diff); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Changes) != 2 || string(diff.Changes["title"].Before) != `"Dune"` || string(diff.Changes["published_year"].After) != "1969" {
+		t.Errorf("diff: got %+v", diff.Changes)
+	}
+
+	rr = serveHistory(t, h.RevertBook, "POST", "/books/7/revert?revision=1", "7", nil)
+	var reverted models.BookRevision
+	if err := json.NewDecoder(rr.Body).Decode(&reverted); err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Revision != 3 || reverted.Title != "Dune" {
+		t.Errorf("revert: got %+v", reverted)
+	}
+	if got := readBook(t, h, 7); got.Title != "Dune" || got.PublishedYear != 1965 {
+		t.Errorf("reverted book: got %+v", got)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		target  string
+		id      string
+		want    int
+	}{
+		{"unknown book", h.ListBookHistory, "GET", "/books/99/history", "99", http.StatusNotFound},
+		{"invalid id", h.ListBookHistory, "GET", "/books/x/history", "x", http.StatusBadRequest},
+		{"missing revision", h.DiffBookRevisions, "GET", "/books/7/history/diff?from=1", "7", http.StatusBadRequest},
+		{"unknown revision", h.DiffBookRevisions, "GET", "/books/7/history/diff?from=1&to=9", "7", http.StatusNotFound},
+		{"revert unknown revision", h.RevertBook, "POST", "/books/7/revert?revision=9", "7", http.StatusNotFound},
+		{"revert with GET", h.RevertBook, "GET", "/books/7/revert?revision=1", "7", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if rr := serveHistory(t, tt.handler, tt.method, tt.target, tt.id, nil); rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+	}
+}
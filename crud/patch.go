@@ -0,0 +1,131 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang_project/models"
+	"golang_project/patch"
+	"golang_project/problem"
+)
+
+// The fields of books and users that a PATCH must leave as they are
+var (
+	bookReadOnly = []string{"id", "total_copies", "available_copies", "deleted_at", "version"}
+	userReadOnly = []string{"id", "password", "role", "email_verified_at", "deleted_at", "version"}
+)
+
+// applyPatch applies the JSON Merge Patch or JSON Patch in the body of r to
+// current and decodes the result into patched, which must have the fields of
+// the model and no others, and leave readOnly fields unchanged. It writes the
+// problem response and returns false when the patch cannot be applied.
+func applyPatch(w http.ResponseWriter, r *http.Request, current, patched interface{}, readOnly []string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != patch.MergePatchType && mediaType != patch.JSONPatchType) {
+		problem.Write(w, r, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType,
+			"Content-Type must be "+patch.MergePatchType+" or "+patch.JSONPatchType)
+		return false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return false
+	}
+	doc, err := json.Marshal(current)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error encoding record")
+		return false
+	}
+
+	result, err := patch.Apply(mediaType, doc, body)
+	if errors.Is(err, patch.ErrTestFailed) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, err.Error())
+		return false
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(result))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(patched); err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Patched record is invalid: "+err.Error())
+		return false
+	}
+	changed, err := changedFields(current, patched)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error encoding record")
+		return false
+	}
+	for _, name := range readOnly {
+		if changed[name] {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, fmt.Sprintf("Field %s cannot be changed", name))
+			return false
+		}
+	}
+	return true
+}
+
+// changedFields reports which fields differ between before and after, two
+// values of the same model
+func changedFields(before, after interface{}) (map[string]bool, error) {
+	old, err := jsonFields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := jsonFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]bool)
+	for name, value := range old {
+		changed[name] = !bytes.Equal(value, updated[name])
+	}
+	for name := range updated {
+		if _, ok := old[name]; !ok {
+			changed[name] = true
+		}
+	}
+	return changed, nil
+}
+
+// jsonFields returns the fields of v, which marshals to a JSON object
+func jsonFields(v interface{}) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// validateBook returns why a patched book cannot be stored, or "" if it can
+func validateBook(b models.Book) string {
+	if strings.TrimSpace(b.Title) == "" {
+		return "Missing title"
+	}
+	if b.PublishedYear < 0 {
+		return "Invalid published year"
+	}
+	return ""
+}
+
+// validateUser returns why a patched user cannot be stored, or "" if it can
+func validateUser(u models.User) string {
+	if strings.TrimSpace(u.Name) == "" {
+		return "Missing name"
+	}
+	if !strings.Contains(u.Email, "@") {
+		return "Invalid email"
+	}
+	return ""
+}
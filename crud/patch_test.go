@@ -0,0 +1,109 @@
+package crud
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang_project/patch"
+)
+
+func servePatch(handler http.HandlerFunc, target, contentType, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PATCH", target, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPatchBook(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	rr := servePatch(h.UpdateBook, "/books/update?id=1", patch.MergePatchType, `"1"`, `{"title":"Dune","genre":"Science Fiction"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("merge patch: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	book := readBook(t, h, 1)
+	if book.Title != "Dune" || book.Genre != "Science Fiction" || book.Author != "Seed Author" || book.ISBN != "0000000001" {
+		t.Errorf("merge patched book: got %+v", book)
+	}
+
+	rr = servePatch(h.UpdateBook, "/books/update?id=1", patch.JSONPatchType+"; charset=utf-8", `"2"`,
+		`[{"op":"test","path":"/title","value":"Dune"},{"op":"replace","path":"/published_year","value":1965}]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("JSON patch: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	if book := readBook(t, h, 1); book.PublishedYear != 1965 || book.Title != "Dune" || book.Version != 3 {
+		t.Errorf("JSON patched book: got %+v", book)
+	}
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		ifMatch     string
+		body        string
+		want        int
+	}{
+		{"plain JSON", "/books/update?id=1", "application/json", `"3"`, `{"title":"Dune"}`, http.StatusUnsupportedMediaType},
+		{"failed test", "/books/update?id=1", patch.JSONPatchType, `"3"`, `[{"op":"test","path":"/title","value":"Emma"}]`, http.StatusConflict},
+		{"malformed patch", "/books/update?id=1", patch.JSONPatchType, `"3"`, `[{"op":"remove","path":"/nothing"}]`, http.StatusBadRequest},
+		{"read-only field", "/books/update?id=1", patch.MergePatchType, `"3"`, `{"total_copies":5}`, http.StatusBadRequest},
+		{"another ID", "/books/update?id=1", patch.MergePatchType, `"3"`, `{"id":2}`, http.StatusBadRequest},
+		{"unknown field", "/books/update?id=1", patch.MergePatchType, `"3"`, `{"titel":"Dune"}`, http.StatusBadRequest},
+		{"wrong type", "/books/update?id=1", patch.MergePatchType, `"3"`, `{"published_year":"1965"}`, http.StatusBadRequest},
+		{"blank title", "/books/update?id=1", patch.MergePatchType, `"3"`, `{"title":" "}`, http.StatusBadRequest},
+		{"without If-Match", "/books/update?id=1", patch.MergePatchType, "", `{"title":"Dune"}`, http.StatusPreconditionRequired},
+		{"stale version", "/books/update?id=1", patch.MergePatchType, `"2"`, `{"title":"Dune"}`, http.StatusPreconditionFailed},
+		{"missing ID", "/books/update", patch.MergePatchType, `"3"`, `{"title":"Dune"}`, http.StatusBadRequest},
+		{"unknown book", "/books/update?id=99", patch.MergePatchType, `"1"`, `{"title":"Dune"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rr := servePatch(h.UpdateBook, tt.target, tt.contentType, tt.ifMatch, tt.body); rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+	}
+	if book := readBook(t, h, 1); book.Version != 3 {
+		t.Errorf("refused patches changed the book: got %+v", book)
+	}
+}
+
+func TestPatchBookkeeper(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	rr := servePatch(h.UpdateBookkeeper, "/bookkeepers/update?id=1", patch.MergePatchType, `"1"`, `{"name":"Amir Rahimi"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("merge patch: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	bookkeeper, err := h.users.Get(1, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bookkeeper.Name != "Amir Rahimi" || bookkeeper.Email != "amir@gmail.com" || !bookkeeper.IsActive {
+		t.Errorf("patched bookkeeper: got %+v", bookkeeper)
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		body    string
+		want    int
+	}{
+		{"password", h.UpdateBookkeeper, "/bookkeepers/update?id=1", `{"password":"secret"}`, http.StatusBadRequest},
+		{"role", h.UpdateBookkeeper, "/bookkeepers/update?id=1", `{"role":"user"}`, http.StatusBadRequest},
+		{"invalid email", h.UpdateBookkeeper, "/bookkeepers/update?id=1", `{"email":"amir"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rr := servePatch(tt.handler, tt.target, patch.MergePatchType, "*", tt.body); rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+	}
+	if after, err := h.users.Get(1, "admin"); err != nil || after.Password != bookkeeper.Password || after.Role != "admin" || after.Email != "amir@gmail.com" {
+		t.Errorf("bookkeeper after patches: got %+v, %v", after, err)
+	}
+}
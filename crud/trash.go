@@ -0,0 +1,271 @@
+package crud
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"golang_project/audit"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// DefaultRetention is how long deleted books and users stay in the trash
+// when TRASH_RETENTION is not set
+const DefaultRetention = 30 * 24 * time.Hour
+
+// RetentionFromEnv returns the retention period configured through
+// TRASH_RETENTION, such as 720h, falling back to DefaultRetention
+func RetentionFromEnv() (time.Duration, error) {
+	raw := os.Getenv("TRASH_RETENTION")
+	if raw == "" {
+		return DefaultRetention, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, errors.New("crud: TRASH_RETENTION must be a positive duration")
+	}
+	return d, nil
+}
+
+// Purger permanently removes the books and users that have been in the trash
+// for longer than the retention period
+type Purger struct {
+	books     store.BookRepository
+	users     store.UserRepository
+	audit     *audit.Log
+	retention time.Duration
+}
+
+// NewPurger returns a Purger emptying the trash of books and users after
+// retention. Every purged book and user is recorded in auditLog.
+func NewPurger(books store.BookRepository, users store.UserRepository, auditLog *audit.Log, retention time.Duration) *Purger {
+	return &Purger{books: books, users: users, audit: auditLog, retention: retention}
+}
+
+// Purge removes what was deleted more than the retention period before now
+// and returns the IDs of the books and users removed
+func (p *Purger) Purge(now time.Time) (books, users []int, err error) {
+	cutoff := now.Add(-p.retention)
+
+	// The trash is read first so the audit log can show what was purged
+	trashedBooks, err := p.books.ListDeleted()
+	if err != nil {
+		return nil, nil, err
+	}
+	trashedUsers, err := p.users.ListDeleted("")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	books, err = p.books.Purge(cutoff)
+	if err != nil {
+		return nil, nil, err
+	}
+	purged := make(map[int]bool, len(books))
+	for _, id := range books {
+		purged[id] = true
+	}
+	for _, book := range trashedBooks {
+		if purged[book.ID] {
+			p.audit.RecordSystem(models.AuditPurge, "book", book.ID, book, nil)
+		}
+	}
+
+	users, err = p.users.Purge(cutoff)
+	if err != nil {
+		return books, nil, err
+	}
+	purged = make(map[int]bool, len(users))
+	for _, id := range users {
+		purged[id] = true
+	}
+	for _, user := range trashedUsers {
+		if purged[user.ID] {
+			p.audit.RecordSystem(models.AuditPurge, userEntity(user.Role), user.ID, user, nil)
+		}
+	}
+	return books, users, nil
+}
+
+// Run purges the trash now and then every interval, forever
+func (p *Purger) Run(interval time.Duration) {
+	for {
+		books, users, err := p.Purge(time.Now().UTC())
+		if err != nil {
+			log.Printf("purging the trash: %v", err)
+		} else if len(books) > 0 || len(users) > 0 {
+			log.Printf("purged books %v and users %v from the trash", books, users)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// ListTrashedBooks handles the request to list deleted books
+// @Summary List deleted books
+// @Description Get the books in the trash, most recently deleted first. They are purged for good once
+// @Description the retention period has passed.
+// @Tags books
+// @Produce json
+// @Success 200 {array} models.Book
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Router /books/trash [get]
+func (h *Handler) ListTrashedBooks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	books, err := h.books.ListDeleted()
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(books)
+}
+
+// RestoreBook handles the request to restore a deleted book
+// @Summary Restore a deleted book
+// @Description Take a book out of the trash, with its copies
+// @Tags books
+// @Produce plain
+// @Param id query string true "Book ID"
+// @Success 200 {string} string "Book restored successfully"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "Book not in the trash"
+// @Router /books/restore [post]
+func (h *Handler) RestoreBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+	id, ok := queryID(w, r, "Missing book ID")
+	if !ok {
+		return
+	}
+
+	before, err := h.books.GetWithDeleted(id)
+	if err == nil {
+		err = h.books.Restore(id)
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, "Book not in the trash")
+		return
+	}
+	if after, err := h.books.Get(id); err == nil {
+		h.audit.Record(r, models.AuditRestore, "book", id, before, after)
+	}
+
+	w.Write([]byte("Book restored successfully"))
+}
+
+// ListTrashedUsers handles the request to list deleted users
+// @Summary List deleted users
+// @Description Get the patron accounts in the trash, most recently deleted first. They are purged for
+// @Description good once the retention period has passed.
+// @Tags users
+// @Produce json
+// @Success 200 {array} models.User
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Router /users/trash [get]
+func (h *Handler) ListTrashedUsers(w http.ResponseWriter, r *http.Request) {
+	h.listTrashedUsers(w, r, "user")
+}
+
+// RestoreUser handles the request to restore a deleted user
+// @Summary Restore a deleted user
+// @Description Take a patron account out of the trash. The sessions it had stay signed out.
+// @Tags users
+// @Produce plain
+// @Param id query string true "User ID"
+// @Success 200 {string} string "User restored successfully"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "User not in the trash"
+// @Router /users/restore [post]
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	h.restoreUser(w, r, "user", "user", "User")
+}
+
+// ListTrashedBookkeepers handles the request to list deleted bookkeepers
+// @Summary List deleted bookkeepers
+// @Description Get the bookkeeper accounts in the trash, most recently deleted first
+// @Tags bookkeepers
+// @Produce json
+// @Success 200 {array} models.User
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Router /bookkeepers/trash [get]
+func (h *Handler) ListTrashedBookkeepers(w http.ResponseWriter, r *http.Request) {
+	h.listTrashedUsers(w, r, "admin")
+}
+
+// RestoreBookkeeper handles the request to restore a deleted bookkeeper
+// @Summary Restore a deleted bookkeeper
+// @Description Take a bookkeeper account out of the trash. The sessions it had stay signed out.
+// @Tags bookkeepers
+// @Produce plain
+// @Param id query string true "Bookkeeper ID"
+// @Success 200 {string} string "Bookkeeper restored successfully"
+// @Failure 403 {object} problem.Problem "Forbidden"
+// @Failure 404 {object} problem.Problem "Bookkeeper not in the trash"
+// @Router /bookkeepers/restore [post]
+func (h *Handler) RestoreBookkeeper(w http.ResponseWriter, r *http.Request) {
+	h.restoreUser(w, r, "admin", "bookkeeper", "Bookkeeper")
+}
+
+func (h *Handler) listTrashedUsers(w http.ResponseWriter, r *http.Request, role string) {
+	if r.Method != http.MethodGet {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	users, err := h.users.ListDeleted(role)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	for i := range users {
+		users[i].Password = ""
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// userEntity names accounts with the role in the audit log
+func userEntity(role string) string {
+	if role == "user" {
+		return "user"
+	}
+	return "bookkeeper"
+}
+
+// restoreUser takes the account with the role and the id query parameter out
+// of the trash. entity names it in the audit log and label in responses.
+func (h *Handler) restoreUser(w http.ResponseWriter, r *http.Request, role, entity, label string) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+	id, ok := queryID(w, r, "Missing "+entity+" ID")
+	if !ok {
+		return
+	}
+
+	before, err := h.users.GetWithDeleted(id, role)
+	if err == nil {
+		err = h.users.Restore(id, role)
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, label+" not in the trash")
+		return
+	}
+	if after, err := h.users.Get(id, role); err == nil {
+		h.audit.Record(r, models.AuditRestore, entity, id, before, after)
+	}
+
+	w.Write([]byte(label + " restored successfully"))
+}
@@ -0,0 +1,131 @@
+package crud
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang_project/audit"
+	"golang_project/models"
+	"golang_project/store"
+)
+
+func serveTrash(h http.HandlerFunc, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	// Deletes require If-Match, which any version satisfies here
+	req.Header.Set("If-Match", "*")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTrashAndRestoreBook(t *testing.T) {
+	h, _ := newTestHandlers(t)
+
+	if rr := serveTrash(h.DeleteBook, "DELETE", "/books/delete?id=2"); rr.Code != http.StatusOK {
+		t.Fatalf("delete: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serveTrash(h.ReadBook, "GET", "/books/read?id=2"); rr.Code != http.StatusNotFound {
+		t.Errorf("read deleted book: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr := serveTrash(h.HandleBooks, "GET", "/books")
+	var books []models.Book
+	if err := json.NewDecoder(rr.Body).Decode(&books); err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range books {
+		if book.ID == 2 {
+			t.Errorf("deleted book listed: %+v", book)
+		}
+	}
+
+	rr = serveTrash(h.ListTrashedBooks, "GET", "/books/trash")
+	var trash []models.Book
+	if err := json.NewDecoder(rr.Body).Decode(&trash); err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != 2 || trash[0].DeletedAt == nil {
+		t.Fatalf("trash: got %+v", trash)
+	}
+
+	if rr := serveTrash(h.RestoreBook, "POST", "/books/restore?id=2"); rr.Code != http.StatusOK {
+		t.Fatalf("restore: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serveTrash(h.RestoreBook, "POST", "/books/restore?id=2"); rr.Code != http.StatusNotFound {
+		t.Errorf("restore twice: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if book := readBook(t, h, 2); book.ID != 2 || book.DeletedAt != nil {
+		t.Errorf("restored book: got %+v", book)
+	}
+}
+
+func TestPurger(t *testing.T) {
+	h, _ := newTestHandlers(t)
+	now := time.Now().UTC()
+	if err := h.books.Delete(1, 0, now.Add(-31*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.books.Delete(2, 0, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.users.Delete(1, "", 0, now.Add(-40*24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := store.Open(filepath.Join(t.TempDir(), "audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	entries := store.NewSQLiteAuditRepository(db)
+
+	books, users, err := NewPurger(h.books, h.users, audit.NewLog(entries), DefaultRetention).Purge(now)
+	if err != nil || len(books) != 1 || books[0] != 1 || len(users) != 1 || users[0] != 1 {
+		t.Fatalf("purge: got books %v and users %v, %v want [1] and [1]", books, users, err)
+	}
+	purges, err := entries.List(store.AuditFilter{Action: models.AuditPurge, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(purges) != 2 {
+		t.Fatalf("audited purges: got %+v", purges)
+	}
+	for _, entry := range purges {
+		if entry.Actor != audit.SystemActor || entry.ActorID != nil || entry.EntityID != 1 {
+			t.Errorf("audited purge: got %+v", entry)
+		}
+	}
+	if purges[0].Entity != "bookkeeper" || purges[1].Entity != "book" {
+		t.Errorf("purged entities: got %s and %s want bookkeeper and book", purges[0].Entity, purges[1].Entity)
+	}
+	if _, err := h.books.GetWithDeleted(1); err == nil {
+		t.Errorf("expired book still in the trash")
+	}
+	if _, err := h.books.GetWithDeleted(2); err != nil {
+		t.Errorf("recent book purged: %v", err)
+	}
+}
+
+func TestRetentionFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", DefaultRetention, false},
+		{"168h", 7 * 24 * time.Hour, false},
+		{"-1h", 0, true},
+		{"a week", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRASH_RETENTION", tt.value)
+		got, err := RetentionFromEnv()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%q: got %v, %v want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
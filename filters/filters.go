@@ -1,17 +1,22 @@
 package filters
 
 import (
-	"database/sql"
 	"encoding/json"
 	"golang_project/models"
+	"golang_project/paging"
+	"golang_project/problem"
+	"golang_project/store"
 	"net/http"
-	"strings"
 )
 
-func CheckErr(err error) {
-	if err != nil {
-		panic(err)
-	}
+// Handler serves the book filtering and search endpoints
+type Handler struct {
+	books store.BookRepository
+}
+
+// NewHandler returns a Handler backed by the given book repository
+func NewHandler(books store.BookRepository) *Handler {
+	return &Handler{books: books}
 }
 
 // FilterBooksByGenre filters books by genre
@@ -20,29 +25,29 @@ func CheckErr(err error) {
 // @Tags books
 // @Produce json
 // @Param genre query string true "Genre"
+// @Param limit query int false "Books per page (default 50, at most 100)"
+// @Param cursor query string false "Cursor from X-Next-Cursor of the previous page"
+// @Param sort query string false "Fields to sort by, e.g. genre,-published_year"
+// @Param fields query string false "Fields to include, e.g. id,title"
 // @Success 200 {array} models.Book
+// @Failure 400 {object} problem.Problem "Invalid pagination parameters"
 // @Router /books/filter/genre [get]
-func FilterBooksByGenre(w http.ResponseWriter, r *http.Request) {
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
+func (h *Handler) FilterBooksByGenre(w http.ResponseWriter, r *http.Request) {
 	genre := r.URL.Query().Get("genre")
 
-	rows, err := db.Query("SELECT ID, Title, Author, ISBN, PublishedYear, Genre FROM books WHERE Genre = ?", genre)
-	CheckErr(err)
-	defer rows.Close()
+	params, err := paging.Parse(r, "")
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
 
-	var books []models.Book
-	for rows.Next() {
-		var book models.Book
-		err = rows.Scan(&book.ID, &book.Title, &book.Author, &book.ISBN, &book.PublishedYear, &book.Genre)
-		CheckErr(err)
-		books = append(books, book)
+	books, err := h.books.Filter(models.Filter{Genre: genre}, params.Page())
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	params.Write(w, r, books)
 }
 
 // FilterBooksByAuthor filters books by author
@@ -51,29 +56,29 @@ func FilterBooksByGenre(w http.ResponseWriter, r *http.Request) {
 // @Tags books
 // @Produce json
 // @Param author query string true "Author"
+// @Param limit query int false "Books per page (default 50, at most 100)"
+// @Param cursor query string false "Cursor from X-Next-Cursor of the previous page"
+// @Param sort query string false "Fields to sort by, e.g. genre,-published_year"
+// @Param fields query string false "Fields to include, e.g. id,title"
 // @Success 200 {array} models.Book
+// @Failure 400 {object} problem.Problem "Invalid pagination parameters"
 // @Router /books/filter/author [get]
-func FilterBooksByAuthor(w http.ResponseWriter, r *http.Request) {
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
+func (h *Handler) FilterBooksByAuthor(w http.ResponseWriter, r *http.Request) {
 	author := r.URL.Query().Get("author")
 
-	rows, err := db.Query("SELECT ID, Title, Author, ISBN, PublishedYear, Genre FROM books WHERE Author = ?", author)
-	CheckErr(err)
-	defer rows.Close()
+	params, err := paging.Parse(r, "")
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
 
-	var books []models.Book
-	for rows.Next() {
-		var book models.Book
-		err = rows.Scan(&book.ID, &book.Title, &book.Author, &book.ISBN, &book.PublishedYear, &book.Genre)
-		CheckErr(err)
-		books = append(books, book)
+	books, err := h.books.Filter(models.Filter{Author: author}, params.Page())
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	params.Write(w, r, books)
 }
 
 // FilterBooksByPublishedYear filters books by published year
@@ -82,60 +87,78 @@ func FilterBooksByAuthor(w http.ResponseWriter, r *http.Request) {
 // @Tags books
 // @Produce json
 // @Param published_year query string true "Published Year"
+// @Param limit query int false "Books per page (default 50, at most 100)"
+// @Param cursor query string false "Cursor from X-Next-Cursor of the previous page"
+// @Param sort query string false "Fields to sort by, e.g. genre,-published_year"
+// @Param fields query string false "Fields to include, e.g. id,title"
 // @Success 200 {array} models.Book
+// @Failure 400 {object} problem.Problem "Invalid pagination parameters"
 // @Router /books/filter/year [get]
-func FilterBooksByPublishedYear(w http.ResponseWriter, r *http.Request) {
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
+func (h *Handler) FilterBooksByPublishedYear(w http.ResponseWriter, r *http.Request) {
 	publishedYear := r.URL.Query().Get("published_year")
 
-	rows, err := db.Query("SELECT ID, Title, Author, ISBN, PublishedYear, Genre FROM books WHERE PublishedYear = ?", publishedYear)
-	CheckErr(err)
-	defer rows.Close()
+	params, err := paging.Parse(r, "")
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
 
-	var books []models.Book
-	for rows.Next() {
-		var book models.Book
-		err = rows.Scan(&book.ID, &book.Title, &book.Author, &book.ISBN, &book.PublishedYear, &book.Genre)
-		CheckErr(err)
-		books = append(books, book)
+	books, err := h.books.Filter(models.Filter{PublishedYear: publishedYear}, params.Page())
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	params.Write(w, r, books)
 }
 
 // SearchBooksByTitle searches books by title
 // @Summary Search Books by Title
-// @Description Search books by title
+// @Description Search books whose title contains every word, best match first. Falls back to
+// @Description an exact title match, ordered by ID, when full-text search is not available.
 // @Tags books
 // @Produce json
 // @Param title query string true "Title"
+// @Param limit query int false "Books per page (default 20, or 50 without full-text search; at most 100)"
+// @Param cursor query string false "Cursor from X-Next-Cursor of the previous page"
+// @Param fields query string false "Fields to include, e.g. id,title"
 // @Success 200 {array} models.Book
+// @Failure 400 {object} problem.Problem "Invalid pagination parameters"
 // @Router /books/search/title [get]
-func SearchBooksByTitle(w http.ResponseWriter, r *http.Request) {
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
+func (h *Handler) SearchBooksByTitle(w http.ResponseWriter, r *http.Request) {
 	title := r.URL.Query().Get("title")
 
-	rows, err := db.Query("SELECT ID, Title, Author, ISBN, PublishedYear, Genre FROM books WHERE Title = ?", title)
-	CheckErr(err)
-	defer rows.Close()
-
-	var books []models.Book
-	for rows.Next() {
-		var book models.Book
-		err = rows.Scan(&book.ID, &book.Title, &book.Author, &book.ISBN, &book.PublishedYear, &book.Genre)
-		CheckErr(err)
-		books = append(books, book)
+	available, err := h.books.SearchAvailable()
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	if !available {
+		params, err := paging.Parse(r, "id")
+		if err != nil {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+			return
+		}
+		books, err := h.books.Filter(models.Filter{Title: title}, params.Page())
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+			return
+		}
+		params.Write(w, r, books)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	params, err := paging.ParseSearch(r)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	results, err := h.books.Search(title, "title", params.Page())
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	params.WriteResultBooks(w, r, results)
 }
 
 // AdvancedFilterBooks filters books based on multiple criteria
@@ -144,61 +167,43 @@ func SearchBooksByTitle(w http.ResponseWriter, r *http.Request) {
 // @Tags books
 // @Produce json
 // @Param filter body models.Filter true "Filter"
+// @Param limit query int false "Books per page (default 50, at most 100)"
+// @Param cursor query string false "Cursor from X-Next-Cursor of the previous page"
+// @Param sort query string false "Fields to sort by, e.g. genre,-published_year"
+// @Param fields query string false "Fields to include, e.g. id,title"
 // @Success 200 {array} models.Book
+// @Failure 400 {object} problem.Problem "Invalid pagination parameters"
 // @Router /books/filter/advanced [post]
-func AdvancedFilterBooks(w http.ResponseWriter, r *http.Request) {
-	db, err := sql.Open("sqlite3", "/Users/amir/Documents/newtestgo/test.db")
-	CheckErr(err)
-	defer db.Close()
-
+func (h *Handler) AdvancedFilterBooks(w http.ResponseWriter, r *http.Request) {
 	var filter models.Filter
-	err = json.NewDecoder(r.Body).Decode(&filter)
-	CheckErr(err)
-
-	var filters []string
-	var args []interface{}
-
-	if filter.Genre != "" {
-		filters = append(filters, "Genre = ?")
-		args = append(args, filter.Genre)
-	}
-	if filter.Author != "" {
-		filters = append(filters, "Author = ?")
-		args = append(args, filter.Author)
-	}
-	if filter.PublishedYear != "" {
-		filters = append(filters, "PublishedYear = ?")
-		args = append(args, filter.PublishedYear)
-	}
-	if filter.Title != "" {
-		filters = append(filters, "Title = ?")
-		args = append(args, filter.Title)
+	err := json.NewDecoder(r.Body).Decode(&filter)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
 	}
 
-	query := "SELECT ID, Title, Author, ISBN, PublishedYear, Genre FROM books"
-	if len(filters) > 0 {
-		query += " WHERE " + strings.Join(filters, " AND ")
+	params, err := paging.Parse(r, sortForOrder(filter.SortOrder))
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
 	}
 
-	// Add sorting order based on published year
-	if filter.SortOrder == "asc" {
-		query += " ORDER BY PublishedYear ASC"
-	} else if filter.SortOrder == "desc" {
-		query += " ORDER BY PublishedYear DESC"
+	books, err := h.books.Filter(filter, params.Page())
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
 	}
 
-	rows, err := db.Query(query, args...)
-	CheckErr(err)
-	defer rows.Close()
+	params.Write(w, r, books)
+}
 
-	var books []models.Book
-	for rows.Next() {
-		var book models.Book
-		err = rows.Scan(&book.ID, &book.Title, &book.Author, &book.ISBN, &book.PublishedYear, &book.Genre)
-		CheckErr(err)
-		books = append(books, book)
+// sortForOrder turns the sort_order of an advanced filter into a sort parameter
+func sortForOrder(order string) string {
+	switch order {
+	case "asc":
+		return "published_year"
+	case "desc":
+		return "-published_year"
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	return ""
 }
@@ -5,12 +5,36 @@ import (
 	"encoding/json"
 	"golang_project/auth"
 	"golang_project/models"
+	"golang_project/store"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 )
 
-func loginAsBookkeeper(t *testing.T) *http.Cookie {
+func newTestHandler(t *testing.T) *Handler {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	books := store.NewSQLiteBookRepository(db)
+	seed := []models.Book{
+		{Title: "Test Book", Author: "Test Author", ISBN: "1234567890", PublishedYear: 2023, Genre: "Test Genre"},
+		{Title: "Test Book", Author: "Test Author", ISBN: "1234567891", PublishedYear: 2023, Genre: "Test Genre"},
+		{Title: "The Great Gatsby", Author: "F. Scott Fitzgerald", ISBN: "9780743273565", PublishedYear: 1925, Genre: "Fiction"},
+	}
+	for i := range seed {
+		if err := books.Create(&seed[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewHandler(books)
+}
+
+func loginAsBookkeeper(t *testing.T, authHandler *auth.Handler) *http.Cookie {
 	credentials := auth.Credentials{
 		Username: "amir@gmail.com",
 		Password: "1234",
@@ -27,7 +51,7 @@ func loginAsBookkeeper(t *testing.T) *http.Cookie {
 	req.Header.Set("Content-Type", "application/json")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(auth.LoginBookkeeper)
+	handler := http.HandlerFunc(authHandler.Token)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -47,13 +71,15 @@ func loginAsBookkeeper(t *testing.T) *http.Cookie {
 }
 
 func TestFilterBooksByGenre(t *testing.T) {
+	h := newTestHandler(t)
+
 	req, err := http.NewRequest("GET", "/books/filter/genre?genre=Test Genre", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(FilterBooksByGenre)
+	handler := http.HandlerFunc(h.FilterBooksByGenre)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -75,13 +101,15 @@ func TestFilterBooksByGenre(t *testing.T) {
 }
 
 func TestFilterBooksByAuthor(t *testing.T) {
+	h := newTestHandler(t)
+
 	req, err := http.NewRequest("GET", "/books/filter/author?author=Test Author", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(FilterBooksByAuthor)
+	handler := http.HandlerFunc(h.FilterBooksByAuthor)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -103,13 +131,15 @@ func TestFilterBooksByAuthor(t *testing.T) {
 }
 
 func TestFilterBooksByPublishedYear(t *testing.T) {
+	h := newTestHandler(t)
+
 	req, err := http.NewRequest("GET", "/books/filter/year?published_year=2023", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(FilterBooksByPublishedYear)
+	handler := http.HandlerFunc(h.FilterBooksByPublishedYear)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -131,13 +161,15 @@ func TestFilterBooksByPublishedYear(t *testing.T) {
 }
 
 func TestSearchBooksByTitle(t *testing.T) {
+	h := newTestHandler(t)
+
 	req, err := http.NewRequest("GET", "/books/search/title?title=Test Book", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(SearchBooksByTitle)
+	handler := http.HandlerFunc(h.SearchBooksByTitle)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -156,9 +188,36 @@ func TestSearchBooksByTitle(t *testing.T) {
 			t.Errorf("Expected all books to have title 'Test Book', but got %s", book.Title)
 		}
 	}
+
+	// One book per page, with or without full-text search
+	var ids []int
+	target := "/books/search/title?title=Test+Book&limit=1"
+	for page := 0; target != "" && page < 3; page++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("page %d: got %v want %v", page, rr.Code, http.StatusOK)
+		}
+		var books []models.Book
+		if err := json.Unmarshal(rr.Body.Bytes(), &books); err != nil {
+			t.Fatal(err)
+		}
+		for _, book := range books {
+			ids = append(ids, book.ID)
+		}
+		target = ""
+		if next := rr.Header().Get("X-Next-Cursor"); next != "" {
+			target = "/books/search/title?title=Test+Book&limit=1&cursor=" + next
+		}
+	}
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Errorf("paged title search: got books %v want the two test books", ids)
+	}
 }
 
 func TestAdvancedFilterBooks(t *testing.T) {
+	h := newTestHandler(t)
+
 	filter := models.Filter{
 		Genre:        "Test Genre",
 		Author:       "Test Author",
@@ -178,7 +237,7 @@ func TestAdvancedFilterBooks(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(AdvancedFilterBooks)
+	handler := http.HandlerFunc(h.AdvancedFilterBooks)
 	handler.ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusOK {
@@ -206,3 +265,64 @@ func TestAdvancedFilterBooks(t *testing.T) {
 		}
 	}
 }
+
+func TestSearchBooks(t *testing.T) {
+	h := newTestHandler(t)
+
+	req, err := http.NewRequest("GET", "/books/search", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.SearchBooks).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("missing query: got %v want %v", status, http.StatusBadRequest)
+	}
+
+	req, err = http.NewRequest("GET", "/books/search?q=gats", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.SearchBooks).ServeHTTP(rr, req)
+	if rr.Code == http.StatusNotImplemented {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var results []models.SearchResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(results) != 1 || results[0].Title != "The Great Gatsby" || results[0].Snippet != "The Great <mark>Gatsby</mark>" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.SearchBooks).ServeHTTP(rr, httptest.NewRequest("GET", "/books/search?q=test&limit=1", nil))
+	next := rr.Header().Get("X-Next-Cursor")
+	if rr.Code != http.StatusOK || next == "" {
+		t.Fatalf("first page: got %v and cursor %q", rr.Code, next)
+	}
+	var first []models.SearchResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &first); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.SearchBooks).ServeHTTP(rr, httptest.NewRequest("GET", "/books/search?q=test&limit=1&cursor="+next, nil))
+	var second []models.SearchResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &second); err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || len(second) != 1 || first[0].ID == second[0].ID || rr.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("paged search: got %+v then %+v", first, second)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(h.SearchBooks).ServeHTTP(rr, httptest.NewRequest("GET", "/books/search?q=test&sort=title", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("sorted search: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,51 @@
+package filters
+
+import (
+	"errors"
+	"net/http"
+
+	"golang_project/paging"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// SearchBooks handles the full-text book search request
+// @Summary Search books
+// @Description Full-text search over title, author, genre and ISBN. Every word must match, and
+// @Description matches the start of longer words too. Results are ranked with title matches first
+// @Description and carry a snippet with the matching words wrapped in <mark> tags.
+// @Tags books
+// @Produce json
+// @Param q query string true "Search words"
+// @Param limit query int false "Results per page (default 20, at most 100)"
+// @Param cursor query string false "Cursor from X-Next-Cursor of the previous page"
+// @Param fields query string false "Fields to include, e.g. id,title,score"
+// @Success 200 {array} models.SearchResult
+// @Failure 400 {object} problem.Problem "Missing search query or invalid pagination parameters"
+// @Failure 501 {object} problem.Problem "Full-text search is not available"
+// @Router /books/search [get]
+func (h *Handler) SearchBooks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing search query")
+		return
+	}
+
+	params, err := paging.ParseSearch(r)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	results, err := h.books.Search(query, "", params.Page())
+	if errors.Is(err, store.ErrSearchUnavailable) {
+		problem.Write(w, r, http.StatusNotImplemented, problem.CodeNotImplemented, "Full-text search is not available")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	params.WriteResults(w, r, results)
+}
@@ -0,0 +1,184 @@
+package fines
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang_project/audit"
+	"golang_project/auth"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// TransactionRequest is the body of a payment or waiver. The amount is in cents.
+type TransactionRequest struct {
+	UserID int    `json:"user_id"`
+	Amount int    `json:"amount"`
+	Note   string `json:"note"`
+}
+
+// Handler serves the fine ledger endpoints
+type Handler struct {
+	ledger store.LedgerRepository
+	users  store.UserRepository
+	audit  *audit.Log
+}
+
+// NewHandler returns a Handler backed by the given repositories. Payments and
+// waivers are recorded in auditLog.
+func NewHandler(ledger store.LedgerRepository, users store.UserRepository, auditLog *audit.Log) *Handler {
+	return &Handler{ledger: ledger, users: users, audit: auditLog}
+}
+
+// ReadAccount handles the request to view a patron's fine ledger
+// @Summary Read a patron's fines
+// @Description Get the balance and ledger entries of a patron. Amounts are in cents.
+// @Tags fines
+// @Produce json
+// @Param user_id query string true "User ID"
+// @Success 200 {object} models.Account
+// @Failure 404 {object} problem.Problem "User not found"
+// @Router /fines [get]
+func (h *Handler) ReadAccount(w http.ResponseWriter, r *http.Request) {
+	raw := r.URL.Query().Get("user_id")
+	if raw == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Missing user ID")
+		return
+	}
+	userID, err := strconv.Atoi(raw)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid user_id")
+		return
+	}
+
+	if _, err := h.users.Get(userID, ""); err != nil {
+		problem.StoreError(w, r, err, "User not found")
+		return
+	}
+	h.writeAccount(w, r, userID)
+}
+
+// ReadMyAccount handles the request to view the caller's fine ledger
+// @Summary Read my fines
+// @Description Get the balance and ledger entries of the logged-in patron. Amounts are in cents.
+// @Tags fines
+// @Produce json
+// @Success 200 {object} models.Account
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Router /user/fines [get]
+func (h *Handler) ReadMyAccount(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	h.writeAccount(w, r, claims.UserID)
+}
+
+// RecordPayment handles the request to record a payment towards a patron's fines
+// @Summary Record a payment
+// @Description Credit a payment against a patron's outstanding fines. Amounts are in cents.
+// @Tags fines
+// @Accept json
+// @Produce json
+// @Param payment body fines.TransactionRequest true "Patron and amount"
+// @Success 201 {object} models.LedgerEntry
+// @Failure 400 {object} problem.Problem "Amount must be positive"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 409 {object} problem.Problem "Amount exceeds the outstanding balance"
+// @Router /fines/pay [post]
+func (h *Handler) RecordPayment(w http.ResponseWriter, r *http.Request) {
+	h.credit(w, r, models.LedgerPayment)
+}
+
+// WaiveFees handles the request to waive part or all of a patron's fines
+// @Summary Waive fees
+// @Description Forgive part or all of a patron's outstanding fines. Amounts are in cents.
+// @Tags fines
+// @Accept json
+// @Produce json
+// @Param waiver body fines.TransactionRequest true "Patron and amount"
+// @Success 201 {object} models.LedgerEntry
+// @Failure 400 {object} problem.Problem "Amount must be positive"
+// @Failure 404 {object} problem.Problem "User not found"
+// @Failure 409 {object} problem.Problem "Amount exceeds the outstanding balance"
+// @Router /fines/waive [post]
+func (h *Handler) WaiveFees(w http.ResponseWriter, r *http.Request) {
+	h.credit(w, r, models.LedgerWaiver)
+}
+
+// credit records a payment or waiver of kind against the patron named in the request
+func (h *Handler) credit(w http.ResponseWriter, r *http.Request, kind string) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req TransactionRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	if req.Amount <= 0 {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Amount must be positive")
+		return
+	}
+
+	if _, err := h.users.Get(req.UserID, ""); err != nil {
+		problem.StoreError(w, r, err, "User not found")
+		return
+	}
+
+	entry := models.LedgerEntry{
+		UserID:     req.UserID,
+		Kind:       kind,
+		Amount:     req.Amount,
+		Note:       req.Note,
+		RecordedBy: claims.Username,
+		CreatedAt:  time.Now().UTC(),
+	}
+	err = h.ledger.Credit(&entry)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Amount exceeds the outstanding balance")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error recording transaction")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "ledger_entry", entry.ID, nil, entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+func (h *Handler) writeAccount(w http.ResponseWriter, r *http.Request, userID int) {
+	entries, err := h.ledger.ListByUser(userID)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	balance, err := h.ledger.Balance(userID)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	if entries == nil {
+		entries = []models.LedgerEntry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Account{UserID: userID, Balance: balance, Entries: entries})
+}
@@ -0,0 +1,128 @@
+package fines
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang_project/audit"
+	"golang_project/auth"
+	"golang_project/models"
+	"golang_project/store"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestHandler(t *testing.T) (*Handler, store.LedgerRepository, store.AuditRepository, models.User) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	users := store.NewSQLiteUserRepository(db)
+	ledger := store.NewSQLiteLedgerRepository(db)
+	entries := store.NewSQLiteAuditRepository(db)
+
+	patron := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Role: "user"}
+	if err := users.Create(&patron); err != nil {
+		t.Fatal(err)
+	}
+	charge := models.LedgerEntry{UserID: patron.ID, Kind: models.LedgerCharge, Amount: 500, CreatedAt: time.Now().UTC()}
+	if err := ledger.Create(&charge); err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(ledger, users, audit.NewLog(entries)), ledger, entries, patron
+}
+
+func serveAsBookkeeper(t *testing.T, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	tokenString, _, err := auth.IssueToken(models.User{ID: 1, Email: "amir@gmail.com", Role: "admin"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	rr := httptest.NewRecorder()
+	auth.AuthMiddleware(handler).ServeHTTP(rr, req)
+	return rr
+}
+
+func credit(t *testing.T, handler http.HandlerFunc, path string, body TransactionRequest) *httptest.ResponseRecorder {
+	jsonPayload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", path, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return serveAsBookkeeper(t, handler, req)
+}
+
+func TestRecordPaymentAndWaiver(t *testing.T) {
+	h, ledger, entries, patron := newTestHandler(t)
+
+	tests := []struct {
+		handler http.HandlerFunc
+		path    string
+		body    TransactionRequest
+		want    int
+	}{
+		{h.RecordPayment, "/fines/pay", TransactionRequest{UserID: patron.ID, Amount: 0}, http.StatusBadRequest},
+		{h.RecordPayment, "/fines/pay", TransactionRequest{UserID: patron.ID + 100, Amount: 100}, http.StatusNotFound},
+		{h.RecordPayment, "/fines/pay", TransactionRequest{UserID: patron.ID, Amount: 600}, http.StatusConflict},
+		{h.RecordPayment, "/fines/pay", TransactionRequest{UserID: patron.ID, Amount: 300, Note: "cash"}, http.StatusCreated},
+		{h.WaiveFees, "/fines/waive", TransactionRequest{UserID: patron.ID, Amount: 200}, http.StatusCreated},
+		{h.WaiveFees, "/fines/waive", TransactionRequest{UserID: patron.ID, Amount: 1}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if rr := credit(t, tt.handler, tt.path, tt.body); rr.Code != tt.want {
+			t.Errorf("%s %+v: got %v want %v", tt.path, tt.body, rr.Code, tt.want)
+		}
+	}
+
+	balance, err := ledger.Balance(patron.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 0 {
+		t.Errorf("balance is %d, want 0", balance)
+	}
+
+	audited, err := entries.List(store.AuditFilter{Action: models.AuditCreate, Entity: "ledger_entry", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(audited) != 2 || audited[0].Actor != "amir@gmail.com" || !strings.Contains(string(audited[0].Changes), `"kind":{"after":"waiver"}`) {
+		t.Errorf("audited transactions: got %+v", audited)
+	}
+}
+
+func TestReadAccount(t *testing.T) {
+	h, _, _, patron := newTestHandler(t)
+
+	if rr := credit(t, h.RecordPayment, "/fines/pay", TransactionRequest{UserID: patron.ID, Amount: 100}); rr.Code != http.StatusCreated {
+		t.Fatalf("payment: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/fines?user_id=%d", patron.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := serveAsBookkeeper(t, h.ReadAccount, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var account models.Account
+	if err := json.Unmarshal(rr.Body.Bytes(), &account); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if account.Balance != 400 || len(account.Entries) != 2 || account.Entries[1].RecordedBy != "amir@gmail.com" {
+		t.Errorf("unexpected account: %+v", account)
+	}
+}
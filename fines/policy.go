@@ -0,0 +1,84 @@
+package fines
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Rate is the overdue fine for one kind of book. Amounts are in cents.
+type Rate struct {
+	// DailyRate is charged for every started day a loan is overdue
+	DailyRate int `json:"daily_rate"`
+	// MaxFine caps the fines of a single loan, renewals included; zero
+	// means no cap
+	MaxFine int `json:"max_fine"`
+}
+
+// Policy sets the overdue fines and when a patron's balance blocks new checkouts
+type Policy struct {
+	// Default applies to books whose genre has no rate of its own
+	Default Rate `json:"default"`
+	// Genres maps a book genre to its rate
+	Genres map[string]Rate `json:"genres"`
+	// BlockThreshold is the balance above which a patron may not check out books
+	BlockThreshold int `json:"block_threshold"`
+}
+
+// DefaultPolicy is used when no fines file is configured
+var DefaultPolicy = Policy{
+	Default:        Rate{DailyRate: 25, MaxFine: 1000},
+	BlockThreshold: 1000,
+}
+
+// LoadPolicy reads a JSON fine policy from path
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return Policy{}, err
+	}
+	return policy, nil
+}
+
+// PolicyFromEnv loads the policy named by FINES_FILE, falling back to DefaultPolicy
+func PolicyFromEnv() (Policy, error) {
+	path := os.Getenv("FINES_FILE")
+	if path == "" {
+		return DefaultPolicy, nil
+	}
+	return LoadPolicy(path)
+}
+
+// RateFor returns the rate that applies to books of genre
+func (p Policy) RateFor(genre string) Rate {
+	if rate, ok := p.Genres[genre]; ok {
+		return rate
+	}
+	return p.Default
+}
+
+// Fine returns the fine for a book of genre due at due and returned at returned
+func (p Policy) Fine(genre string, due, returned time.Time) int {
+	late := returned.Sub(due)
+	if late <= 0 {
+		return 0
+	}
+	days := int((late + 24*time.Hour - 1) / (24 * time.Hour))
+
+	rate := p.RateFor(genre)
+	fine := days * rate.DailyRate
+	if rate.MaxFine > 0 && fine > rate.MaxFine {
+		fine = rate.MaxFine
+	}
+	return fine
+}
+
+// Blocks reports whether a patron owing balance may not check out books
+func (p Policy) Blocks(balance int) bool {
+	return balance > p.BlockThreshold
+}
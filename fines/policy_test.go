@@ -0,0 +1,57 @@
+package fines
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFine(t *testing.T) {
+	policy := Policy{
+		Default: Rate{DailyRate: 25, MaxFine: 100},
+		Genres:  map[string]Rate{"Reference": {DailyRate: 200}},
+	}
+	due := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		genre    string
+		returned time.Time
+		want     int
+	}{
+		{"Fiction", due.Add(-time.Hour), 0},
+		{"Fiction", due, 0},
+		{"Fiction", due.Add(time.Minute), 25},
+		{"Fiction", due.Add(48 * time.Hour), 50},
+		{"Fiction", due.Add(30 * 24 * time.Hour), 100},
+		{"Reference", due.Add(30 * 24 * time.Hour), 6000},
+	}
+	for _, tt := range tests {
+		if got := policy.Fine(tt.genre, due, tt.returned); got != tt.want {
+			t.Errorf("Fine(%q, %v late) = %d, want %d", tt.genre, tt.returned.Sub(due), got, tt.want)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fines.json")
+	err := os.WriteFile(path, []byte(`{"default": {"daily_rate": 10}, "genres": {"DVD": {"daily_rate": 100, "max_fine": 500}}, "block_threshold": 200}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rate := policy.RateFor("DVD"); rate.DailyRate != 100 || rate.MaxFine != 500 {
+		t.Errorf("unexpected DVD rate: %+v", rate)
+	}
+	if rate := policy.RateFor("Fiction"); rate.DailyRate != 10 {
+		t.Errorf("expected genres missing from the file to use the default rate, got %+v", rate)
+	}
+	if policy.Blocks(200) || !policy.Blocks(201) {
+		t.Errorf("expected only balances above 200 to block checkouts")
+	}
+}
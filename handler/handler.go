@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"database/sql"
 	"fmt"
+	"golang_project/audit"
 	"golang_project/auth"
 	"golang_project/crud"
 	"golang_project/filters"
+	"golang_project/fines"
+	"golang_project/loans"
+	"golang_project/mail"
+	"golang_project/problem"
+	"golang_project/store"
 	"log"
 	"net/http"
 
@@ -23,14 +30,18 @@ import (
 func MainPage(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Welcome to the main page!\n")
 	fmt.Fprintf(w, "Please visit /books to see the list of books\n")
+	fmt.Fprintf(w, "Please visit /books/search?q= to search for books\n")
 	fmt.Fprintf(w, "Please visit /users to see the list of users")
 	fmt.Fprintf(w, "Please visit /login to login")
 	fmt.Fprintf(w, "Please visit /login/bookkeepers to login as a bookkeeper")
+	fmt.Fprintf(w, "Please visit /sessions to see your sessions")
+	fmt.Fprintf(w, "Please visit /password/reset/request to reset a forgotten password")
 	fmt.Fprintf(w, "Please visit /admin to see the admin page")
 	fmt.Fprintf(w, "Please visit /user to see the user page")
 	fmt.Fprintf(w, "Please visit /books/create to create a book")
 	fmt.Fprintf(w, "Please visit /books/update to update a book")
 	fmt.Fprintf(w, "Please visit /books/delete to delete a book")
+	fmt.Fprintf(w, "Please visit /copies to see the copies of a book")
 	fmt.Fprintf(w, "Please visit /users/update to update a user")
 	fmt.Fprintf(w, "Please visit /users/delete to delete a user")
 	fmt.Fprintf(w, "Please visit /bookkeepers/update to update a bookkeeper")
@@ -38,42 +49,155 @@ func MainPage(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Please visit /bookkeepers/create to create a bookkeeper")
 	fmt.Fprintf(w, "Please visit /bookkeepers/read to read a bookkeeper")
 	fmt.Fprintf(w, "Please visit /secret to see the secret page")
+	fmt.Fprintf(w, "Please visit /loans/checkout to check out a book")
+	fmt.Fprintf(w, "Please visit /loans/return to return a book")
+	fmt.Fprintf(w, "Please visit /user/loans to see your loans")
+	fmt.Fprintf(w, "Please visit /loans/renew to renew a loan")
+	fmt.Fprintf(w, "Please visit /holds/place to place a hold on a book")
+	fmt.Fprintf(w, "Please visit /user/holds to see your holds")
+	fmt.Fprintf(w, "Please visit /user/fines to see your fines")
 }
 
-// HandleRequest sets up the routes and starts the server
-func HandleRequest() {
-	http.HandleFunc("/", MainPage)
-	http.HandleFunc("/login", auth.LoginUser)
-	http.HandleFunc("/login/bookkeepers", auth.LoginBookkeeper)
-	http.HandleFunc("/books", crud.HandleBooks)
-	http.HandleFunc("/books/read", crud.ReadBook)
-	http.HandleFunc("/books/filter/genre", filters.FilterBooksByGenre)
-	http.HandleFunc("/books/filter/author", filters.FilterBooksByAuthor)
-	http.HandleFunc("/books/filter/year", filters.FilterBooksByPublishedYear)
-	http.HandleFunc("/books/filter/advanced", filters.AdvancedFilterBooks)
-	http.HandleFunc("/books/search/title", filters.SearchBooksByTitle)
-	http.HandleFunc("/users/create", crud.CreateUser)
-	http.HandleFunc("/users/read", crud.ReadUser)
+// NewRouter builds the application routes on top of the given database,
+// guarding protected routes with the permissions granted by policy, slowing
+// down repeated failed logins according to throttle and charging overdue
+// fines according to finePolicy. Account emails are sent
+// through mailer with links pointing to appURL. Patrons can also sign in at
+// the OpenID Connect provider oidc, unless it is nil. Every request is tagged with
+// a request ID, panics are answered with a 500 problem, tokens of revoked
+// sessions are refused and API keys are accepted in place of tokens.
+func NewRouter(db *sql.DB, policy auth.Policy, throttle auth.Throttle, finePolicy fines.Policy, mailer mail.Mailer, appURL string, oidc *auth.OIDCProvider) http.Handler {
+	books := store.NewSQLiteBookRepository(db)
+	users := store.NewSQLiteUserRepository(db)
+	copies := store.NewSQLiteCopyRepository(db)
+	loanRepo := store.NewSQLiteLoanRepository(db)
+	holds := store.NewSQLiteHoldRepository(db)
+	ledger := store.NewSQLiteLedgerRepository(db)
+	renewals := store.NewSQLiteRenewalPolicyRepository(db)
+	sessions := store.NewSQLiteSessionRepository(db)
+	resets := store.NewSQLitePasswordResetRepository(db)
+	verifications := store.NewSQLiteEmailVerificationRepository(db)
+	twoFactor := store.NewSQLiteTwoFactorRepository(db)
+	apiKeys := store.NewSQLiteAPIKeyRepository(db)
+	limiter := auth.NewLimiter(store.NewSQLiteLoginAttemptRepository(db), throttle)
+
+	auditLog := audit.NewLog(store.NewSQLiteAuditRepository(db))
+	authHandler := auth.NewHandler(users, sessions, resets, verifications, twoFactor, apiKeys, limiter, mailer, appURL, auditLog)
+	crudHandler := crud.NewHandler(books, users, copies, authHandler, auditLog, store.NewSQLiteBookRevisionRepository(db))
+	filtersHandler := filters.NewHandler(books)
+	loansHandler := loans.NewHandler(loanRepo, holds, books, copies, users, ledger, renewals, finePolicy, auditLog)
+	finesHandler := fines.NewHandler(ledger, users, auditLog)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", MainPage)
+	mux.HandleFunc("/auth/token", authHandler.Token)
+	// Aliases of /auth/token kept for older clients
+	mux.HandleFunc("/login", authHandler.Token)
+	mux.HandleFunc("/login/bookkeepers", authHandler.Token)
+	mux.HandleFunc("/refresh", authHandler.Refresh)
+	if oidc != nil {
+		oidcHandler := auth.NewOIDCHandler(authHandler, oidc, store.NewSQLiteOIDCRepository(db))
+		mux.HandleFunc("/login/oidc", oidcHandler.Login)
+		mux.HandleFunc("/login/oidc/callback", oidcHandler.Callback)
+		mux.Handle("/login/oidc/link", policy.RequirePermission(auth.PermAccountRead)(http.HandlerFunc(oidcHandler.Link)))
+	}
+	mux.HandleFunc("/.well-known/jwks.json", auth.ServeJWKS)
+	mux.HandleFunc("/password/reset", authHandler.ResetPasswordForm)
+	mux.HandleFunc("/password/reset/request", authHandler.RequestPasswordReset)
+	mux.HandleFunc("/password/reset/confirm", authHandler.ConfirmPasswordReset)
+	mux.HandleFunc("/books", crudHandler.HandleBooks)
+	mux.HandleFunc("/books/read", crudHandler.ReadBook)
+	mux.HandleFunc("/books/filter/genre", filtersHandler.FilterBooksByGenre)
+	mux.HandleFunc("/books/filter/author", filtersHandler.FilterBooksByAuthor)
+	mux.HandleFunc("/books/filter/year", filtersHandler.FilterBooksByPublishedYear)
+	mux.HandleFunc("/books/filter/advanced", filtersHandler.AdvancedFilterBooks)
+	mux.HandleFunc("/books/search", filtersHandler.SearchBooks)
+	mux.HandleFunc("/books/search/title", filtersHandler.SearchBooksByTitle)
+	mux.HandleFunc("/users/create", crudHandler.CreateUser)
+	mux.HandleFunc("/users/read", crudHandler.ReadUser)
+	mux.HandleFunc("/users/verify", authHandler.VerifyEmail)
+	mux.HandleFunc("/users/verify/resend", authHandler.ResendVerification)
+
+	requireAccount := policy.RequirePermission(auth.PermAccountRead)
+	requireBooksWrite := policy.RequirePermission(auth.PermBooksWrite)
+	requireFinesWrite := policy.RequirePermission(auth.PermFinesWrite)
+	requireHoldsWrite := policy.RequirePermission(auth.PermHoldsWrite)
+	requireLoansRenew := policy.RequirePermission(auth.PermLoansRenew)
+	requireLoansWrite := policy.RequirePermission(auth.PermLoansWrite)
+	requireUsersWrite := policy.RequirePermission(auth.PermUsersWrite)
+	requireUsersAdmin := policy.RequirePermission(auth.PermUsersAdmin)
+	requireAuditRead := policy.RequirePermission(auth.PermAuditRead)
+	requireAPIKeysWrite := policy.RequirePermission(auth.PermAPIKeysWrite)
 
-	http.Handle("/admin", auth.BookkeeperMiddleware(http.HandlerFunc(auth.AdminHandler)))
-	http.Handle("/user", auth.AuthMiddleware(http.HandlerFunc(auth.UserHandler)))
+	mux.Handle("/admin", requireUsersAdmin(http.HandlerFunc(auth.AdminHandler)))
+	mux.Handle("/user", requireAccount(http.HandlerFunc(auth.UserHandler)))
+	// Signed-in accounts reach these without their role's permissions, so
+	// that roles requiring two-factor authentication can enrol or log out
+	mux.Handle("/logout", auth.AuthMiddleware(http.HandlerFunc(authHandler.Logout)))
+	mux.Handle("/2fa/enroll", auth.AuthMiddleware(http.HandlerFunc(authHandler.EnrollTwoFactor)))
+	mux.Handle("/2fa/confirm", auth.AuthMiddleware(http.HandlerFunc(authHandler.ConfirmTwoFactor)))
+	mux.Handle("/2fa/disable", auth.AuthMiddleware(http.HandlerFunc(authHandler.DisableTwoFactor)))
+	mux.Handle("/2fa/reset", requireUsersAdmin(http.HandlerFunc(authHandler.ResetTwoFactor)))
+	mux.Handle("/password/change", requireAccount(http.HandlerFunc(authHandler.ChangePassword)))
+	mux.Handle("/sessions", requireAccount(http.HandlerFunc(authHandler.ListSessions)))
+	mux.Handle("/sessions/revoke", requireAccount(http.HandlerFunc(authHandler.RevokeSession)))
+	mux.Handle("/user/loans", requireAccount(http.HandlerFunc(loansHandler.ListMyLoans)))
+	mux.Handle("/user/holds", requireAccount(http.HandlerFunc(loansHandler.ListMyHolds)))
+	mux.Handle("/user/fines", requireAccount(http.HandlerFunc(finesHandler.ReadMyAccount)))
+	mux.Handle("/loans/renew", requireLoansRenew(http.HandlerFunc(loansHandler.RenewLoan)))
+	mux.Handle("/holds/place", requireHoldsWrite(http.HandlerFunc(loansHandler.PlaceHold)))
+	mux.Handle("/holds/cancel", requireHoldsWrite(http.HandlerFunc(loansHandler.CancelHold)))
 
 	// Protected routes for bookkeepers
-	http.Handle("/books/create", auth.BookkeeperMiddleware(http.HandlerFunc(crud.CreateBook)))
-	http.Handle("/books/update", auth.BookkeeperMiddleware(http.HandlerFunc(crud.UpdateBook)))
-	http.Handle("/books/delete", auth.BookkeeperMiddleware(http.HandlerFunc(crud.DeleteBook)))
-	http.Handle("/users/update", auth.BookkeeperMiddleware(http.HandlerFunc(crud.UpdateUser)))
-	http.Handle("/users/delete", auth.BookkeeperMiddleware(http.HandlerFunc(crud.DeleteUser)))
-	http.Handle("/bookkeepers/update", auth.BookkeeperMiddleware(http.HandlerFunc(crud.UpdateBookkeeper)))
-	http.Handle("/bookkeepers/delete", auth.BookkeeperMiddleware(http.HandlerFunc(crud.DeleteBookkeeper)))
-	http.Handle("/bookkeepers/create", auth.BookkeeperMiddleware(http.HandlerFunc(crud.CreateBookkeeper)))
-	http.Handle("/bookkeepers/read", auth.BookkeeperMiddleware(http.HandlerFunc(crud.ReadBookkeeper)))
-	http.Handle("/secret", auth.BookkeeperMiddleware(http.HandlerFunc(SecretPage)))
+	mux.Handle("/books/create", requireBooksWrite(http.HandlerFunc(crudHandler.CreateBook)))
+	mux.Handle("/books/update", requireBooksWrite(http.HandlerFunc(crudHandler.UpdateBook)))
+	mux.Handle("/books/delete", requireBooksWrite(http.HandlerFunc(crudHandler.DeleteBook)))
+	mux.Handle("/books/trash", requireBooksWrite(http.HandlerFunc(crudHandler.ListTrashedBooks)))
+	mux.Handle("/books/restore", requireBooksWrite(http.HandlerFunc(crudHandler.RestoreBook)))
+	mux.Handle("/books/{id}/history", requireBooksWrite(http.HandlerFunc(crudHandler.ListBookHistory)))
+	mux.Handle("/books/{id}/history/diff", requireBooksWrite(http.HandlerFunc(crudHandler.DiffBookRevisions)))
+	mux.Handle("/books/{id}/revert", requireBooksWrite(http.HandlerFunc(crudHandler.RevertBook)))
+	mux.Handle("/copies", requireBooksWrite(http.HandlerFunc(crudHandler.ListCopies)))
+	mux.Handle("/copies/create", requireBooksWrite(http.HandlerFunc(crudHandler.CreateCopy)))
+	mux.Handle("/copies/read", requireBooksWrite(http.HandlerFunc(crudHandler.ReadCopy)))
+	mux.Handle("/copies/update", requireBooksWrite(http.HandlerFunc(crudHandler.UpdateCopy)))
+	mux.Handle("/copies/delete", requireBooksWrite(http.HandlerFunc(crudHandler.DeleteCopy)))
+	mux.Handle("/users/update", requireUsersWrite(http.HandlerFunc(crudHandler.UpdateUser)))
+	mux.Handle("/users/delete", requireUsersWrite(http.HandlerFunc(crudHandler.DeleteUser)))
+	mux.Handle("/users/trash", requireUsersWrite(http.HandlerFunc(crudHandler.ListTrashedUsers)))
+	mux.Handle("/users/restore", requireUsersWrite(http.HandlerFunc(crudHandler.RestoreUser)))
+	mux.Handle("/users/unlock", requireUsersWrite(http.HandlerFunc(authHandler.UnlockAccount)))
+	mux.Handle("/login/failures", requireUsersWrite(http.HandlerFunc(authHandler.ListLoginFailures)))
+	mux.Handle("/bookkeepers/update", requireUsersAdmin(http.HandlerFunc(crudHandler.UpdateBookkeeper)))
+	mux.Handle("/bookkeepers/delete", requireUsersAdmin(http.HandlerFunc(crudHandler.DeleteBookkeeper)))
+	mux.Handle("/bookkeepers/trash", requireUsersAdmin(http.HandlerFunc(crudHandler.ListTrashedBookkeepers)))
+	mux.Handle("/bookkeepers/restore", requireUsersAdmin(http.HandlerFunc(crudHandler.RestoreBookkeeper)))
+	mux.Handle("/bookkeepers/create", requireUsersAdmin(http.HandlerFunc(crudHandler.CreateBookkeeper)))
+	mux.Handle("/bookkeepers/read", requireUsersAdmin(http.HandlerFunc(crudHandler.ReadBookkeeper)))
+	mux.Handle("/secret", requireUsersAdmin(http.HandlerFunc(SecretPage)))
+	mux.Handle("/audit", requireAuditRead(http.HandlerFunc(auditLog.ListEntries)))
+	mux.Handle("/apikeys", requireAPIKeysWrite(http.HandlerFunc(authHandler.ListAPIKeys)))
+	mux.Handle("/apikeys/create", requireAPIKeysWrite(http.HandlerFunc(authHandler.CreateAPIKey)))
+	mux.Handle("/apikeys/revoke", requireAPIKeysWrite(http.HandlerFunc(authHandler.RevokeAPIKey)))
+	mux.Handle("/loans/checkout", requireLoansWrite(http.HandlerFunc(loansHandler.CheckoutBook)))
+	mux.Handle("/loans/return", requireLoansWrite(http.HandlerFunc(loansHandler.ReturnBook)))
+	mux.Handle("/loans/active", requireLoansWrite(http.HandlerFunc(loansHandler.ListActiveLoans)))
+	mux.Handle("/loans/policy", requireLoansWrite(http.HandlerFunc(loansHandler.ReadRenewalPolicy)))
+	mux.Handle("/loans/policy/update", requireLoansWrite(http.HandlerFunc(loansHandler.UpdateRenewalPolicy)))
+	mux.Handle("/holds", requireLoansWrite(http.HandlerFunc(loansHandler.ListHolds)))
+	mux.Handle("/fines", requireFinesWrite(http.HandlerFunc(finesHandler.ReadAccount)))
+	mux.Handle("/fines/pay", requireFinesWrite(http.HandlerFunc(finesHandler.RecordPayment)))
+	mux.Handle("/fines/waive", requireFinesWrite(http.HandlerFunc(finesHandler.WaiveFees)))
 
 	// Swagger endpoint
-	http.HandleFunc("/swagger/", httpSwagger.WrapHandler)
+	mux.HandleFunc("/swagger/", httpSwagger.WrapHandler)
 
-	log.Fatal(http.ListenAndServe(":9000", nil))
+	return problem.RequestID(problem.Recover(auth.WithSessions(sessions)(auth.WithAPIKeys(apiKeys, users)(mux))))
+}
+
+// HandleRequest sets up the routes and starts the server
+func HandleRequest(db *sql.DB, policy auth.Policy, throttle auth.Throttle, finePolicy fines.Policy, mailer mail.Mailer, appURL string, oidc *auth.OIDCProvider) {
+	log.Fatal(http.ListenAndServe(":9000", NewRouter(db, policy, throttle, finePolicy, mailer, appURL, oidc)))
 }
 
 // SecretPage handles the secret page request
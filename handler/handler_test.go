@@ -0,0 +1,596 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"golang_project/auth"
+	"golang_project/fines"
+	"golang_project/mail"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestRouter(t *testing.T) (http.Handler, *sql.DB) {
+	router, db, _ := newTestRouterWithMailer(t)
+	return router, db
+}
+
+func newTestRouterWithMailer(t *testing.T) (http.Handler, *sql.DB, *mail.MemoryMailer) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mailer := &mail.MemoryMailer{}
+	return NewRouter(db, auth.DefaultPolicy, auth.DefaultThrottle, fines.DefaultPolicy, mailer, "http://localhost:9000", nil), db, mailer
+}
+
+// bearerToken registers an account with the given email and role and returns
+// the Authorization header of a fresh session for it
+func bearerToken(t *testing.T, db *sql.DB, email, role string) string {
+	user := models.User{Name: email, Email: email, IsActive: true, Role: role}
+	if err := store.NewSQLiteUserRepository(db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	session := models.Session{ID: email, UserID: user.ID, RefreshHash: email, CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := store.NewSQLiteSessionRepository(db).Create(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, _, err := auth.IssueToken(user, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + tokenString
+}
+
+func createBookRequest(t *testing.T) *http.Request {
+	book := models.Book{
+		Title:         "Test Book",
+		Author:        "Test Author",
+		ISBN:          "1234567890",
+		PublishedYear: 2023,
+		Genre:         "Test Genre",
+	}
+	jsonPayload, err := json.Marshal(book)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/books/create", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestCreateBookRequiresBooksWrite(t *testing.T) {
+	router, db := newTestRouter(t)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"anonymous", "", http.StatusUnauthorized},
+		{"user", bearerToken(t, db, "jane.doe@example.com", "user"), http.StatusForbidden},
+		{"bookkeeper", bearerToken(t, db, "emma.watson@example.com", "bookkeeper"), http.StatusCreated},
+		{"admin", bearerToken(t, db, "amir@gmail.com", "admin"), http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createBookRequest(t)
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != tt.want {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tt.want)
+			}
+		})
+	}
+}
+
+func TestBookkeeperRoutesRequireUsersAdmin(t *testing.T) {
+	router, db := newTestRouter(t)
+
+	req, err := http.NewRequest("GET", "/bookkeepers/read?id=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", bearerToken(t, db, "emma.watson@example.com", "bookkeeper"))
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusForbidden)
+	}
+}
+
+func TestUserRoutesOnlyReachPatrons(t *testing.T) {
+	router, db := newTestRouter(t)
+	token := bearerToken(t, db, "emma.watson@example.com", "bookkeeper")
+	users := store.NewSQLiteUserRepository(db)
+	admin := models.User{Name: "amir rahimi", Email: "amir@gmail.com", IsActive: true, Role: "admin"}
+	if err := users.Create(&admin); err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(admin.ID)
+
+	tests := []struct {
+		name, method, target, contentType, body string
+	}{
+		{"put", "PUT", "/users/update", "application/json", `{"id":` + id + `,"name":"amir","email":"emma.watson+admin@example.com","membership_date":"2024-01-01","is_active":true}`},
+		{"patch", "PATCH", "/users/update?id=" + id, "application/merge-patch+json", `{"email":"emma.watson+admin@example.com"}`},
+		{"delete", "DELETE", "/users/delete?id=" + id, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", token)
+			req.Header.Set("If-Match", `"1"`)
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if rr.Code != http.StatusNotFound {
+				t.Errorf("%s of an admin by a bookkeeper: got %v want %v", tt.method, rr.Code, http.StatusNotFound)
+			}
+		})
+	}
+
+	if after, err := users.Get(admin.ID, ""); err != nil || after.Email != admin.Email || after.Version != 1 {
+		t.Errorf("admin after the attempts: got %+v, %v want it unchanged", after, err)
+	}
+}
+
+func TestErrorsAreProblems(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	req := createBookRequest(t)
+	req.Header.Set(problem.RequestIDHeader, "test-request")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusUnauthorized {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusUnauthorized)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, problem.ContentType)
+	}
+
+	var p problem.Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if p.Code != problem.CodeUnauthorized || p.Status != http.StatusUnauthorized || p.RequestID != "test-request" {
+		t.Errorf("unexpected problem: %+v", p)
+	}
+}
+
+func TestRegistrationRequiresVerification(t *testing.T) {
+	router, db, mailer := newTestRouterWithMailer(t)
+
+	serve := func(method, target string, body interface{}) *httptest.ResponseRecorder {
+		jsonPayload, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, target, bytes.NewBuffer(jsonPayload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	registration := models.User{Name: "Mallory", Email: "mallory@example.com", IsActive: true, Password: "secret", Role: "admin"}
+	if rr := serve("POST", "/users/create", registration); rr.Code != http.StatusCreated {
+		t.Fatalf("register: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	user, err := store.NewSQLiteUserRepository(db).GetByEmail("mallory@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != "user" || user.IsActive || user.EmailVerifiedAt != nil {
+		t.Errorf("expected an inactive, unverified patron: %+v", user)
+	}
+
+	credentials := auth.Credentials{Username: "mallory@example.com", Password: "secret"}
+	if rr := serve("POST", "/login", credentials); rr.Code != http.StatusForbidden {
+		t.Errorf("login before verification: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	// The password alone does not reveal that the address is unverified
+	twoFactor := store.NewSQLiteTwoFactorRepository(db)
+	if err := twoFactor.Begin(user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatal(err)
+	}
+	if err := twoFactor.Confirm(user.ID, 0, time.Now().UTC(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if rr := serve("POST", "/login", credentials); rr.Code != http.StatusUnauthorized {
+		t.Errorf("login before verification without a two-factor code: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+	if err := twoFactor.Delete(user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := mailer.Messages()
+	if len(messages) != 1 || messages[0].To != "mallory@example.com" {
+		t.Fatalf("expected one verification email: %+v", messages)
+	}
+	start := strings.Index(messages[0].Body, "http://localhost:9000")
+	link := strings.Fields(messages[0].Body[start:])[0]
+	target := strings.TrimPrefix(link, "http://localhost:9000")
+
+	if rr := serve("GET", target, nil); rr.Code != http.StatusOK {
+		t.Fatalf("verify: got %v want %v", rr.Code, http.StatusOK)
+	}
+	entries, err := store.NewSQLiteAuditRepository(db).List(store.AuditFilter{Action: models.AuditUpdate, Entity: "user", EntityID: user.ID, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ActorID == nil || *entries[0].ActorID != user.ID || !strings.Contains(string(entries[0].Changes), `"is_active":{"before":false,"after":true}`) {
+		t.Errorf("audited verification: got %+v", entries)
+	}
+	if rr := serve("GET", target, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("verify twice: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := serve("POST", "/login", credentials); rr.Code != http.StatusOK {
+		t.Errorf("login after verification: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestPasswordResetLinkIsRouted(t *testing.T) {
+	router, db, mailer := newTestRouterWithMailer(t)
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("old password"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Password: string(hashedPassword), Role: "user", EmailVerifiedAt: &now}
+	if err := store.NewSQLiteUserRepository(db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := serve("POST", "/password/reset/request", "application/json", `{"email":"jane.doe@example.com"}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("request: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	messages := mailer.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected one reset email: %+v", messages)
+	}
+	start := strings.Index(messages[0].Body, "http://localhost:9000")
+	link := strings.Fields(messages[0].Body[start:])[0]
+	target := strings.TrimPrefix(link, "http://localhost:9000")
+
+	rr := serve("GET", target, "", "")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("reset link: got %v %q want %v", rr.Code, rr.Header().Get("Content-Type"), http.StatusOK)
+	}
+	token := strings.TrimPrefix(target, "/password/reset?token=")
+	if !strings.Contains(rr.Body.String(), `action="/password/reset/confirm"`) || !strings.Contains(rr.Body.String(), token) {
+		t.Errorf("reset form: got %s", rr.Body.String())
+	}
+
+	// The form posts the token and password form-encoded
+	form := url.Values{"token": {token}, "password": {"new password"}}.Encode()
+	if rr := serve("POST", "/password/reset/confirm", "application/x-www-form-urlencoded", form); rr.Code != http.StatusOK {
+		t.Fatalf("confirm: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := serve("POST", "/login", "application/json", `{"email":"jane.doe@example.com","password":"new password"}`); rr.Code != http.StatusOK {
+		t.Errorf("login with the new password: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	router, db := newTestRouter(t)
+	bookkeeper := bearerToken(t, db, "emma.watson@example.com", "bookkeeper")
+
+	send := func(method, target, body string, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	for name, body := range map[string]string{
+		"missing name":  `{"scopes":["books:write"]}`,
+		"unknown scope": `{"name":"scanner","scopes":["books:burn"]}`,
+		"key scope":     `{"name":"scanner","scopes":["apikeys:write"]}`,
+		"expired":       `{"name":"scanner","scopes":["books:write"],"expires_at":"` + past + `"}`,
+	} {
+		if rr := send("POST", "/apikeys/create", body, "Authorization", bookkeeper); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %v want %v", name, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	rr := send("POST", "/apikeys/create", `{"name":"scanner","scopes":["books:write","users:admin"]}`, "Authorization", bookkeeper)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create key: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var created auth.APIKeyResponse
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(created.Key, created.APIKey.Prefix+".") || strings.Contains(created.APIKey.KeyHash, created.Key) {
+		t.Errorf("unexpected key %q with prefix %q", created.Key, created.APIKey.Prefix)
+	}
+
+	req := createBookRequest(t)
+	req.Header.Set(auth.APIKeyHeader, created.Key)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("scoped route: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		key    string
+		want   int
+	}{
+		{"unscoped route", "GET", "/fines?user_id=1", created.Key, http.StatusForbidden},
+		{"scope beyond the role", "GET", "/bookkeepers/read?id=1", created.Key, http.StatusForbidden},
+		{"key management", "GET", "/apikeys", created.Key, http.StatusForbidden},
+		{"session route", "POST", "/logout", created.Key, http.StatusUnauthorized},
+		{"wrong secret", "POST", "/books/delete?id=1", created.APIKey.Prefix + ".wrong", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if rr := send(tt.method, tt.target, "", auth.APIKeyHeader, tt.key); rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+	}
+
+	rr = send("GET", "/apikeys", "", "Authorization", bookkeeper)
+	var keys []models.APIKey
+	if err := json.NewDecoder(rr.Body).Decode(&keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].LastUsedAt == nil || keys[0].KeyHash != "" {
+		t.Errorf("unexpected keys %+v", keys)
+	}
+
+	if rr := send("POST", "/apikeys/revoke?id="+strconv.Itoa(created.APIKey.ID), "", "Authorization", bookkeeper); rr.Code != http.StatusOK {
+		t.Fatalf("revoke: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("GET", "/copies?book_id=1", "", auth.APIKeyHeader, created.Key); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked key: got %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	entries, err := store.NewSQLiteAuditRepository(db).List(store.AuditFilter{Entity: "api_key", EntityID: created.APIKey.ID, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != models.AuditUpdate || entries[1].Action != models.AuditCreate ||
+		entries[0].Actor != "emma.watson@example.com" || !strings.Contains(string(entries[0].Changes), `"revoked_at":{"after"`) {
+		t.Errorf("audited key: got %+v", entries)
+	}
+}
+
+func TestTokenTakesRoleFromAccount(t *testing.T) {
+	router, db := newTestRouter(t)
+	users := store.NewSQLiteUserRepository(db)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	for _, user := range []models.User{
+		{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Password: string(hashedPassword), Role: "user", EmailVerifiedAt: &now},
+		{Name: "Emma Watson", Email: "emma.watson@example.com", IsActive: true, Password: string(hashedPassword), Role: "bookkeeper"},
+	} {
+		if err := users.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		want   int
+		role   string
+	}{
+		{"patron", "/auth/token", `{"email":"jane.doe@example.com","password":"1234"}`, http.StatusOK, "user"},
+		{"bookkeeper", "/auth/token", `{"email":"emma.watson@example.com","password":"1234"}`, http.StatusOK, "bookkeeper"},
+		{"legacy username field", "/auth/token", `{"username":"jane.doe@example.com","password":"1234"}`, http.StatusOK, "user"},
+		{"login alias", "/login", `{"username":"emma.watson@example.com","password":"1234"}`, http.StatusOK, "bookkeeper"},
+		{"bookkeeper alias with a patron", "/login/bookkeepers", `{"username":"jane.doe@example.com","password":"1234"}`, http.StatusOK, "user"},
+		{"unknown username", "/auth/token", `{"username":"jane","password":"1234"}`, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+
+		var tokens auth.TokenResponse
+		if err := json.NewDecoder(rr.Body).Decode(&tokens); err != nil {
+			t.Fatal(err)
+		}
+		req = httptest.NewRequest("GET", "/user", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+		claims := &auth.Claims{}
+		handler := auth.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ = auth.ClaimsFromContext(r.Context())
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if claims.Role != tt.role {
+			t.Errorf("%s role: got %v want %v", tt.name, claims.Role, tt.role)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	router, db := newTestRouter(t)
+	bookkeeper := bearerToken(t, db, "emma.watson@example.com", "bookkeeper")
+
+	send := func(method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(problem.RequestIDHeader, "req-"+method)
+		req.Header.Set("If-Match", "*")
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send("POST", "/books/create", `{"title":"Dune","author":"Frank Herbert"}`, bookkeeper); rr.Code != http.StatusCreated {
+		t.Fatalf("create: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := send("PUT", "/books/update", `{"id":1,"title":"Dune Messiah","author":"Frank Herbert"}`, bookkeeper); rr.Code != http.StatusOK {
+		t.Fatalf("update: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("DELETE", "/books/delete?id=1", "", bookkeeper); rr.Code != http.StatusOK {
+		t.Fatalf("delete: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("DELETE", "/books/delete?id=1", "", bookkeeper); rr.Code != http.StatusNotFound {
+		t.Errorf("delete twice: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("POST", "/users/create", `{"name":"Mallory","email":"mallory@example.com","password":"secret"}`, ""); rr.Code != http.StatusCreated {
+		t.Fatalf("register: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	list := func(query string) []models.AuditEntry {
+		rr := send("GET", "/audit"+query, "", bookkeeper)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("audit%s: got %v want %v", query, rr.Code, http.StatusOK)
+		}
+		var entries []models.AuditEntry
+		if err := json.NewDecoder(rr.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	entries := list("?entity=book&entity_id=1")
+	if len(entries) != 3 || entries[0].Action != models.AuditDelete || entries[1].Action != models.AuditUpdate || entries[2].Action != models.AuditCreate {
+		t.Fatalf("book history: got %+v", entries)
+	}
+	update := entries[1]
+	if update.Actor != "emma.watson@example.com" || update.ActorID == nil || update.RequestID != "req-PUT" || update.IP == "" {
+		t.Errorf("unexpected update entry %+v", update)
+	}
+	if string(update.Changes) != `{"title":{"before":"Dune","after":"Dune Messiah"},"version":{"before":1,"after":2}}` {
+		t.Errorf("update changes: got %s", update.Changes)
+	}
+
+	registration := list("?entity=user")
+	if len(registration) != 1 || registration[0].ActorID != nil || strings.Contains(string(registration[0].Changes), "password") {
+		t.Errorf("registration: got %+v", registration)
+	}
+	if entries := list("?actor_id=" + strconv.Itoa(*update.ActorID) + "&action=update"); len(entries) != 1 {
+		t.Errorf("filter by actor and action: got %d entries want 1", len(entries))
+	}
+	if entries := list("?before=" + strconv.Itoa(update.ID) + "&limit=5"); len(entries) != 1 || entries[0].Action != models.AuditCreate {
+		t.Errorf("older entries: got %+v", entries)
+	}
+
+	if rr := send("GET", "/audit?since=yesterday", "", bookkeeper); rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid since: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+	if rr := send("GET", "/audit", "", bearerToken(t, db, "jane.doe@example.com", "user")); rr.Code != http.StatusForbidden {
+		t.Errorf("patron: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestBookHistoryRoutes(t *testing.T) {
+	router, db := newTestRouter(t)
+	bookkeeper := bearerToken(t, db, "emma.watson@example.com", "bookkeeper")
+	patron := bearerToken(t, db, "john.doe@example.com", "user")
+
+	send := func(method, target, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", token)
+		req.Header.Set("If-Match", "*")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send("POST", "/books/create", `{"title":"Dune","author":"Frank Herbert"}`, bookkeeper); rr.Code != http.StatusCreated {
+		t.Fatalf("create: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := send("PUT", "/books/update", `{"id":1,"title":"Dune Messiah","author":"Frank Herbert"}`, bookkeeper); rr.Code != http.StatusOK {
+		t.Fatalf("update: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr := send("GET", "/books/1/history", "", bookkeeper)
+	var revisions []models.BookRevision
+	if err := json.NewDecoder(rr.Body).Decode(&revisions); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Actor != "emma.watson@example.com" || revisions[0].ActorID == nil {
+		t.Fatalf("history: got %+v", revisions)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		want   int
+	}{
+		{"diff", "GET", "/books/1/history/diff?from=1&to=2", bookkeeper, http.StatusOK},
+		{"revert", "POST", "/books/1/revert?revision=1", bookkeeper, http.StatusOK},
+		{"history as patron", "GET", "/books/1/history", patron, http.StatusForbidden},
+		{"revert as patron", "POST", "/books/1/revert?revision=1", patron, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if rr := send(tt.method, tt.target, "", tt.token); rr.Code != tt.want {
+			t.Errorf("%s: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+	}
+}
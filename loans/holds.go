@@ -0,0 +1,240 @@
+package loans
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang_project/auth"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// HoldRequest is the body of a hold request
+type HoldRequest struct {
+	BookID int `json:"book_id"`
+}
+
+// PlaceHold handles the request to join the hold queue of a book
+// @Summary Place a hold
+// @Description Queue the logged-in patron for a book whose copies are all checked out
+// @Tags holds
+// @Accept json
+// @Produce json
+// @Param hold body loans.HoldRequest true "Book"
+// @Success 201 {object} models.Hold
+// @Failure 403 {object} problem.Problem "User account is inactive"
+// @Failure 404 {object} problem.Problem "Book not found"
+// @Failure 409 {object} problem.Problem "A copy is available or a hold already exists"
+// @Router /holds/place [post]
+func (h *Handler) PlaceHold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req HoldRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := h.expireHolds(now); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating holds")
+		return
+	}
+
+	user, err := h.users.Get(claims.UserID, "")
+	if err != nil {
+		problem.StoreError(w, r, err, "User not found")
+		return
+	}
+	if !user.IsActive {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeAccountInactive, "User account is inactive")
+		return
+	}
+
+	book, err := h.books.Get(req.BookID)
+	if err != nil {
+		problem.StoreError(w, r, err, "Book not found")
+		return
+	}
+	if book.AvailableCopies > 0 {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "A copy is available for checkout")
+		return
+	}
+
+	hold := models.Hold{UserID: user.ID, BookID: book.ID, CreatedAt: now}
+	err = h.holds.Create(&hold)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "You already have a hold on this book")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error creating hold")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "hold", hold.ID, nil, hold)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hold)
+}
+
+// CancelHold handles the request to leave a hold queue
+// @Summary Cancel a hold
+// @Description Cancel one of the logged-in patron's holds. A copy set aside for it passes to the next patron.
+// @Tags holds
+// @Param id query string true "Hold ID"
+// @Success 200 {string} string "Hold cancelled successfully"
+// @Failure 404 {object} problem.Problem "Hold not found"
+// @Failure 409 {object} problem.Problem "Hold is already closed"
+// @Router /holds/cancel [post]
+func (h *Handler) CancelHold(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, ok := queryInt(w, r, "id", "Missing hold ID")
+	if !ok {
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := h.expireHolds(now); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating holds")
+		return
+	}
+
+	hold, err := h.holds.Get(id)
+	if err == nil && hold.UserID != claims.UserID {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, "Hold not found")
+		return
+	}
+
+	err = h.holds.Close(hold.ID, models.HoldCancelled)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Hold is already closed")
+		return
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, "Hold not found")
+		return
+	}
+	if after, err := h.holds.Get(hold.ID); err == nil {
+		h.audit.Record(r, models.AuditUpdate, "hold", hold.ID, hold, after)
+	}
+
+	if hold.Status == models.HoldReady && hold.CopyID != 0 {
+		if err := h.releaseCopy(hold.BookID, hold.CopyID, now); err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating holds")
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Hold cancelled successfully"))
+}
+
+// ListHolds handles the request to list the hold queue of a book
+// @Summary List the hold queue of a book
+// @Description Get the waiting and ready holds on a book in queue order
+// @Tags holds
+// @Produce json
+// @Param book_id query string true "Book ID"
+// @Success 200 {array} models.Hold
+// @Router /holds [get]
+func (h *Handler) ListHolds(w http.ResponseWriter, r *http.Request) {
+	bookID, ok := queryInt(w, r, "book_id", "Missing book ID")
+	if !ok {
+		return
+	}
+
+	if err := h.expireHolds(time.Now().UTC()); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating holds")
+		return
+	}
+
+	holds, err := h.holds.ListOpenByBook(bookID)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(holds)
+}
+
+// ListMyHolds handles the request to list the caller's holds
+// @Summary List my holds
+// @Description Get the waiting and ready holds of the logged-in patron
+// @Tags holds
+// @Produce json
+// @Success 200 {array} models.Hold
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Router /user/holds [get]
+func (h *Handler) ListMyHolds(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := h.expireHolds(time.Now().UTC()); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating holds")
+		return
+	}
+
+	holds, err := h.holds.ListOpenByUser(claims.UserID)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(holds)
+}
+
+// releaseCopy offers a copy that just became free to the next patron waiting on its book
+func (h *Handler) releaseCopy(bookID, copyID int, now time.Time) error {
+	_, err := h.holds.PromoteNext(bookID, copyID, now, now.Add(PickupWindow))
+	return err
+}
+
+// expireHolds closes ready holds whose pickup window has ended and passes
+// their copies on. It runs before any decision that depends on the queues.
+func (h *Handler) expireHolds(now time.Time) error {
+	expired, err := h.holds.ExpireReady(now)
+	if err != nil {
+		return err
+	}
+	for _, hold := range expired {
+		if hold.CopyID == 0 {
+			continue
+		}
+		if err := h.releaseCopy(hold.BookID, hold.CopyID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package loans
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang_project/auth"
+	"golang_project/models"
+	"golang_project/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// serveAs runs handler behind AuthMiddleware with a token for user
+func serveAs(t *testing.T, user models.User, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	tokenString, _, err := auth.IssueToken(user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	rr := httptest.NewRecorder()
+	auth.AuthMiddleware(handler).ServeHTTP(rr, req)
+	return rr
+}
+
+func placeHold(t *testing.T, f fixture, user models.User) *httptest.ResponseRecorder {
+	jsonPayload, err := json.Marshal(HoldRequest{BookID: f.book.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/holds/place", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return serveAs(t, user, f.handler.PlaceHold, req)
+}
+
+func listHolds(t *testing.T, f fixture) []models.Hold {
+	req, err := http.NewRequest("GET", fmt.Sprintf("/holds?book_id=%d", f.book.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(f.handler.ListHolds).ServeHTTP(rr, req)
+
+	var holds []models.Hold
+	if err := json.Unmarshal(rr.Body.Bytes(), &holds); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return holds
+}
+
+func addPatron(t *testing.T, f fixture, email string) models.User {
+	user := models.User{Name: email, Email: email, IsActive: true, Role: "user"}
+	if err := store.NewSQLiteUserRepository(f.db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestPlaceHoldRequiresAllCopiesOut(t *testing.T) {
+	f := newFixture(t)
+
+	if rr := placeHold(t, f, f.active); rr.Code != http.StatusConflict {
+		t.Errorf("copy available: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	borrower := addPatron(t, f, "borrower@example.com")
+	if rr := checkout(t, f.handler, borrower.ID, f.copy.ID); rr.Code != http.StatusCreated {
+		t.Fatalf("checkout: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	if rr := placeHold(t, f, f.active); rr.Code != http.StatusCreated {
+		t.Fatalf("all copies out: got %v want %v. Response body: %v", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	if rr := placeHold(t, f, f.active); rr.Code != http.StatusConflict {
+		t.Errorf("duplicate hold: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := placeHold(t, f, f.inactive); rr.Code != http.StatusForbidden {
+		t.Errorf("inactive user: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestHoldQueueIsPromotedOnReturn(t *testing.T) {
+	f := newFixture(t)
+	borrower := addPatron(t, f, "borrower@example.com")
+	first := addPatron(t, f, "first@example.com")
+	second := addPatron(t, f, "second@example.com")
+
+	var loan models.Loan
+	rr := checkout(t, f.handler, borrower.ID, f.copy.ID)
+	if err := json.Unmarshal(rr.Body.Bytes(), &loan); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	for _, patron := range []models.User{first, second} {
+		if rr := placeHold(t, f, patron); rr.Code != http.StatusCreated {
+			t.Fatalf("hold for %s: got %v want %v", patron.Email, rr.Code, http.StatusCreated)
+		}
+	}
+
+	holds := listHolds(t, f)
+	if len(holds) != 2 || holds[0].UserID != first.ID || holds[0].Position != 1 || holds[1].Position != 2 {
+		t.Fatalf("unexpected queue: %+v", holds)
+	}
+
+	if rr := returnLoan(t, f.handler, loan.ID); rr.Code != http.StatusOK {
+		t.Fatalf("return: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	holds = listHolds(t, f)
+	if holds[0].Status != models.HoldReady || holds[0].CopyID != f.copy.ID || holds[0].ExpiresAt == nil {
+		t.Errorf("head of queue not ready for pickup: %+v", holds[0])
+	}
+	if holds[1].Status != models.HoldWaiting || holds[1].Position != 1 {
+		t.Errorf("second hold should now be first in line: %+v", holds[1])
+	}
+
+	if rr := checkout(t, f.handler, second.ID, f.copy.ID); rr.Code != http.StatusConflict {
+		t.Errorf("checkout by other patron: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := checkout(t, f.handler, first.ID, f.copy.ID); rr.Code != http.StatusCreated {
+		t.Errorf("checkout by hold owner: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	holds = listHolds(t, f)
+	if len(holds) != 1 || holds[0].UserID != second.ID {
+		t.Errorf("fulfilled hold should leave the queue: %+v", holds)
+	}
+}
+
+func TestExpiredHoldPassesToNextPatron(t *testing.T) {
+	f := newFixture(t)
+	borrower := addPatron(t, f, "borrower@example.com")
+	first := addPatron(t, f, "first@example.com")
+	second := addPatron(t, f, "second@example.com")
+
+	var loan models.Loan
+	rr := checkout(t, f.handler, borrower.ID, f.copy.ID)
+	if err := json.Unmarshal(rr.Body.Bytes(), &loan); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	placeHold(t, f, first)
+	placeHold(t, f, second)
+	returnLoan(t, f.handler, loan.ID)
+
+	// Let the pickup window of the first patron run out
+	if _, err := f.db.Exec("UPDATE holds SET expires_at = '2000-01-01 00:00:00+00:00' WHERE status = 'ready'"); err != nil {
+		t.Fatal(err)
+	}
+
+	holds := listHolds(t, f)
+	if len(holds) != 1 || holds[0].UserID != second.ID || holds[0].Status != models.HoldReady {
+		t.Errorf("expected the copy to pass to the second patron: %+v", holds)
+	}
+}
+
+func TestCancelReadyHoldReleasesCopy(t *testing.T) {
+	f := newFixture(t)
+	borrower := addPatron(t, f, "borrower@example.com")
+	first := addPatron(t, f, "first@example.com")
+	second := addPatron(t, f, "second@example.com")
+
+	var loan models.Loan
+	rr := checkout(t, f.handler, borrower.ID, f.copy.ID)
+	if err := json.Unmarshal(rr.Body.Bytes(), &loan); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	var hold models.Hold
+	rr = placeHold(t, f, first)
+	if err := json.Unmarshal(rr.Body.Bytes(), &hold); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	placeHold(t, f, second)
+	returnLoan(t, f.handler, loan.ID)
+
+	cancel := func(user models.User) int {
+		req, err := http.NewRequest("POST", fmt.Sprintf("/holds/cancel?id=%d", hold.ID), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serveAs(t, user, f.handler.CancelHold, req).Code
+	}
+
+	if code := cancel(second); code != http.StatusNotFound {
+		t.Errorf("cancel someone else's hold: got %v want %v", code, http.StatusNotFound)
+	}
+	if code := cancel(first); code != http.StatusOK {
+		t.Fatalf("cancel own hold: got %v want %v", code, http.StatusOK)
+	}
+	if entries := audited(t, f, models.AuditCreate, "hold"); len(entries) != 2 {
+		t.Errorf("audited holds: got %+v", entries)
+	}
+	if entries := audited(t, f, models.AuditUpdate, "hold"); len(entries) != 1 || entries[0].EntityID != hold.ID || entries[0].Actor != first.Email ||
+		!strings.Contains(string(entries[0].Changes), `"status":{"before":"ready","after":"cancelled"}`) {
+		t.Errorf("audited cancel: got %+v", entries)
+	}
+	if code := cancel(first); code != http.StatusConflict {
+		t.Errorf("cancel twice: got %v want %v", code, http.StatusConflict)
+	}
+
+	holds := listHolds(t, f)
+	if len(holds) != 1 || holds[0].UserID != second.ID || holds[0].Status != models.HoldReady {
+		t.Errorf("expected the copy to pass to the second patron: %+v", holds)
+	}
+}
@@ -0,0 +1,290 @@
+package loans
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang_project/audit"
+	"golang_project/auth"
+	"golang_project/fines"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// LoanPeriod is how long a patron may keep a book before it is due
+const LoanPeriod = 14 * 24 * time.Hour
+
+// PickupWindow is how long a returned copy stays set aside for the patron at
+// the head of the hold queue before it passes to the next one
+const PickupWindow = 3 * 24 * time.Hour
+
+// CheckoutRequest is the body of a checkout request
+type CheckoutRequest struct {
+	UserID int `json:"user_id"`
+	CopyID int `json:"copy_id"`
+}
+
+// Handler serves the loan and hold endpoints
+type Handler struct {
+	loans    store.LoanRepository
+	holds    store.HoldRepository
+	books    store.BookRepository
+	copies   store.CopyRepository
+	users    store.UserRepository
+	ledger   store.LedgerRepository
+	renewals store.RenewalPolicyRepository
+	fines    fines.Policy
+	audit    *audit.Log
+}
+
+// NewHandler returns a Handler backed by the given repositories that charges
+// overdue fines according to finePolicy. Loans, holds, fines and policy
+// changes are recorded in auditLog.
+func NewHandler(loans store.LoanRepository, holds store.HoldRepository, books store.BookRepository, copies store.CopyRepository, users store.UserRepository, ledger store.LedgerRepository, renewals store.RenewalPolicyRepository, finePolicy fines.Policy, auditLog *audit.Log) *Handler {
+	return &Handler{loans: loans, holds: holds, books: books, copies: copies, users: users, ledger: ledger, renewals: renewals, fines: finePolicy, audit: auditLog}
+}
+
+// CheckoutBook handles the request to lend a book to a patron
+// @Summary Check out a book
+// @Description Lend a copy of a book to an active patron. Fails if the copy is already on loan
+// @Description or set aside for another patron's hold, or if the patron owes more than the fine
+// @Description threshold. Fulfills the patron's own hold on the book.
+// @Tags loans
+// @Accept json
+// @Produce json
+// @Param checkout body loans.CheckoutRequest true "Patron and copy"
+// @Success 201 {object} models.Loan
+// @Failure 403 {object} problem.Problem "User account is inactive or has outstanding fines"
+// @Failure 404 {object} problem.Problem "User or copy not found"
+// @Failure 409 {object} problem.Problem "Copy is already checked out or reserved"
+// @Router /loans/checkout [post]
+func (h *Handler) CheckoutBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var req CheckoutRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UTC()
+	if err := h.expireHolds(now); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating holds")
+		return
+	}
+
+	user, err := h.users.Get(req.UserID, "")
+	if err != nil {
+		problem.StoreError(w, r, err, "User not found")
+		return
+	}
+	if !user.IsActive {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeAccountInactive, "User account is inactive")
+		return
+	}
+
+	balance, err := h.ledger.Balance(user.ID)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	if h.fines.Blocks(balance) {
+		problem.Write(w, r, http.StatusForbidden, problem.CodeFinesOutstanding, "Outstanding fines exceed the checkout limit")
+		return
+	}
+
+	bookCopy, err := h.copies.Get(req.CopyID)
+	if err != nil {
+		problem.StoreError(w, r, err, "Copy not found")
+		return
+	}
+	// Copies of books in the trash are not lent out
+	if _, err := h.books.Get(bookCopy.BookID); err != nil {
+		problem.StoreError(w, r, err, "Copy not found")
+		return
+	}
+
+	loan := models.Loan{
+		UserID:       user.ID,
+		CopyID:       bookCopy.ID,
+		BookID:       bookCopy.BookID,
+		CheckoutDate: now,
+		DueDate:      now.Add(LoanPeriod),
+	}
+	fulfilled, err := h.loans.Checkout(&loan, now.Add(PickupWindow))
+	if errors.Is(err, store.ErrReserved) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Copy is reserved for another patron")
+		return
+	}
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Copy is already checked out")
+		return
+	}
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error creating loan")
+		return
+	}
+	h.audit.Record(r, models.AuditCreate, "loan", loan.ID, nil, loan)
+	if fulfilled != nil {
+		if after, err := h.holds.Get(fulfilled.ID); err == nil {
+			h.audit.Record(r, models.AuditUpdate, "hold", fulfilled.ID, *fulfilled, after)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(loan)
+}
+
+// ReturnBook handles the request to return a loaned book
+// @Summary Return a book
+// @Description Close an active loan, charging the patron an overdue fine if it is late.
+// @Description The copy is set aside for the next patron waiting on the book.
+// @Tags loans
+// @Param id query string true "Loan ID"
+// @Success 200 {string} string "Book returned successfully"
+// @Failure 404 {object} problem.Problem "Loan not found"
+// @Failure 409 {object} problem.Problem "Loan already returned"
+// @Router /loans/return [post]
+func (h *Handler) ReturnBook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	id, ok := queryInt(w, r, "id", "Missing loan ID")
+	if !ok {
+		return
+	}
+
+	loan, err := h.loans.Get(id)
+	if err != nil {
+		problem.StoreError(w, r, err, "Loan not found")
+		return
+	}
+
+	now := time.Now().UTC()
+	fine, maxFine, err := h.overdueFine(loan, now)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error charging fine")
+		return
+	}
+	err = h.loans.Return(id, now, fine, maxFine)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Loan already returned")
+		return
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, "Loan not found")
+		return
+	}
+	if after, err := h.loans.Get(id); err == nil {
+		h.audit.Record(r, models.AuditUpdate, "loan", id, loan, after)
+	}
+	h.auditFine(r, fine)
+
+	if err := h.releaseCopy(loan.BookID, loan.CopyID, now); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating holds")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Book returned successfully"))
+}
+
+// ListActiveLoans handles the request to list a patron's active loans
+// @Summary List a patron's active loans
+// @Description Get the loans of a patron that have not been returned
+// @Tags loans
+// @Produce json
+// @Param user_id query string true "User ID"
+// @Success 200 {array} models.Loan
+// @Router /loans/active [get]
+func (h *Handler) ListActiveLoans(w http.ResponseWriter, r *http.Request) {
+	userID, ok := queryInt(w, r, "user_id", "Missing user ID")
+	if !ok {
+		return
+	}
+	h.writeActiveLoans(w, r, userID)
+}
+
+// ListMyLoans handles the request to list the caller's active loans
+// @Summary List my active loans
+// @Description Get the loans of the logged-in patron that have not been returned
+// @Tags loans
+// @Produce json
+// @Success 200 {array} models.Loan
+// @Failure 401 {object} problem.Problem "Unauthorized"
+// @Router /user/loans [get]
+func (h *Handler) ListMyLoans(w http.ResponseWriter, r *http.Request) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+	h.writeActiveLoans(w, r, claims.UserID)
+}
+
+func (h *Handler) writeActiveLoans(w http.ResponseWriter, r *http.Request, userID int) {
+	loans, err := h.loans.ListActiveByUser(userID)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(loans)
+}
+
+// overdueFine returns the charge for loan if it is returned or renewed at
+// at, or nil when nothing is owed, and the most the charges of the loan may
+// add up to, zero when they are not capped
+func (h *Handler) overdueFine(loan models.Loan, at time.Time) (*models.LedgerEntry, int, error) {
+	book, err := h.books.GetWithDeleted(loan.BookID)
+	if err != nil {
+		return nil, 0, err
+	}
+	amount := h.fines.Fine(book.Genre, loan.DueDate, at)
+	if amount == 0 {
+		return nil, 0, nil
+	}
+	fine := models.LedgerEntry{
+		UserID:    loan.UserID,
+		LoanID:    loan.ID,
+		Kind:      models.LedgerCharge,
+		Amount:    amount,
+		Note:      "Overdue fine for " + book.Title,
+		CreatedAt: at,
+	}
+	return &fine, h.fines.RateFor(book.Genre).MaxFine, nil
+}
+
+// auditFine records fine in the audit log if it was charged
+func (h *Handler) auditFine(r *http.Request, fine *models.LedgerEntry) {
+	if fine != nil && fine.ID != 0 {
+		h.audit.Record(r, models.AuditCreate, "ledger_entry", fine.ID, nil, *fine)
+	}
+}
+
+// queryInt reads a numeric query parameter, writing a 400 response when it is missing or invalid
+func queryInt(w http.ResponseWriter, r *http.Request, name, missing string) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, missing)
+		return 0, false
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, "Invalid "+name)
+		return 0, false
+	}
+	return n, true
+}
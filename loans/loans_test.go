@@ -0,0 +1,261 @@
+package loans
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"golang_project/audit"
+	"golang_project/auth"
+	"golang_project/fines"
+	"golang_project/models"
+	"golang_project/store"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fixture struct {
+	db       *sql.DB
+	handler  *Handler
+	ledger   store.LedgerRepository
+	audit    store.AuditRepository
+	active   models.User
+	inactive models.User
+	book     models.Book
+	copy     models.Copy
+}
+
+func newFixture(t *testing.T) fixture {
+	db, err := store.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	books := store.NewSQLiteBookRepository(db)
+	users := store.NewSQLiteUserRepository(db)
+	copies := store.NewSQLiteCopyRepository(db)
+	ledger := store.NewSQLiteLedgerRepository(db)
+	entries := store.NewSQLiteAuditRepository(db)
+
+	f := fixture{
+		db:       db,
+		handler:  NewHandler(store.NewSQLiteLoanRepository(db), store.NewSQLiteHoldRepository(db), books, copies, users, ledger, store.NewSQLiteRenewalPolicyRepository(db), fines.DefaultPolicy, audit.NewLog(entries)),
+		ledger:   ledger,
+		audit:    entries,
+		active:   models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Role: "user"},
+		inactive: models.User{Name: "John Doe", Email: "john.doe@example.com", IsActive: false, Role: "user"},
+		book:     models.Book{Title: "The Great Gatsby", Author: "F. Scott Fitzgerald", ISBN: "9780743273565", PublishedYear: 1925, Genre: "Fiction"},
+	}
+	if err := users.Create(&f.active); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Create(&f.inactive); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.Create(&f.book); err != nil {
+		t.Fatal(err)
+	}
+	f.copy = models.Copy{BookID: f.book.ID, Barcode: "GATSBY-1", Condition: "good"}
+	if err := copies.Create(&f.copy); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// audited returns the audit log entries of action on entity, newest first
+func audited(t *testing.T, f fixture, action, entity string) []models.AuditEntry {
+	entries, err := f.audit.List(store.AuditFilter{Action: action, Entity: entity, Limit: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func checkout(t *testing.T, h *Handler, userID, copyID int) *httptest.ResponseRecorder {
+	jsonPayload, err := json.Marshal(CheckoutRequest{UserID: userID, CopyID: copyID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "/loans/checkout", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.CheckoutBook).ServeHTTP(rr, req)
+	return rr
+}
+
+func returnLoan(t *testing.T, h *Handler, loanID int) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", fmt.Sprintf("/loans/return?id=%d", loanID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.ReturnBook).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCheckoutBook(t *testing.T) {
+	f := newFixture(t)
+
+	rr := checkout(t, f.handler, f.active.ID, f.copy.ID)
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v. Response body: %v",
+			status, http.StatusCreated, rr.Body.String())
+	}
+
+	var loan models.Loan
+	if err := json.Unmarshal(rr.Body.Bytes(), &loan); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if loan.UserID != f.active.ID || loan.CopyID != f.copy.ID || loan.BookID != f.book.ID {
+		t.Errorf("unexpected loan: %+v", loan)
+	}
+	if got := loan.DueDate.Sub(loan.CheckoutDate); got != LoanPeriod {
+		t.Errorf("loan period is %v, want %v", got, LoanPeriod)
+	}
+}
+
+func TestCheckoutBookRefusals(t *testing.T) {
+	f := newFixture(t)
+
+	if rr := checkout(t, f.handler, f.inactive.ID, f.copy.ID); rr.Code != http.StatusForbidden {
+		t.Errorf("inactive user: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if rr := checkout(t, f.handler, f.active.ID, f.copy.ID+100); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown copy: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := checkout(t, f.handler, f.active.ID, f.copy.ID); rr.Code != http.StatusCreated {
+		t.Fatalf("first checkout: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := checkout(t, f.handler, f.active.ID, f.copy.ID); rr.Code != http.StatusConflict {
+		t.Errorf("copy already out: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestReturnBook(t *testing.T) {
+	f := newFixture(t)
+
+	var loan models.Loan
+	rr := checkout(t, f.handler, f.active.ID, f.copy.ID)
+	if err := json.Unmarshal(rr.Body.Bytes(), &loan); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	if rr := returnLoan(t, f.handler, loan.ID); rr.Code != http.StatusOK {
+		t.Fatalf("return: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if entries := audited(t, f, models.AuditCreate, "loan"); len(entries) != 1 || entries[0].EntityID != loan.ID {
+		t.Errorf("audited checkout: got %+v", entries)
+	}
+	if entries := audited(t, f, models.AuditUpdate, "loan"); len(entries) != 1 || !strings.Contains(string(entries[0].Changes), `"return_date":{"after"`) {
+		t.Errorf("audited return: got %+v", entries)
+	}
+	if rr := returnLoan(t, f.handler, loan.ID); rr.Code != http.StatusConflict {
+		t.Errorf("second return: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := returnLoan(t, f.handler, loan.ID+100); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown loan: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := checkout(t, f.handler, f.active.ID, f.copy.ID); rr.Code != http.StatusCreated {
+		t.Errorf("checkout after return: got %v want %v", rr.Code, http.StatusCreated)
+	}
+}
+
+func TestListMyLoans(t *testing.T) {
+	f := newFixture(t)
+
+	if rr := checkout(t, f.handler, f.active.ID, f.copy.ID); rr.Code != http.StatusCreated {
+		t.Fatalf("checkout: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	tokenString, _, err := auth.IssueToken(f.active, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", "/user/loans", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "token", Value: tokenString})
+
+	rr := httptest.NewRecorder()
+	auth.AuthMiddleware(http.HandlerFunc(f.handler.ListMyLoans)).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var loans []models.Loan
+	if err := json.Unmarshal(rr.Body.Bytes(), &loans); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(loans) != 1 || loans[0].BookID != f.book.ID {
+		t.Errorf("unexpected loans: %+v", loans)
+	}
+}
+
+func TestReturnOverdueChargesFine(t *testing.T) {
+	f := newFixture(t)
+
+	var loan models.Loan
+	rr := checkout(t, f.handler, f.active.ID, f.copy.ID)
+	if err := json.Unmarshal(rr.Body.Bytes(), &loan); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	due := time.Now().UTC().Add(-3*24*time.Hour + time.Hour)
+	if _, err := f.db.Exec("UPDATE loans SET due_date = ? WHERE id = ?", due, loan.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := returnLoan(t, f.handler, loan.ID); rr.Code != http.StatusOK {
+		t.Fatalf("return: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	entries, err := f.ledger.ListByUser(f.active.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 3 * fines.DefaultPolicy.Default.DailyRate
+	if len(entries) != 1 || entries[0].Kind != models.LedgerCharge || entries[0].Amount != want || entries[0].LoanID != loan.ID {
+		t.Errorf("unexpected ledger entries: %+v, want one charge of %d", entries, want)
+	}
+	if audit := audited(t, f, models.AuditCreate, "ledger_entry"); len(audit) != 1 || audit[0].EntityID != entries[0].ID {
+		t.Errorf("audited fine: got %+v", audit)
+	}
+}
+
+func TestCheckoutBlockedByFines(t *testing.T) {
+	f := newFixture(t)
+
+	charge := models.LedgerEntry{
+		UserID:    f.active.ID,
+		Kind:      models.LedgerCharge,
+		Amount:    fines.DefaultPolicy.BlockThreshold + 1,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := f.ledger.Create(&charge); err != nil {
+		t.Fatal(err)
+	}
+	if rr := checkout(t, f.handler, f.active.ID, f.copy.ID); rr.Code != http.StatusForbidden {
+		t.Fatalf("balance over threshold: got %v want %v", rr.Code, http.StatusForbidden)
+	}
+
+	payment := models.LedgerEntry{UserID: f.active.ID, Kind: models.LedgerPayment, Amount: 1, CreatedAt: time.Now().UTC()}
+	if err := f.ledger.Create(&payment); err != nil {
+		t.Fatal(err)
+	}
+	if rr := checkout(t, f.handler, f.active.ID, f.copy.ID); rr.Code != http.StatusCreated {
+		t.Errorf("balance at threshold: got %v want %v", rr.Code, http.StatusCreated)
+	}
+}
@@ -0,0 +1,197 @@
+package loans
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang_project/auth"
+	"golang_project/models"
+	"golang_project/problem"
+	"golang_project/store"
+)
+
+// RenewLoan handles the request to extend one of the caller's loans
+// @Summary Renew a loan
+// @Description Extend the due date of one of the logged-in patron's active loans by the renewal period.
+// @Description Refused once the renewal limit is reached, when other patrons are waiting for the book,
+// @Description or when the loan is overdue by more than the policy allows. Fines for days already
+// @Description overdue are charged at renewal.
+// @Tags loans
+// @Produce json
+// @Param id query string true "Loan ID"
+// @Success 200 {object} models.Loan
+// @Failure 404 {object} problem.Problem "Loan not found"
+// @Failure 409 {object} problem.Problem "Loan cannot be renewed"
+// @Router /loans/renew [post]
+func (h *Handler) RenewLoan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, ok := queryInt(w, r, "id", "Missing loan ID")
+	if !ok {
+		return
+	}
+
+	loan, err := h.loans.Get(id)
+	if err == nil && loan.UserID != claims.UserID {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, "Loan not found")
+		return
+	}
+	if loan.ReturnDate != nil {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Loan already returned")
+		return
+	}
+
+	policy, err := h.renewals.Get()
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	now := time.Now().UTC()
+	if loan.Renewals >= policy.MaxRenewals {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Renewal limit reached")
+		return
+	}
+	if now.Sub(loan.DueDate) > time.Duration(policy.MaxOverdueDays)*24*time.Hour {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Loan is too far overdue to renew")
+		return
+	}
+
+	if policy.RefuseOnHolds {
+		if err := h.expireHolds(now); err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating holds")
+			return
+		}
+		waiting, err := h.hasWaitingHolds(loan.BookID)
+		if err != nil {
+			problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+			return
+		}
+		if waiting {
+			problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Other patrons are waiting for this book")
+			return
+		}
+	}
+
+	// The fine so far is charged with the renewal, so a concurrent return
+	// cannot charge it a second time
+	fine, maxFine, err := h.overdueFine(loan, now)
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error charging fine")
+		return
+	}
+
+	from := loan.DueDate
+	if now.After(from) {
+		from = now
+	}
+	dueDate := from.Add(time.Duration(policy.PeriodDays) * 24 * time.Hour)
+	err = h.loans.Renew(loan.ID, dueDate, fine, maxFine)
+	if errors.Is(err, store.ErrConflict) {
+		problem.Write(w, r, http.StatusConflict, problem.CodeConflict, "Loan already returned")
+		return
+	}
+	if err != nil {
+		problem.StoreError(w, r, err, "Loan not found")
+		return
+	}
+	h.auditFine(r, fine)
+
+	renewed, err := h.loans.Get(loan.ID)
+	if err != nil {
+		problem.StoreError(w, r, err, "Loan not found")
+		return
+	}
+	h.audit.Record(r, models.AuditUpdate, "loan", loan.ID, loan, renewed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(renewed)
+}
+
+// ReadRenewalPolicy handles the request to view the renewal policy
+// @Summary Read the renewal policy
+// @Description Get the limits applied when patrons renew loans
+// @Tags loans
+// @Produce json
+// @Success 200 {object} models.RenewalPolicy
+// @Router /loans/policy [get]
+func (h *Handler) ReadRenewalPolicy(w http.ResponseWriter, r *http.Request) {
+	policy, err := h.renewals.Get()
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdateRenewalPolicy handles the request to change the renewal policy
+// @Summary Update the renewal policy
+// @Description Replace the limits applied when patrons renew loans. Takes effect immediately.
+// @Tags loans
+// @Accept json
+// @Produce json
+// @Param policy body models.RenewalPolicy true "Renewal policy"
+// @Success 200 {object} models.RenewalPolicy
+// @Failure 400 {object} problem.Problem "Invalid policy"
+// @Router /loans/policy/update [put]
+func (h *Handler) UpdateRenewalPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		problem.Write(w, r, http.StatusMethodNotAllowed, problem.CodeMethodNotAllowed, "Invalid request method")
+		return
+	}
+
+	var policy models.RenewalPolicy
+	err := json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidRequest, err.Error())
+		return
+	}
+	if policy.MaxRenewals < 0 || policy.PeriodDays < 1 || policy.MaxOverdueDays < 0 {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeValidationFailed, "Renewal limits must not be negative and the period must be at least one day")
+		return
+	}
+
+	before, err := h.renewals.Get()
+	if err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error querying database")
+		return
+	}
+	if err := h.renewals.Update(policy); err != nil {
+		problem.Write(w, r, http.StatusInternalServerError, problem.CodeInternal, "Error updating policy")
+		return
+	}
+	h.audit.Record(r, models.AuditUpdate, "renewal_policy", 1, before, policy)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// hasWaitingHolds reports whether any patron is queued for the book
+func (h *Handler) hasWaitingHolds(bookID int) (bool, error) {
+	holds, err := h.holds.ListOpenByBook(bookID)
+	if err != nil {
+		return false, err
+	}
+	for _, hold := range holds {
+		if hold.Status == models.HoldWaiting {
+			return true, nil
+		}
+	}
+	return false, nil
+}
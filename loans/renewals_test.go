@@ -0,0 +1,184 @@
+package loans
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang_project/fines"
+	"golang_project/models"
+	"golang_project/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func renew(t *testing.T, f fixture, user models.User, loanID int) *httptest.ResponseRecorder {
+	req, err := http.NewRequest("POST", fmt.Sprintf("/loans/renew?id=%d", loanID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveAs(t, user, f.handler.RenewLoan, req)
+}
+
+func checkoutLoan(t *testing.T, f fixture, user models.User) models.Loan {
+	rr := checkout(t, f.handler, user.ID, f.copy.ID)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("checkout: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var loan models.Loan
+	if err := json.Unmarshal(rr.Body.Bytes(), &loan); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return loan
+}
+
+func TestRenewLoan(t *testing.T) {
+	f := newFixture(t)
+	loan := checkoutLoan(t, f, f.active)
+
+	if rr := renew(t, f, addPatron(t, f, "other@example.com"), loan.ID); rr.Code != http.StatusNotFound {
+		t.Errorf("someone else's loan: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr := renew(t, f, f.active, loan.ID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v. Response body: %v",
+			rr.Code, http.StatusOK, rr.Body.String())
+	}
+	var renewed models.Loan
+	if err := json.Unmarshal(rr.Body.Bytes(), &renewed); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if renewed.Renewals != 1 || !renewed.DueDate.Equal(loan.DueDate.Add(14*24*time.Hour)) {
+		t.Errorf("unexpected renewed loan: %+v", renewed)
+	}
+	if entries := audited(t, f, models.AuditUpdate, "loan"); len(entries) != 1 || entries[0].Actor != f.active.Email || !strings.Contains(string(entries[0].Changes), `"renewals":{"before":0,"after":1}`) {
+		t.Errorf("audited renewal: got %+v", entries)
+	}
+
+	if rr := renew(t, f, f.active, loan.ID); rr.Code != http.StatusOK {
+		t.Fatalf("second renewal: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := renew(t, f, f.active, loan.ID); rr.Code != http.StatusConflict {
+		t.Errorf("renewal limit: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestRenewLoanRefusals(t *testing.T) {
+	f := newFixture(t)
+	loan := checkoutLoan(t, f, f.active)
+
+	if rr := placeHold(t, f, addPatron(t, f, "waiting@example.com")); rr.Code != http.StatusCreated {
+		t.Fatalf("hold: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if rr := renew(t, f, f.active, loan.ID); rr.Code != http.StatusConflict {
+		t.Errorf("holds on the title: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	renewals := store.NewSQLiteRenewalPolicyRepository(f.db)
+	policy, err := renewals.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.RefuseOnHolds = false
+	if err := renewals.Update(policy); err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Now().UTC().Add(-time.Duration(policy.MaxOverdueDays+1) * 24 * time.Hour)
+	if _, err := f.db.Exec("UPDATE loans SET due_date = ? WHERE id = ?", due, loan.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rr := renew(t, f, f.active, loan.ID); rr.Code != http.StatusConflict {
+		t.Errorf("overdue beyond the limit: got %v want %v", rr.Code, http.StatusConflict)
+	}
+
+	due = time.Now().UTC().Add(-24 * time.Hour)
+	if _, err := f.db.Exec("UPDATE loans SET due_date = ? WHERE id = ?", due, loan.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rr := renew(t, f, f.active, loan.ID); rr.Code != http.StatusOK {
+		t.Fatalf("overdue within the limit: got %v want %v", rr.Code, http.StatusOK)
+	}
+	balance, err := f.ledger.Balance(f.active.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance == 0 {
+		t.Errorf("expected the overdue day to be charged at renewal")
+	}
+}
+
+func TestUpdateRenewalPolicy(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		policy models.RenewalPolicy
+		want   int
+	}{
+		{models.RenewalPolicy{MaxRenewals: -1, PeriodDays: 7}, http.StatusBadRequest},
+		{models.RenewalPolicy{MaxRenewals: 1, PeriodDays: 0}, http.StatusBadRequest},
+		{models.RenewalPolicy{MaxRenewals: 0, PeriodDays: 7}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		jsonPayload, err := json.Marshal(tt.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("PUT", "/loans/policy/update", bytes.NewBuffer(jsonPayload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(f.handler.UpdateRenewalPolicy).ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%+v: got %v want %v", tt.policy, rr.Code, tt.want)
+		}
+	}
+	if entries := audited(t, f, models.AuditUpdate, "renewal_policy"); len(entries) != 1 || !strings.Contains(string(entries[0].Changes), `"period_days":{"before":14,"after":7}`) {
+		t.Errorf("audited policy change: got %+v", entries)
+	}
+
+	loan := checkoutLoan(t, f, f.active)
+	if rr := renew(t, f, f.active, loan.ID); rr.Code != http.StatusConflict {
+		t.Errorf("renewals disabled: got %v want %v", rr.Code, http.StatusConflict)
+	}
+}
+
+func TestRenewedLoanFinesAreCapped(t *testing.T) {
+	f := newFixture(t)
+	loan := checkoutLoan(t, f, f.active)
+	rate := fines.DefaultPolicy.Default
+
+	due := time.Now().UTC().Add(-5*24*time.Hour + time.Hour)
+	if _, err := f.db.Exec("UPDATE loans SET due_date = ? WHERE id = ?", due, loan.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rr := renew(t, f, f.active, loan.ID); rr.Code != http.StatusOK {
+		t.Fatalf("overdue renewal: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Returned long after the new due date, the loan owes no more than the cap in all
+	due = time.Now().UTC().Add(-100 * 24 * time.Hour)
+	if _, err := f.db.Exec("UPDATE loans SET due_date = ? WHERE id = ?", due, loan.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rr := returnLoan(t, f.handler, loan.ID); rr.Code != http.StatusOK {
+		t.Fatalf("return: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	entries, err := f.ledger.ListByUser(f.active.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Amount != 5*rate.DailyRate || entries[1].Amount != rate.MaxFine-5*rate.DailyRate {
+		t.Errorf("unexpected charges: %+v, want %d and then up to %d in all", entries, 5*rate.DailyRate, rate.MaxFine)
+	}
+	if audit := audited(t, f, models.AuditCreate, "ledger_entry"); len(audit) != 2 {
+		t.Errorf("audited fines: got %+v", audit)
+	}
+}
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{To: "jane.doe@example.com", Subject: "Reset your password", Body: "line one\nline two"}
+	got := string(format("library@example.com", msg, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	for _, want := range []string{
+		"From: library@example.com\r\n",
+		"To: jane.doe@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n",
+		"\r\n\r\nline one\r\nline two",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message %q does not contain %q", got, want)
+		}
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir, "library@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(Message{To: "jane.doe@example.com", Subject: "Hello", Body: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), "jane.doe_at_example.com.eml") {
+		t.Errorf("unexpected files: %v", entries)
+	}
+}
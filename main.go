@@ -2,7 +2,15 @@ package main
 
 import (
 	"fmt"
+	"golang_project/audit"
+	"golang_project/auth"
+	"golang_project/crud"
+	"golang_project/fines"
 	handlers "golang_project/handler"
+	"golang_project/mail"
+	"golang_project/store"
+	"log"
+	"os"
 	"time"
 )
 
@@ -24,5 +32,54 @@ func main() {
 	endTime := time.Now()
 	fmt.Println("Current Time:", endTime.Format(time.RFC1123))
 
-	handlers.HandleRequest()
+	db, err := store.Open(store.PathFromEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	keys, err := auth.KeysFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.SetKeys(keys)
+
+	policy, err := auth.PolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	throttle, err := auth.ThrottleFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	finePolicy, err := fines.PolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mailer, err := mail.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:9000"
+	}
+
+	oidc, err := auth.OIDCFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	retention, err := crud.RetentionFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	purger := crud.NewPurger(store.NewSQLiteBookRepository(db), store.NewSQLiteUserRepository(db), audit.NewLog(store.NewSQLiteAuditRepository(db)), retention)
+	go purger.Run(time.Hour)
+
+	handlers.HandleRequest(db, policy, throttle, finePolicy, mailer, appURL, oidc)
 }
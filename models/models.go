@@ -1,12 +1,38 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type Book struct {
+	ID              int    `json:"id"`
+	Title           string `json:"title"`
+	Author          string `json:"author"`
+	ISBN            string `json:"isbn"`
+	PublishedYear   int    `json:"published_year"`
+	Genre           string `json:"genre"`
+	TotalCopies     int    `json:"total_copies"`
+	AvailableCopies int    `json:"available_copies"`
+	// DeletedAt is set while the book is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Version goes up by one with every update and is sent as the ETag
+	Version int `json:"version"`
+}
+
+type SearchResult struct {
+	Book
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+type Copy struct {
 	ID            int    `json:"id"`
-	Title         string `json:"title"`
-	Author        string `json:"author"`
-	ISBN          string `json:"isbn"`
-	PublishedYear int    `json:"published_year"`
-	Genre         string `json:"genre"`
+	BookID        int    `json:"book_id"`
+	Barcode       string `json:"barcode"`
+	Condition     string `json:"condition"`
+	ShelfLocation string `json:"shelf_location"`
+	Available     bool   `json:"available"`
 }
 
 type User struct {
@@ -17,6 +43,12 @@ type User struct {
 	IsActive       bool   `json:"is_active"`
 	Password       string `json:"password"`
 	Role           string `json:"role"`
+	// EmailVerifiedAt is nil until the user follows the link mailed at registration
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	// DeletedAt is set while the account is in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Version goes up by one with every update and is sent as the ETag
+	Version int `json:"version"`
 }
 
 type Filter struct {
@@ -26,3 +58,188 @@ type Filter struct {
 	Title         string `json:"title"`
 	SortOrder     string `json:"sort_order"`
 }
+
+type Loan struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	CopyID       int        `json:"copy_id"`
+	BookID       int        `json:"book_id"`
+	CheckoutDate time.Time  `json:"checkout_date"`
+	DueDate      time.Time  `json:"due_date"`
+	ReturnDate   *time.Time `json:"return_date,omitempty"`
+	Renewals     int        `json:"renewals"`
+}
+
+type Session struct {
+	ID          string     `json:"id"`
+	UserID      int        `json:"user_id"`
+	RefreshHash string     `json:"-"`
+	UserAgent   string     `json:"user_agent"`
+	IP          string     `json:"ip"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastUsedAt  time.Time  `json:"last_used_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	// TwoFactor is true when the session was started with a second factor
+	TwoFactor bool `json:"two_factor"`
+	Current   bool `json:"current"`
+}
+
+type TwoFactor struct {
+	UserID      int
+	Secret      string
+	ConfirmedAt *time.Time
+	LastStep    int64
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type OIDCLogin struct {
+	StateHash    string
+	CodeVerifier string
+	Nonce        string
+	// LinkUserID is set when a signed-in account is linking the identity
+	// rather than signing in with it
+	LinkUserID *int
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+type Identity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginFailure struct {
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	UserID    *int      `json:"user_id,omitempty"`
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LoginCounter struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+const (
+	LoginUnknownAccount = "unknown_account"
+	LoginWrongPassword  = "wrong_password"
+	LoginWrongCode      = "wrong_code"
+	LoginThrottled      = "throttled"
+	LoginLocked         = "locked"
+)
+
+type OneTimeToken struct {
+	TokenHash string
+	UserID    int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+type RenewalPolicy struct {
+	MaxRenewals    int  `json:"max_renewals"`
+	PeriodDays     int  `json:"period_days"`
+	MaxOverdueDays int  `json:"max_overdue_days"`
+	RefuseOnHolds  bool `json:"refuse_on_holds"`
+}
+
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = "ready"
+	HoldFulfilled = "fulfilled"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+type Hold struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	BookID    int        `json:"book_id"`
+	CopyID    int        `json:"copy_id,omitempty"`
+	Status    string     `json:"status"`
+	Position  int        `json:"position,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+const (
+	LedgerCharge  = "charge"
+	LedgerPayment = "payment"
+	LedgerWaiver  = "waiver"
+)
+
+type LedgerEntry struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	LoanID     int       `json:"loan_id,omitempty"`
+	Kind       string    `json:"kind"`
+	Amount     int       `json:"amount"`
+	Note       string    `json:"note"`
+	RecordedBy string    `json:"recorded_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type Account struct {
+	UserID  int           `json:"user_id"`
+	Balance int           `json:"balance"`
+	Entries []LedgerEntry `json:"entries"`
+}
+
+type BookRevision struct {
+	BookID        int    `json:"book_id"`
+	Revision      int    `json:"revision"`
+	Title         string `json:"title"`
+	Author        string `json:"author"`
+	ISBN          string `json:"isbn"`
+	PublishedYear int    `json:"published_year"`
+	Genre         string `json:"genre"`
+	// ActorID is nil for revisions recorded before history was kept
+	ActorID   *int      `json:"actor_id,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type AuditEntry struct {
+	ID int `json:"id"`
+	// ActorID is nil for changes made without logging in, such as
+	// registration, and for those the server makes itself
+	ActorID  *int   `json:"actor_id,omitempty"`
+	Actor    string `json:"actor,omitempty"`
+	APIKeyID *int   `json:"api_key_id,omitempty"`
+	Action   string `json:"action"`
+	Entity   string `json:"entity"`
+	EntityID int    `json:"entity_id"`
+	// Changes maps each changed field to its value before and after
+	Changes   json.RawMessage `json:"changes" swaggertype:"object"`
+	IP        string          `json:"ip"`
+	RequestID string          `json:"request_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
@@ -0,0 +1,326 @@
+// Package paging implements keyset pagination, sorting and sparse fieldsets
+// for the book listing endpoints.
+//
+// A list request may carry:
+//
+//	limit=20              at most MaxLimit books per page
+//	sort=genre,-title     fields to order by, descending when prefixed with -
+//	fields=id,title       the book fields to include in each result
+//	cursor=...            the value of X-Next-Cursor from the previous page
+//
+// When another page follows, the response carries its cursor in the
+// X-Next-Cursor header and its URL in a Link header with rel="next".
+//
+// Search results are ordered by rank instead, so a search request has no sort
+// parameter, and its cursors hold the score and ID of the last result.
+package paging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang_project/models"
+	"golang_project/store"
+)
+
+// DefaultLimit is the page size used when a request has no limit
+const DefaultLimit = 50
+
+// MaxLimit caps the page size a request may ask for
+const MaxLimit = 100
+
+// DefaultSearchLimit is the page size used when a search request has no limit
+const DefaultSearchLimit = 20
+
+// rankSort is the sort search cursors are bound to: best match first
+const rankSort = "-rank"
+
+// bookFields are the JSON fields of models.Book that may be selected
+var bookFields = map[string]bool{
+	"id":               true,
+	"title":            true,
+	"author":           true,
+	"isbn":             true,
+	"published_year":   true,
+	"genre":            true,
+	"total_copies":     true,
+	"available_copies": true,
+}
+
+// searchFields are the JSON fields of models.SearchResult that may be selected
+var searchFields = map[string]bool{
+	"id":               true,
+	"title":            true,
+	"author":           true,
+	"isbn":             true,
+	"published_year":   true,
+	"genre":            true,
+	"total_copies":     true,
+	"available_copies": true,
+	"score":            true,
+	"snippet":          true,
+}
+
+// Params are the pagination, sorting and field selection options of a list request
+type Params struct {
+	Limit  int
+	Sort   []store.SortKey
+	Fields []string
+	After  []interface{}
+
+	// sort is the sort parameter the cursors of this listing are bound to
+	sort string
+}
+
+// cursor is the decoded form of the cursor parameter
+type cursor struct {
+	Sort  string        `json:"s"`
+	After []interface{} `json:"a"`
+}
+
+// Parse reads the limit, sort, fields and cursor parameters of r. defaultSort
+// is used when the request has no sort parameter.
+func Parse(r *http.Request, defaultSort string) (Params, error) {
+	query := r.URL.Query()
+	p := Params{Limit: DefaultLimit, sort: defaultSort}
+
+	if err := p.parseLimit(query.Get("limit")); err != nil {
+		return p, err
+	}
+
+	if query.Has("sort") {
+		p.sort = query.Get("sort")
+	}
+	for _, field := range splitList(p.sort) {
+		key := store.SortKey{Field: field}
+		if strings.HasPrefix(field, "-") {
+			key = store.SortKey{Field: field[1:], Desc: true}
+		}
+		if !store.IsBookSortField(key.Field) {
+			return p, fmt.Errorf("cannot sort by %q", key.Field)
+		}
+		p.Sort = append(p.Sort, key)
+	}
+
+	if err := p.parseFields(query.Get("fields"), bookFields); err != nil {
+		return p, err
+	}
+
+	if raw := query.Get("cursor"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil || !validCursor(c, p.Sort) {
+			return p, fmt.Errorf("invalid cursor")
+		}
+		if c.Sort != p.sort {
+			return p, fmt.Errorf("cursor was issued for a different sort")
+		}
+		p.After = c.After
+	}
+	return p, nil
+}
+
+// ParseSearch reads the limit, fields and cursor parameters of a search
+// request, whose results are ordered by rank. The fields may include the
+// score and snippet of each result.
+func ParseSearch(r *http.Request) (Params, error) {
+	query := r.URL.Query()
+	p := Params{Limit: DefaultSearchLimit, sort: rankSort}
+
+	if err := p.parseLimit(query.Get("limit")); err != nil {
+		return p, err
+	}
+	if query.Has("sort") {
+		return p, fmt.Errorf("search results are ordered by rank and cannot be sorted")
+	}
+	if err := p.parseFields(query.Get("fields"), searchFields); err != nil {
+		return p, err
+	}
+
+	if raw := query.Get("cursor"); raw != "" {
+		c, err := decodeCursor(raw)
+		if err != nil || !validSearchCursor(c) {
+			return p, fmt.Errorf("invalid cursor")
+		}
+		p.After = c.After
+	}
+	return p, nil
+}
+
+func (p *Params) parseLimit(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 {
+		return fmt.Errorf("invalid limit %q", raw)
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	p.Limit = limit
+	return nil
+}
+
+func (p *Params) parseFields(raw string, allowed map[string]bool) error {
+	for _, field := range splitList(raw) {
+		if !allowed[field] {
+			return fmt.Errorf("unknown field %q", field)
+		}
+		p.Fields = append(p.Fields, field)
+	}
+	return nil
+}
+
+// Page returns the page of the listing to fetch. It asks for one book more
+// than the limit so that Write can tell whether another page follows.
+func (p Params) Page() store.Page {
+	return store.Page{Limit: p.Limit + 1, Sort: p.Sort, After: p.After}
+}
+
+// Write encodes books, fetched with Page, as the response to r. It sets the
+// next page headers when there are more books than the limit.
+func (p Params) Write(w http.ResponseWriter, r *http.Request, books []models.Book) {
+	write(p, w, r, books, func(book models.Book) []interface{} {
+		return store.BookCursor(book, p.Sort)
+	})
+}
+
+// WriteResults encodes search results, fetched with the Page of ParseSearch,
+// as the response to r. It sets the next page headers when there are more
+// results than the limit.
+func (p Params) WriteResults(w http.ResponseWriter, r *http.Request, results []models.SearchResult) {
+	write(p, w, r, results, store.SearchCursor)
+}
+
+// WriteResultBooks is WriteResults for endpoints that return the books of
+// the results without their score and snippet
+func (p Params) WriteResultBooks(w http.ResponseWriter, r *http.Request, results []models.SearchResult) {
+	if len(results) > p.Limit {
+		p.setNext(w, r, store.SearchCursor(results[p.Limit-1]))
+		results = results[:p.Limit]
+	}
+	books := make([]models.Book, len(results))
+	for i, result := range results {
+		books[i] = result.Book
+	}
+	p.encode(w, books)
+}
+
+// write encodes items, fetched with Page, keeping at most the limit and
+// setting the next page headers from the position of the last item kept
+func write[T any](p Params, w http.ResponseWriter, r *http.Request, items []T, position func(T) []interface{}) {
+	if len(items) > p.Limit {
+		items = items[:p.Limit]
+		p.setNext(w, r, position(items[len(items)-1]))
+	}
+	if items == nil {
+		items = []T{}
+	}
+	p.encode(w, items)
+}
+
+// setNext sets the headers pointing to the page that starts after the
+// position after
+func (p Params) setNext(w http.ResponseWriter, r *http.Request, after []interface{}) {
+	next := encodeCursor(cursor{Sort: p.sort, After: after})
+
+	u := *r.URL
+	query := u.Query()
+	query.Set("cursor", next)
+	u.RawQuery = query.Encode()
+
+	w.Header().Set("X-Next-Cursor", next)
+	w.Header().Set("Link", "<"+u.RequestURI()+`>; rel="next"`)
+}
+
+// encode writes items, a slice of models, as JSON holding only the selected
+// fields, or all of them when none were selected
+func (p Params) encode(w http.ResponseWriter, items interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if len(p.Fields) == 0 {
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+	json.NewEncoder(w).Encode(selectFields(items, p.Fields))
+}
+
+// selectFields returns items, a slice of models, as JSON objects holding only fields
+func selectFields(items interface{}, fields []string) []map[string]json.RawMessage {
+	data, _ := json.Marshal(items)
+	var all []map[string]json.RawMessage
+	json.Unmarshal(data, &all)
+
+	selected := make([]map[string]json.RawMessage, len(all))
+	for i, item := range all {
+		selected[i] = make(map[string]json.RawMessage, len(fields))
+		for _, field := range fields {
+			selected[i][field] = item[field]
+		}
+	}
+	return selected
+}
+
+// validCursor reports whether c holds one scalar value per key of sort plus the ID tie-breaker
+func validCursor(c cursor, sort []store.SortKey) bool {
+	want := len(sort) + 1
+	for _, key := range sort {
+		if key.Field == "id" {
+			want--
+			break
+		}
+	}
+	if len(c.After) != want {
+		return false
+	}
+	for _, value := range c.After {
+		switch value.(type) {
+		case string, float64:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validSearchCursor reports whether c holds the score and ID of a search result
+func validSearchCursor(c cursor) bool {
+	if c.Sort != rankSort || len(c.After) != 2 {
+		return false
+	}
+	for _, value := range c.After {
+		if _, ok := value.(float64); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// splitList splits a comma-separated parameter, ignoring empty entries
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
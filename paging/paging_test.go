@@ -0,0 +1,152 @@
+package paging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang_project/models"
+	"golang_project/store"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false},
+		{"limit=10&sort=genre,-title&fields=id,title", false},
+		{"limit=0", true},
+		{"limit=ten", true},
+		{"sort=password", true},
+		{"sort=-total_copies", true},
+		{"fields=id,secret", true},
+		{"cursor=not-a-cursor", true},
+		{"sort=title&cursor=" + encodeCursor(cursor{Sort: "-title", After: []interface{}{"Emma", 1.0}}), true},
+		{"sort=title&cursor=" + encodeCursor(cursor{Sort: "title", After: []interface{}{"Emma"}}), true},
+		{"sort=title&cursor=" + encodeCursor(cursor{Sort: "title", After: []interface{}{"Emma", 1.0}}), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/books?"+tt.query, nil)
+		if _, err := Parse(r, ""); (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q): got error %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/books?limit=500&sort=genre,-title", nil)
+	p, err := Parse(r, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Limit != MaxLimit {
+		t.Errorf("limit = %d, want %d", p.Limit, MaxLimit)
+	}
+	if len(p.Sort) != 2 || p.Sort[0] != (store.SortKey{Field: "genre"}) || p.Sort[1] != (store.SortKey{Field: "title", Desc: true}) {
+		t.Errorf("unexpected sort: %+v", p.Sort)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := httptest.NewRequest("GET", "/books?limit=2&sort=-title&fields=id,title", nil)
+	p, err := Parse(r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	books := []models.Book{{ID: 3, Title: "Emma", Author: "Jane Austen"}, {ID: 1, Title: "Dune"}, {ID: 2, Title: "Beloved"}}
+	rr := httptest.NewRecorder()
+	p.Write(rr, r, books)
+
+	var page []map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(page) != 2 || len(page[0]) != 2 || page[0]["title"] != "Emma" {
+		t.Errorf("unexpected page: %v", page)
+	}
+
+	next := rr.Header().Get("X-Next-Cursor")
+	c, err := decodeCursor(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Sort != "-title" || len(c.After) != 2 || c.After[0] != "Dune" || c.After[1] != 1.0 {
+		t.Errorf("unexpected cursor: %+v", c)
+	}
+	wantLink := "</books?" + url.Values{"limit": {"2"}, "sort": {"-title"}, "fields": {"id,title"}, "cursor": {next}}.Encode() + `>; rel="next"`
+	if link := rr.Header().Get("Link"); link != wantLink {
+		t.Errorf("Link = %q, want %q", link, wantLink)
+	}
+
+	rr = httptest.NewRecorder()
+	p.Write(rr, r, books[:2])
+	if rr.Header().Get("Link") != "" || rr.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("expected no next page headers on the last page")
+	}
+	if rr.Code != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"", false},
+		{"limit=5&fields=id,score,snippet", false},
+		{"sort=title", true},
+		{"fields=password", true},
+		{"cursor=" + encodeCursor(cursor{Sort: "title", After: []interface{}{"Emma", 1.0}}), true},
+		{"cursor=" + encodeCursor(cursor{Sort: rankSort, After: []interface{}{1.5}}), true},
+		{"cursor=" + encodeCursor(cursor{Sort: rankSort, After: []interface{}{1.5, 3.0}}), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/books/search?q=dune&"+tt.query, nil)
+		if _, err := ParseSearch(r); (err != nil) != tt.wantErr {
+			t.Errorf("ParseSearch(%q): got error %v, want error %v", tt.query, err, tt.wantErr)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/books/search?q=dune&limit=1", nil)
+	p, err := ParseSearch(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := []models.SearchResult{
+		{Book: models.Book{ID: 4, Title: "Dune"}, Score: 2.5, Snippet: "<mark>Dune</mark>"},
+		{Book: models.Book{ID: 2, Title: "Dune Messiah"}, Score: 1.5},
+	}
+	rr := httptest.NewRecorder()
+	p.WriteResults(rr, r, results)
+
+	var page []models.SearchResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(page) != 1 || page[0].ID != 4 || page[0].Snippet != "<mark>Dune</mark>" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+	c, err := decodeCursor(rr.Header().Get("X-Next-Cursor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Sort != rankSort || len(c.After) != 2 || c.After[0] != 2.5 || c.After[1] != 4.0 {
+		t.Errorf("unexpected cursor: %+v", c)
+	}
+
+	rr = httptest.NewRecorder()
+	p.WriteResultBooks(rr, r, results)
+	var books []map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &books); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(books) != 1 || books[0]["title"] != "Dune" || books[0]["snippet"] != nil {
+		t.Errorf("unexpected books: %v", books)
+	}
+	if rr.Header().Get("X-Next-Cursor") == "" {
+		t.Errorf("expected a next page cursor for the books of the results")
+	}
+}
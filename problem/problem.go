@@ -0,0 +1,117 @@
+// Package problem writes error responses as RFC 7807 problem details.
+//
+// Every error response has the media type application/problem+json and a
+// stable machine-readable code alongside the HTTP status:
+//
+//	{
+//	  "type": "about:blank",
+//	  "title": "Not Found",
+//	  "status": 404,
+//	  "detail": "Book not found",
+//	  "instance": "/books/read",
+//	  "code": "not_found",
+//	  "request_id": "4f6c1d0e9a7b2c35"
+//	}
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"golang_project/store"
+)
+
+// ContentType is the media type of problem details responses
+const ContentType = "application/problem+json"
+
+// Code identifies the kind of a problem. Codes never change once published,
+// so clients may match on them instead of on the detail text.
+type Code string
+
+const (
+	// CodeInvalidRequest means the body or parameters could not be read
+	CodeInvalidRequest Code = "invalid_request"
+	// CodeValidationFailed means the request was read but its values are not acceptable
+	CodeValidationFailed Code = "validation_failed"
+	// CodeUnauthorized means the request has no valid token
+	CodeUnauthorized Code = "unauthorized"
+	// CodeInvalidCredentials means a login was refused
+	CodeInvalidCredentials Code = "invalid_credentials"
+	// CodeForbidden means the caller's role lacks the permission the route requires
+	CodeForbidden Code = "forbidden"
+	// CodeCSRFFailed means a cookie-authenticated request lacked a matching CSRF token
+	CodeCSRFFailed Code = "csrf_failed"
+	// CodeTwoFactorRequired means a second factor is needed to log in or to use the route
+	CodeTwoFactorRequired Code = "two_factor_required"
+	// CodeTooManyAttempts means logins are refused for a while after failed ones
+	CodeTooManyAttempts Code = "too_many_attempts"
+	// CodeAccountLocked means the account is locked after too many failed logins
+	CodeAccountLocked Code = "account_locked"
+	// CodeEmailUnverified means the account has not confirmed its email address yet
+	CodeEmailUnverified Code = "email_unverified"
+	// CodeAccountInactive means the patron's account is not active
+	CodeAccountInactive Code = "account_inactive"
+	// CodeFinesOutstanding means the patron owes more than the checkout threshold
+	CodeFinesOutstanding Code = "fines_outstanding"
+	// CodeNotFound means the requested record does not exist
+	CodeNotFound Code = "not_found"
+	// CodeMethodNotAllowed means the route does not accept the request method
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	// CodeConflict means the request clashes with the current state of a record
+	CodeConflict Code = "conflict"
+	// CodeUnsupportedMediaType means the body is in a format the route does not accept
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	// CodePreconditionRequired means a write lacked the If-Match header it requires
+	CodePreconditionRequired Code = "precondition_required"
+	// CodePreconditionFailed means the record was changed since the version the request names
+	CodePreconditionFailed Code = "precondition_failed"
+	// CodeInternal means the server failed to handle the request
+	CodeInternal Code = "internal_error"
+	// CodeNotImplemented means the feature is not available in this build
+	CodeNotImplemented Code = "not_implemented"
+)
+
+// Problem is an RFC 7807 problem details object with the code and request ID extensions
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      Code   `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Write sends a problem with the given status, code and detail in response to r
+func Write(w http.ResponseWriter, r *http.Request, status int, code Code, detail string) {
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: RequestIDFromContext(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// StoreError reports a failed repository call as 404 with notFound as the
+// detail when the record is missing, as 412 when it was changed since it was
+// read, and as 500 otherwise
+func StoreError(w http.ResponseWriter, r *http.Request, err error, notFound string) {
+	if errors.Is(err, store.ErrNotFound) {
+		Write(w, r, http.StatusNotFound, CodeNotFound, notFound)
+		return
+	}
+	if errors.Is(err, store.ErrVersionMismatch) {
+		Write(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "Record was changed since it was read")
+		return
+	}
+	Write(w, r, http.StatusInternalServerError, CodeInternal, "Error querying database")
+}
@@ -0,0 +1,82 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func decode(t *testing.T, rr *httptest.ResponseRecorder) Problem {
+	if ct := rr.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q, want %q", ct, ContentType)
+	}
+	var p Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	return p
+}
+
+func TestWrite(t *testing.T) {
+	req := httptest.NewRequest("GET", "/books/read?id=7", nil)
+	rr := httptest.NewRecorder()
+	Write(rr, req, http.StatusNotFound, CodeNotFound, "Book not found")
+
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+	want := Problem{Type: "about:blank", Title: "Not Found", Status: 404, Detail: "Book not found", Instance: "/books/read", Code: CodeNotFound}
+	if p := decode(t, rr); p != want {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		header string
+		reused bool
+	}{
+		{"", false},
+		{"client-id_42", true},
+		{"bad id\r\nX-Injected: 1", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			req.Header.Set(RequestIDHeader, tt.header)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if seen == "" || rr.Header().Get(RequestIDHeader) != seen {
+			t.Errorf("header %q: context ID %q, response header %q", tt.header, seen, rr.Header().Get(RequestIDHeader))
+		}
+		if (seen == tt.header) != tt.reused {
+			t.Errorf("header %q: got ID %q, want reused %v", tt.header, seen, tt.reused)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	handler := RequestID(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	req := httptest.NewRequest("GET", "/books", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	}
+	p := decode(t, rr)
+	if p.Code != CodeInternal || p.RequestID == "" || p.RequestID != rr.Header().Get(RequestIDHeader) {
+		t.Errorf("unexpected problem: %+v", p)
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"golang_project/models"
+)
+
+const apiKeyColumns = "id, name, prefix, key_hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at"
+
+// SQLiteAPIKeyRepository is an APIKeyRepository backed by a SQLite database
+type SQLiteAPIKeyRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteAPIKeyRepository returns an APIKeyRepository using db
+func NewSQLiteAPIKeyRepository(db *sql.DB) *SQLiteAPIKeyRepository {
+	return &SQLiteAPIKeyRepository{db: db}
+}
+
+// Create inserts key and sets its ID. It returns ErrConflict when the prefix is taken.
+func (r *SQLiteAPIKeyRepository) Create(key *models.APIKey) error {
+	res, err := r.db.Exec("INSERT INTO api_keys(name, prefix, key_hash, scopes, created_by, created_at, expires_at) VALUES(?, ?, ?, ?, ?, ?, ?)",
+		key.Name, key.Prefix, key.KeyHash, strings.Join(key.Scopes, " "), key.CreatedBy, key.CreatedAt, key.ExpiresAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	key.ID = int(id)
+	return nil
+}
+
+// Get returns the key with the given ID, revoked or not
+func (r *SQLiteAPIKeyRepository) Get(id int) (models.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrNotFound
+	}
+	return key, err
+}
+
+// GetByPrefix returns the key with the given prefix, revoked or not
+func (r *SQLiteAPIKeyRepository) GetByPrefix(prefix string) (models.APIKey, error) {
+	key, err := scanAPIKey(r.db.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE prefix = ?", prefix))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrNotFound
+	}
+	return key, err
+}
+
+// List returns every key, newest first
+func (r *SQLiteAPIKeyRepository) List() ([]models.APIKey, error) {
+	rows, err := r.db.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke marks the key with the given ID as revoked. It returns ErrNotFound
+// when there is no such key or it is already revoked.
+func (r *SQLiteAPIKeyRepository) Revoke(id int, at time.Time) error {
+	res, err := r.db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", at, id)
+	return affectedOne(res, err)
+}
+
+// Touch records that the key with the given ID was used at the given time
+func (r *SQLiteAPIKeyRepository) Touch(id int, at time.Time) error {
+	res, err := r.db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", at, id)
+	return affectedOne(res, err)
+}
+
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &key.CreatedBy, &key.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return key, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, nil
+}
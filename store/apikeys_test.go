@@ -0,0 +1,56 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	db := openTestDB(t)
+	user := models.User{Name: "Emma Watson", Email: "emma.watson@example.com", IsActive: true, Role: "bookkeeper"}
+	if err := NewSQLiteUserRepository(db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	keys := NewSQLiteAPIKeyRepository(db)
+
+	now := time.Now().UTC()
+	key := models.APIKey{Name: "scanner", Prefix: "lib_abc", KeyHash: "hash", Scopes: []string{"books:write", "loans:write"}, CreatedBy: user.ID, CreatedAt: now}
+	if err := keys.Create(&key); err != nil {
+		t.Fatal(err)
+	}
+	duplicate := key
+	if err := keys.Create(&duplicate); !errors.Is(err, ErrConflict) {
+		t.Errorf("duplicate prefix: got %v want %v", err, ErrConflict)
+	}
+
+	if err := keys.Touch(key.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	got, err := keys.GetByPrefix("lib_abc")
+	if err != nil || got.ID != key.ID || len(got.Scopes) != 2 || got.Scopes[1] != "loans:write" || got.LastUsedAt == nil {
+		t.Errorf("stored key: got %+v, %v", got, err)
+	}
+	if _, err := keys.GetByPrefix("lib_xyz"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown prefix: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := keys.Revoke(key.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := keys.Get(key.ID); err != nil || got.Prefix != "lib_abc" || got.RevokedAt == nil {
+		t.Errorf("revoked key: got %+v, %v", got, err)
+	}
+	if _, err := keys.Get(key.ID + 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown ID: got %v want %v", err, ErrNotFound)
+	}
+	if err := keys.Revoke(key.ID, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoke twice: got %v want %v", err, ErrNotFound)
+	}
+	list, err := keys.List()
+	if err != nil || len(list) != 1 || list[0].RevokedAt == nil {
+		t.Errorf("listed keys: got %+v, %v", list, err)
+	}
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"database/sql"
+	"strings"
+
+	"golang_project/models"
+)
+
+// SQLiteAuditRepository is an AuditRepository backed by a SQLite database.
+// Triggers in the schema refuse to update or delete entries.
+type SQLiteAuditRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteAuditRepository returns an AuditRepository using db
+func NewSQLiteAuditRepository(db *sql.DB) *SQLiteAuditRepository {
+	return &SQLiteAuditRepository{db: db}
+}
+
+// Append adds entry to the end of the log and sets its ID
+func (r *SQLiteAuditRepository) Append(entry *models.AuditEntry) error {
+	res, err := r.db.Exec(`INSERT INTO audit_log(actor_id, actor, api_key_id, action, entity, entity_id, changes, ip, request_id, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ActorID, entry.Actor, entry.APIKeyID, entry.Action, entry.Entity, entry.EntityID, string(entry.Changes), entry.IP, entry.RequestID, entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.ID = int(id)
+	return nil
+}
+
+// List returns up to filter.Limit entries matching filter, most recent first
+func (r *SQLiteAuditRepository) List(filter AuditFilter) ([]models.AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.ActorID != 0 {
+		conditions = append(conditions, "actor_id = ?")
+		args = append(args, filter.ActorID)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Entity != "" {
+		conditions = append(conditions, "entity = ?")
+		args = append(args, filter.Entity)
+	}
+	if filter.EntityID != 0 {
+		conditions = append(conditions, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until)
+	}
+	if filter.BeforeID != 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+
+	query := "SELECT id, actor_id, actor, api_key_id, action, entity, entity_id, changes, ip, request_id, created_at FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var actorID, apiKeyID sql.NullInt64
+		var changes string
+		if err := rows.Scan(&entry.ID, &actorID, &entry.Actor, &apiKeyID, &entry.Action, &entry.Entity, &entry.EntityID, &changes, &entry.IP, &entry.RequestID, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			entry.ActorID = &id
+		}
+		if apiKeyID.Valid {
+			id := int(apiKeyID.Int64)
+			entry.APIKeyID = &id
+		}
+		entry.Changes = []byte(changes)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
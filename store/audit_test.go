@@ -0,0 +1,75 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestAuditRepository(t *testing.T) {
+	db := openTestDB(t)
+	audit := NewSQLiteAuditRepository(db)
+
+	now := time.Now().UTC()
+	actor := 3
+	for i, entry := range []models.AuditEntry{
+		{ActorID: &actor, Actor: "emma@example.com", Action: models.AuditCreate, Entity: "book", EntityID: 1},
+		{ActorID: &actor, Actor: "emma@example.com", Action: models.AuditUpdate, Entity: "book", EntityID: 1},
+		{Action: models.AuditCreate, Entity: "user", EntityID: 9},
+		{ActorID: &actor, Actor: "emma@example.com", Action: models.AuditDelete, Entity: "book", EntityID: 2},
+	} {
+		entry.Changes = []byte(`{"title":{"after":"Dune"}}`)
+		entry.IP = "10.0.0.1"
+		entry.CreatedAt = now.Add(time.Duration(i) * time.Hour)
+		if err := audit.Append(&entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   []int
+	}{
+		{"everything", AuditFilter{Limit: 10}, []int{4, 3, 2, 1}},
+		{"limit", AuditFilter{Limit: 2}, []int{4, 3}},
+		{"before", AuditFilter{BeforeID: 3, Limit: 10}, []int{2, 1}},
+		{"actor", AuditFilter{ActorID: actor, Limit: 10}, []int{4, 2, 1}},
+		{"action", AuditFilter{Action: models.AuditCreate, Limit: 10}, []int{3, 1}},
+		{"entity", AuditFilter{Entity: "book", EntityID: 1, Limit: 10}, []int{2, 1}},
+		{"period", AuditFilter{Since: now.Add(time.Hour), Until: now.Add(3 * time.Hour), Limit: 10}, []int{3, 2}},
+	}
+	for _, tt := range tests {
+		entries, err := audit.List(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, entry := range entries {
+			got = append(got, entry.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+
+	entries, err := audit.List(AuditFilter{EntityID: 9, Limit: 1})
+	if err != nil || len(entries) != 1 || entries[0].ActorID != nil || string(entries[0].Changes) != `{"title":{"after":"Dune"}}` {
+		t.Errorf("anonymous entry: got %+v, %v", entries, err)
+	}
+
+	if _, err := db.Exec("UPDATE audit_log SET action = 'create'"); err == nil {
+		t.Errorf("updating the audit log succeeded")
+	}
+	if _, err := db.Exec("DELETE FROM audit_log"); err == nil {
+		t.Errorf("deleting from the audit log succeeded")
+	}
+}
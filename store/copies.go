@@ -0,0 +1,138 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"golang_project/models"
+)
+
+// copyAvailable is true for the copy aliased c when it has no open loan
+// and is not set aside for a hold that is ready for pickup
+const copyAvailable = `NOT EXISTS (SELECT 1 FROM loans l WHERE l.copy_id = c.id AND l.return_date IS NULL)
+	AND NOT EXISTS (SELECT 1 FROM holds h WHERE h.copy_id = c.id AND h.status = 'ready')`
+
+const copyColumns = "c.id, c.book_id, c.barcode, c.condition, c.shelf_location, " + copyAvailable
+
+// SQLiteCopyRepository is a CopyRepository backed by a SQLite database
+type SQLiteCopyRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteCopyRepository returns a CopyRepository using db
+func NewSQLiteCopyRepository(db *sql.DB) *SQLiteCopyRepository {
+	return &SQLiteCopyRepository{db: db}
+}
+
+// ListByBook returns the copies of a book ordered by ID
+func (r *SQLiteCopyRepository) ListByBook(bookID int) ([]models.Copy, error) {
+	rows, err := r.db.Query("SELECT "+copyColumns+" FROM copies c WHERE c.book_id = ? ORDER BY c.id", bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var copies []models.Copy
+	for rows.Next() {
+		c, err := scanCopy(rows)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, c)
+	}
+	return copies, rows.Err()
+}
+
+// Get returns the copy with the given ID
+func (r *SQLiteCopyRepository) Get(id int) (models.Copy, error) {
+	c, err := scanCopy(r.db.QueryRow("SELECT "+copyColumns+" FROM copies c WHERE c.id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+// Create inserts c and sets its ID. It returns ErrConflict when the barcode is taken.
+func (r *SQLiteCopyRepository) Create(c *models.Copy) error {
+	res, err := r.db.Exec("INSERT INTO copies(book_id, barcode, condition, shelf_location) VALUES(?, ?, ?, ?)",
+		c.BookID, c.Barcode, c.Condition, c.ShelfLocation)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	c.ID = int(id)
+	c.Available = true
+	return nil
+}
+
+// Update overwrites the barcode, condition and shelf location of c.
+// It returns ErrConflict when the barcode is taken.
+func (r *SQLiteCopyRepository) Update(c models.Copy) error {
+	res, err := r.db.Exec("UPDATE copies SET barcode = ?, condition = ?, shelf_location = ? WHERE id = ?",
+		c.Barcode, c.Condition, c.ShelfLocation, c.ID)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	return affectedOne(res, err)
+}
+
+// Delete removes the copy with the given ID. It returns ErrConflict when the
+// copy is on loan or set aside for a hold.
+func (r *SQLiteCopyRepository) Delete(id int) error {
+	res, err := r.db.Exec("DELETE FROM copies WHERE id IN (SELECT c.id FROM copies c WHERE c.id = ? AND "+copyAvailable+")", id)
+	err = affectedOne(res, err)
+	if errors.Is(err, ErrNotFound) {
+		if _, err := r.Get(id); err != nil {
+			return err
+		}
+		return ErrConflict
+	}
+	return err
+}
+
+func scanCopy(row scanner) (models.Copy, error) {
+	var c models.Copy
+	err := row.Scan(&c.ID, &c.BookID, &c.Barcode, &c.Condition, &c.ShelfLocation, &c.Available)
+	return c, err
+}
+
+// rowQuerier is implemented by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// staleVersion explains why an update of the row of table with the given ID,
+// guarded by its version, touched nothing: ErrVersionMismatch when the row is
+// still there outside the trash, ErrNotFound otherwise
+func staleVersion(q rowQuerier, table string, id int) error {
+	var n int
+	err := q.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE ID = ? AND deleted_at IS NULL", id).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrVersionMismatch
+	}
+	return ErrNotFound
+}
+
+// affectedOne turns an Exec result that touched no rows into ErrNotFound
+func affectedOne(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
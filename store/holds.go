@@ -0,0 +1,173 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang_project/models"
+)
+
+// holdColumns selects a hold together with its queue position, which is only
+// set for waiting holds and counts from 1 at the head of the queue
+const holdColumns = `h.id, h.user_id, h.book_id, IFNULL(h.copy_id, 0), h.status,
+	CASE WHEN h.status = 'waiting' THEN
+		(SELECT COUNT(*) FROM holds q WHERE q.book_id = h.book_id AND q.status = 'waiting' AND q.id <= h.id)
+	ELSE 0 END,
+	h.created_at, h.expires_at`
+
+// SQLiteHoldRepository is a HoldRepository backed by a SQLite database
+type SQLiteHoldRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteHoldRepository returns a HoldRepository using db
+func NewSQLiteHoldRepository(db *sql.DB) *SQLiteHoldRepository {
+	return &SQLiteHoldRepository{db: db}
+}
+
+// Get returns the hold with the given ID
+func (r *SQLiteHoldRepository) Get(id int) (models.Hold, error) {
+	hold, err := scanHold(r.db.QueryRow("SELECT "+holdColumns+" FROM holds h WHERE h.id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return hold, ErrNotFound
+	}
+	return hold, err
+}
+
+// Create queues a waiting hold and sets its ID and position.
+// It returns ErrConflict when the user already has an open hold on the book.
+func (r *SQLiteHoldRepository) Create(hold *models.Hold) error {
+	res, err := r.db.Exec("INSERT INTO holds(user_id, book_id, status, created_at) VALUES(?, ?, ?, ?)",
+		hold.UserID, hold.BookID, models.HoldWaiting, hold.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	*hold, err = r.Get(int(id))
+	return err
+}
+
+// ListOpenByBook returns the waiting and ready holds on a book in queue order
+func (r *SQLiteHoldRepository) ListOpenByBook(bookID int) ([]models.Hold, error) {
+	return r.query("SELECT "+holdColumns+" FROM holds h WHERE h.book_id = ? AND h.status IN ('waiting', 'ready') ORDER BY h.id", bookID)
+}
+
+// ListOpenByUser returns the waiting and ready holds of a user, oldest first
+func (r *SQLiteHoldRepository) ListOpenByUser(userID int) ([]models.Hold, error) {
+	return r.query("SELECT "+holdColumns+" FROM holds h WHERE h.user_id = ? AND h.status IN ('waiting', 'ready') ORDER BY h.id", userID)
+}
+
+// GetOpenByUserAndBook returns the waiting or ready hold a user has on a book
+func (r *SQLiteHoldRepository) GetOpenByUserAndBook(userID, bookID int) (models.Hold, error) {
+	hold, err := scanHold(r.db.QueryRow("SELECT "+holdColumns+" FROM holds h WHERE h.user_id = ? AND h.book_id = ? AND h.status IN ('waiting', 'ready')", userID, bookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return hold, ErrNotFound
+	}
+	return hold, err
+}
+
+// GetReadyForCopy returns the ready hold the copy is set aside for
+func (r *SQLiteHoldRepository) GetReadyForCopy(copyID int) (models.Hold, error) {
+	hold, err := scanHold(r.db.QueryRow("SELECT "+holdColumns+" FROM holds h WHERE h.copy_id = ? AND h.status = 'ready'", copyID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return hold, ErrNotFound
+	}
+	return hold, err
+}
+
+// PromoteNext sets the copy aside for the oldest waiting hold on the book and
+// reports whether there was one to promote
+func (r *SQLiteHoldRepository) PromoteNext(bookID, copyID int, readyAt, expiresAt time.Time) (bool, error) {
+	return promoteNext(r.db, bookID, copyID, readyAt, expiresAt)
+}
+
+func promoteNext(db execer, bookID, copyID int, readyAt, expiresAt time.Time) (bool, error) {
+	err := affectedOne(db.Exec(`UPDATE holds SET status = 'ready', copy_id = ?, ready_at = ?, expires_at = ?
+		WHERE id = (SELECT id FROM holds WHERE book_id = ? AND status = 'waiting' ORDER BY id LIMIT 1)`,
+		copyID, readyAt, expiresAt, bookID))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Close moves an open hold to a final status. It returns ErrConflict when the hold is already closed.
+func (r *SQLiteHoldRepository) Close(id int, status string) error {
+	res, err := r.db.Exec("UPDATE holds SET status = ? WHERE id = ? AND status IN ('waiting', 'ready')", status, id)
+	err = affectedOne(res, err)
+	if errors.Is(err, ErrNotFound) {
+		if _, err := r.Get(id); err != nil {
+			return err
+		}
+		return ErrConflict
+	}
+	return err
+}
+
+// ExpireReady marks ready holds whose pickup window ended at or before now as
+// expired and returns them so their copies can be passed on
+func (r *SQLiteHoldRepository) ExpireReady(now time.Time) ([]models.Hold, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT "+holdColumns+" FROM holds h WHERE h.status = 'ready' AND h.expires_at <= ? ORDER BY h.id", now)
+	if err != nil {
+		return nil, err
+	}
+	expired, err := scanHolds(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hold := range expired {
+		if _, err := tx.Exec("UPDATE holds SET status = 'expired' WHERE id = ?", hold.ID); err != nil {
+			return nil, err
+		}
+	}
+	return expired, tx.Commit()
+}
+
+func (r *SQLiteHoldRepository) query(query string, args ...interface{}) ([]models.Hold, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanHolds(rows)
+}
+
+func scanHolds(rows *sql.Rows) ([]models.Hold, error) {
+	defer rows.Close()
+
+	var holds []models.Hold
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, hold)
+	}
+	return holds, rows.Err()
+}
+
+func scanHold(row scanner) (models.Hold, error) {
+	var hold models.Hold
+	var expiresAt sql.NullTime
+	err := row.Scan(&hold.ID, &hold.UserID, &hold.BookID, &hold.CopyID, &hold.Status, &hold.Position, &hold.CreatedAt, &expiresAt)
+	if expiresAt.Valid {
+		hold.ExpiresAt = &expiresAt.Time
+	}
+	return hold, err
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"golang_project/models"
+)
+
+// SQLiteLedgerRepository is a LedgerRepository backed by a SQLite database
+type SQLiteLedgerRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteLedgerRepository returns a LedgerRepository using db
+func NewSQLiteLedgerRepository(db *sql.DB) *SQLiteLedgerRepository {
+	return &SQLiteLedgerRepository{db: db}
+}
+
+// Create appends entry to the ledger and sets its ID
+func (r *SQLiteLedgerRepository) Create(entry *models.LedgerEntry) error {
+	return insertLedgerEntry(r.db, entry)
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertLedgerEntry(db execer, entry *models.LedgerEntry) error {
+	var loanID interface{}
+	if entry.LoanID != 0 {
+		loanID = entry.LoanID
+	}
+	res, err := db.Exec("INSERT INTO ledger_entries(user_id, loan_id, kind, amount, note, recorded_by, created_at) VALUES(?, ?, ?, ?, ?, ?, ?)",
+		entry.UserID, loanID, entry.Kind, entry.Amount, entry.Note, entry.RecordedBy, entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.ID = int(id)
+	return nil
+}
+
+// Credit appends entry, a payment or waiver, to the ledger and sets its ID,
+// checking in the same statement that it does not exceed what the user owes.
+// It returns ErrConflict when it does.
+func (r *SQLiteLedgerRepository) Credit(entry *models.LedgerEntry) error {
+	res, err := r.db.Exec(`INSERT INTO ledger_entries(user_id, loan_id, kind, amount, note, recorded_by, created_at)
+		SELECT ?, NULL, ?, ?, ?, ?, ? WHERE (`+balanceQuery+`) >= ?`,
+		entry.UserID, entry.Kind, entry.Amount, entry.Note, entry.RecordedBy, entry.CreatedAt, entry.UserID, entry.Amount)
+	if err := affectedOne(res, err); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrConflict
+		}
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.ID = int(id)
+	return nil
+}
+
+// chargeFine appends fine, a charge for a loan, to the ledger within tx,
+// lowering its amount so that the charges of the loan add up to at most
+// maxFine when that is set. It leaves fine.ID zero when there is nothing to
+// charge.
+func chargeFine(tx *sql.Tx, fine *models.LedgerEntry, maxFine int) error {
+	if fine == nil || fine.Amount <= 0 {
+		return nil
+	}
+	if maxFine > 0 {
+		var charged int
+		err := tx.QueryRow("SELECT IFNULL(SUM(amount), 0) FROM ledger_entries WHERE loan_id = ? AND kind = 'charge'", fine.LoanID).Scan(&charged)
+		if err != nil {
+			return err
+		}
+		if fine.Amount > maxFine-charged {
+			fine.Amount = maxFine - charged
+		}
+		if fine.Amount <= 0 {
+			fine.Amount = 0
+			return nil
+		}
+	}
+	return insertLedgerEntry(tx, fine)
+}
+
+// ListByUser returns the ledger entries of a user, oldest first
+func (r *SQLiteLedgerRepository) ListByUser(userID int) ([]models.LedgerEntry, error) {
+	rows, err := r.db.Query("SELECT id, user_id, IFNULL(loan_id, 0), kind, amount, note, recorded_by, created_at FROM ledger_entries WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.LedgerEntry
+	for rows.Next() {
+		var entry models.LedgerEntry
+		err = rows.Scan(&entry.ID, &entry.UserID, &entry.LoanID, &entry.Kind, &entry.Amount, &entry.Note, &entry.RecordedBy, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// balanceQuery selects what the user whose ID is its parameter owes
+const balanceQuery = "SELECT IFNULL(SUM(CASE kind WHEN 'charge' THEN amount ELSE -amount END), 0) FROM ledger_entries WHERE user_id = ?"
+
+// Balance returns what the user owes
+func (r *SQLiteLedgerRepository) Balance(userID int) (int, error) {
+	var balance int
+	err := r.db.QueryRow(balanceQuery, userID).Scan(&balance)
+	return balance, err
+}
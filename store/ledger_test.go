@@ -0,0 +1,56 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestConcurrentCreditsStayWithinBalance(t *testing.T) {
+	db := openTestDB(t)
+	users := NewSQLiteUserRepository(db)
+	ledger := NewSQLiteLedgerRepository(db)
+
+	patron := models.User{Name: "Jane Doe", Email: "jane@example.com", IsActive: true, Role: "user"}
+	if err := users.Create(&patron); err != nil {
+		t.Fatal(err)
+	}
+	charge := models.LedgerEntry{UserID: patron.ID, Kind: models.LedgerCharge, Amount: 500, CreatedAt: time.Now().UTC()}
+	if err := ledger.Create(&charge); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	credited, conflicts := 0, 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payment := models.LedgerEntry{UserID: patron.ID, Kind: models.LedgerPayment, Amount: 200, CreatedAt: time.Now().UTC()}
+			err := ledger.Credit(&payment)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil && payment.ID != 0:
+				credited++
+			case errors.Is(err, ErrConflict):
+				conflicts++
+			default:
+				t.Errorf("credit: got %+v, %v", payment, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if credited != 2 || conflicts != n-2 {
+		t.Errorf("got %d credits and %d conflicts want 2 and %d", credited, conflicts, n-2)
+	}
+	if balance, err := ledger.Balance(patron.ID); err != nil || balance != 100 {
+		t.Errorf("balance: got %d, %v want 100", balance, err)
+	}
+}
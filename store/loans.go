@@ -0,0 +1,192 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang_project/models"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const loanColumns = "loans.id, loans.user_id, loans.copy_id, copies.book_id, loans.checkout_date, loans.due_date, loans.return_date, loans.renewals"
+
+const loanTables = "loans JOIN copies ON copies.id = loans.copy_id"
+
+// SQLiteLoanRepository is a LoanRepository backed by a SQLite database
+type SQLiteLoanRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteLoanRepository returns a LoanRepository using db
+func NewSQLiteLoanRepository(db *sql.DB) *SQLiteLoanRepository {
+	return &SQLiteLoanRepository{db: db}
+}
+
+// Get returns the loan with the given ID
+func (r *SQLiteLoanRepository) Get(id int) (models.Loan, error) {
+	loan, err := scanLoan(r.db.QueryRow("SELECT "+loanColumns+" FROM "+loanTables+" WHERE loans.id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return loan, ErrNotFound
+	}
+	return loan, err
+}
+
+// Create inserts loan and sets its ID. It returns ErrConflict when the copy is already on loan.
+func (r *SQLiteLoanRepository) Create(loan *models.Loan) error {
+	res, err := r.db.Exec("INSERT INTO loans(user_id, copy_id, checkout_date, due_date) VALUES(?, ?, ?, ?)",
+		loan.UserID, loan.CopyID, loan.CheckoutDate, loan.DueDate)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	loan.ID = int(id)
+	return nil
+}
+
+// Checkout lends a copy in one transaction: it inserts loan and sets its ID,
+// then closes the borrower's open hold on the book as fulfilled. If that hold
+// had another copy set aside, the copy passes to the next waiting hold until
+// pickupUntil. It returns the hold as it was before it was closed, or nil if
+// the borrower had none. It returns ErrReserved when the copy is set aside for
+// another user and ErrConflict when it is already on loan.
+func (r *SQLiteLoanRepository) Checkout(loan *models.Loan, pickupUntil time.Time) (*models.Hold, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var holder int
+	err = tx.QueryRow("SELECT user_id FROM holds WHERE copy_id = ? AND status = 'ready'", loan.CopyID).Scan(&holder)
+	if err == nil && holder != loan.UserID {
+		return nil, ErrReserved
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	res, err := tx.Exec("INSERT INTO loans(user_id, copy_id, checkout_date, due_date) VALUES(?, ?, ?, ?)",
+		loan.UserID, loan.CopyID, loan.CheckoutDate, loan.DueDate)
+	if isUniqueViolation(err) {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	hold, err := scanHold(tx.QueryRow("SELECT "+holdColumns+" FROM holds h WHERE h.user_id = ? AND h.book_id = ? AND h.status IN ('waiting', 'ready')", loan.UserID, loan.BookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		loan.ID = int(id)
+		return nil, tx.Commit()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE holds SET status = ? WHERE id = ?", models.HoldFulfilled, hold.ID); err != nil {
+		return nil, err
+	}
+	if hold.Status == models.HoldReady && hold.CopyID != 0 && hold.CopyID != loan.CopyID {
+		if _, err := promoteNext(tx, hold.BookID, hold.CopyID, loan.CheckoutDate, pickupUntil); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	loan.ID = int(id)
+	return &hold, nil
+}
+
+// Return marks the loan as returned and charges fine, if not nil, in the
+// same transaction, capping the charges of the loan at maxFine as described
+// at chargeFine. It returns ErrConflict when the loan was already returned,
+// in which case nothing is charged.
+func (r *SQLiteLoanRepository) Return(id int, returnedAt time.Time, fine *models.LedgerEntry, maxFine int) error {
+	return r.updateActive(id, fine, maxFine, "UPDATE loans SET return_date = ? WHERE id = ? AND return_date IS NULL", returnedAt, id)
+}
+
+// Renew moves the due date of an active loan, counts the renewal and charges
+// fine like Return does. It returns ErrConflict when the loan was already
+// returned, in which case nothing is charged.
+func (r *SQLiteLoanRepository) Renew(id int, dueDate time.Time, fine *models.LedgerEntry, maxFine int) error {
+	return r.updateActive(id, fine, maxFine, "UPDATE loans SET due_date = ?, renewals = renewals + 1 WHERE id = ? AND return_date IS NULL", dueDate, id)
+}
+
+// updateActive runs query, which updates the loan with the given ID unless it
+// was returned, and charges fine in one transaction
+func (r *SQLiteLoanRepository) updateActive(id int, fine *models.LedgerEntry, maxFine int, query string, args ...interface{}) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = affectedOne(tx.Exec(query, args...))
+	if errors.Is(err, ErrNotFound) {
+		var loans int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM loans WHERE id = ?", id).Scan(&loans); err != nil {
+			return err
+		}
+		if loans == 0 {
+			return ErrNotFound
+		}
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	if err := chargeFine(tx, fine, maxFine); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListActiveByUser returns the loans of a user that have not been returned, oldest first
+func (r *SQLiteLoanRepository) ListActiveByUser(userID int) ([]models.Loan, error) {
+	rows, err := r.db.Query("SELECT "+loanColumns+" FROM "+loanTables+" WHERE loans.user_id = ? AND loans.return_date IS NULL ORDER BY loans.due_date", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var loans []models.Loan
+	for rows.Next() {
+		loan, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanLoan(row scanner) (models.Loan, error) {
+	var loan models.Loan
+	var returnDate sql.NullTime
+	err := row.Scan(&loan.ID, &loan.UserID, &loan.CopyID, &loan.BookID, &loan.CheckoutDate, &loan.DueDate, &returnDate, &loan.Renewals)
+	if returnDate.Valid {
+		loan.ReturnDate = &returnDate.Time
+	}
+	return loan, err
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestLoanChargesFines(t *testing.T) {
+	db := openTestDB(t)
+	loans := NewSQLiteLoanRepository(db)
+	ledger := NewSQLiteLedgerRepository(db)
+
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Role: "user"}
+	if err := NewSQLiteUserRepository(db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	book := models.Book{Title: "Dune", Author: "Frank Herbert"}
+	if err := NewSQLiteBookRepository(db).Create(&book); err != nil {
+		t.Fatal(err)
+	}
+	bookCopy := models.Copy{BookID: book.ID, Barcode: "DUNE-1", Condition: "good"}
+	if err := NewSQLiteCopyRepository(db).Create(&bookCopy); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	loan := models.Loan{UserID: user.ID, CopyID: bookCopy.ID, CheckoutDate: now.Add(-30 * 24 * time.Hour), DueDate: now.Add(-2 * 24 * time.Hour)}
+	if err := loans.Create(&loan); err != nil {
+		t.Fatal(err)
+	}
+	charge := func(amount int) *models.LedgerEntry {
+		return &models.LedgerEntry{UserID: user.ID, LoanID: loan.ID, Kind: models.LedgerCharge, Amount: amount, CreatedAt: now}
+	}
+
+	fine := charge(60)
+	if err := loans.Renew(loan.ID, now.Add(14*24*time.Hour), fine, 100); err != nil {
+		t.Fatal(err)
+	}
+	if fine.ID == 0 || fine.Amount != 60 {
+		t.Errorf("renewal charge: got %+v want 60", fine)
+	}
+
+	// Charges of one loan add up to the cap at most
+	fine = charge(60)
+	if err := loans.Return(loan.ID, now, fine, 100); err != nil {
+		t.Fatal(err)
+	}
+	if fine.ID == 0 || fine.Amount != 40 {
+		t.Errorf("return charge: got %+v want 40", fine)
+	}
+
+	// Nothing is charged with a renewal that finds the loan returned
+	fine = charge(60)
+	if err := loans.Renew(loan.ID, now.Add(14*24*time.Hour), fine, 0); !errors.Is(err, ErrConflict) {
+		t.Errorf("renewal of a returned loan: got %v want %v", err, ErrConflict)
+	}
+	if err := loans.Return(9999, now, nil, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("return of a missing loan: got %v want %v", err, ErrNotFound)
+	}
+	if balance, err := ledger.Balance(user.ID); err != nil || balance != 100 {
+		t.Errorf("balance: got %d, %v want 100", balance, err)
+	}
+}
+
+func TestCheckoutFulfillsHold(t *testing.T) {
+	db := openTestDB(t)
+	loans := NewSQLiteLoanRepository(db)
+	holds := NewSQLiteHoldRepository(db)
+
+	users := NewSQLiteUserRepository(db)
+	var patrons []models.User
+	for _, email := range []string{"jane@example.com", "john@example.com", "mary@example.com"} {
+		user := models.User{Name: email, Email: email, IsActive: true, Role: "user"}
+		if err := users.Create(&user); err != nil {
+			t.Fatal(err)
+		}
+		patrons = append(patrons, user)
+	}
+	jane, john, mary := patrons[0], patrons[1], patrons[2]
+	book := models.Book{Title: "Dune", Author: "Frank Herbert"}
+	if err := NewSQLiteBookRepository(db).Create(&book); err != nil {
+		t.Fatal(err)
+	}
+	var copies []models.Copy
+	for _, barcode := range []string{"DUNE-1", "DUNE-2"} {
+		bookCopy := models.Copy{BookID: book.ID, Barcode: barcode, Condition: "good"}
+		if err := NewSQLiteCopyRepository(db).Create(&bookCopy); err != nil {
+			t.Fatal(err)
+		}
+		copies = append(copies, bookCopy)
+	}
+
+	now := time.Now().UTC()
+	for _, user := range []models.User{jane, mary} {
+		hold := models.Hold{UserID: user.ID, BookID: book.ID, CreatedAt: now}
+		if err := holds.Create(&hold); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := holds.PromoteNext(book.ID, copies[0].ID, now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	loan := func(user models.User, bookCopy models.Copy) *models.Loan {
+		return &models.Loan{UserID: user.ID, CopyID: bookCopy.ID, BookID: book.ID, CheckoutDate: now, DueDate: now.Add(time.Hour)}
+	}
+	if _, err := loans.Checkout(loan(john, copies[0]), now.Add(time.Hour)); !errors.Is(err, ErrReserved) {
+		t.Errorf("checkout of a copy set aside for another user: got %v want %v", err, ErrReserved)
+	}
+
+	// Jane borrows the other copy, so the one set aside for her passes to Mary
+	janes := loan(jane, copies[1])
+	fulfilled, err := loans.Checkout(janes, now.Add(time.Hour))
+	if err != nil || janes.ID == 0 || fulfilled == nil || fulfilled.UserID != jane.ID || fulfilled.Status != models.HoldReady {
+		t.Fatalf("checkout by the hold owner: got %+v, %+v, %v", janes, fulfilled, err)
+	}
+	if hold, err := holds.Get(fulfilled.ID); err != nil || hold.Status != models.HoldFulfilled {
+		t.Errorf("fulfilled hold: got %+v, %v", hold, err)
+	}
+	if hold, err := holds.GetReadyForCopy(copies[0].ID); err != nil || hold.UserID != mary.ID {
+		t.Errorf("released copy: got %+v, %v want it ready for mary", hold, err)
+	}
+
+	if _, err := loans.Checkout(loan(mary, copies[1]), now.Add(time.Hour)); !errors.Is(err, ErrConflict) {
+		t.Errorf("checkout of a copy on loan: got %v want %v", err, ErrConflict)
+	}
+	if hold, err := holds.GetOpenByUserAndBook(mary.ID, book.ID); err != nil || hold.Status != models.HoldReady {
+		t.Errorf("a refused checkout should leave the hold open: got %+v, %v", hold, err)
+	}
+	if fulfilled, err := loans.Checkout(loan(john, copies[1]), now.Add(time.Hour)); !errors.Is(err, ErrConflict) || fulfilled != nil {
+		t.Errorf("second checkout: got %+v, %v want %v", fulfilled, err, ErrConflict)
+	}
+}
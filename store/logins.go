@@ -0,0 +1,114 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang_project/models"
+)
+
+// SQLiteLoginAttemptRepository is a LoginAttemptRepository backed by a SQLite database
+type SQLiteLoginAttemptRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteLoginAttemptRepository returns a LoginAttemptRepository using db
+func NewSQLiteLoginAttemptRepository(db *sql.DB) *SQLiteLoginAttemptRepository {
+	return &SQLiteLoginAttemptRepository{db: db}
+}
+
+// GetCounter returns the failed login counter stored under key
+func (r *SQLiteLoginAttemptRepository) GetCounter(key string) (models.LoginCounter, error) {
+	var counter models.LoginCounter
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRow("SELECT key, failures, last_failure_at, locked_until FROM login_counters WHERE key = ?", key).
+		Scan(&counter.Key, &counter.Failures, &counter.LastFailureAt, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return counter, ErrNotFound
+	}
+	if lockedUntil.Valid {
+		counter.LockedUntil = &lockedUntil.Time
+	}
+	return counter, err
+}
+
+// AddFailure counts a failed login at at under key in a single statement, so
+// that concurrent failures are all counted, and returns the updated counter.
+// Failures are forgotten first when the last one came before staleBefore
+// without a lockout, or when their lockout has ended by at. Once the count
+// reaches max, unless max is 0, the counter is locked until lockUntil.
+func (r *SQLiteLoginAttemptRepository) AddFailure(key string, at, staleBefore time.Time, max int, lockUntil time.Time) (models.LoginCounter, error) {
+	const stale = "((locked_until IS NOT NULL AND locked_until <= ?2) OR (locked_until IS NULL AND last_failure_at < ?3))"
+	const failures = "CASE WHEN " + stale + " THEN 1 ELSE failures + 1 END"
+	var counter models.LoginCounter
+	var lockedUntil sql.NullTime
+	err := r.db.QueryRow(`INSERT INTO login_counters(key, failures, last_failure_at, locked_until)
+		VALUES(?1, 1, ?2, CASE WHEN ?4 > 0 AND ?4 <= 1 THEN ?5 END)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = `+failures+`,
+			last_failure_at = excluded.last_failure_at,
+			locked_until = CASE WHEN ?4 > 0 AND `+failures+` >= ?4 THEN ?5 WHEN `+stale+` THEN NULL ELSE locked_until END
+		RETURNING key, failures, last_failure_at, locked_until`,
+		key, at, staleBefore, max, lockUntil).Scan(&counter.Key, &counter.Failures, &counter.LastFailureAt, &lockedUntil)
+	if lockedUntil.Valid {
+		counter.LockedUntil = &lockedUntil.Time
+	}
+	return counter, err
+}
+
+// ResetCounter forgets the failures counted under key. It returns ErrNotFound
+// when there are none.
+func (r *SQLiteLoginAttemptRepository) ResetCounter(key string) error {
+	res, err := r.db.Exec("DELETE FROM login_counters WHERE key = ?", key)
+	return affectedOne(res, err)
+}
+
+// RecordFailure appends failure to the record of failed logins and sets its ID
+func (r *SQLiteLoginAttemptRepository) RecordFailure(failure *models.LoginFailure) error {
+	res, err := r.db.Exec("INSERT INTO login_failures(email, user_id, ip, reason, request_id, created_at) VALUES(?, ?, ?, ?, ?, ?)",
+		failure.Email, failure.UserID, failure.IP, failure.Reason, failure.RequestID, failure.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	failure.ID = int(id)
+	return nil
+}
+
+// ListFailures returns up to limit failed logins, most recent first, limited
+// to those for email unless it is empty
+func (r *SQLiteLoginAttemptRepository) ListFailures(email string, limit int) ([]models.LoginFailure, error) {
+	query := "SELECT id, email, user_id, ip, reason, request_id, created_at FROM login_failures"
+	var args []interface{}
+	if email != "" {
+		query += " WHERE email = ?"
+		args = append(args, email)
+	}
+	query += " ORDER BY created_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var failures []models.LoginFailure
+	for rows.Next() {
+		var failure models.LoginFailure
+		var userID sql.NullInt64
+		if err := rows.Scan(&failure.ID, &failure.Email, &userID, &failure.IP, &failure.Reason, &failure.RequestID, &failure.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			failure.UserID = &id
+		}
+		failures = append(failures, failure)
+	}
+	return failures, rows.Err()
+}
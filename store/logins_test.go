@@ -0,0 +1,95 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestConcurrentFailuresAreAllCounted(t *testing.T) {
+	attempts := NewSQLiteLoginAttemptRepository(openTestDB(t))
+	now := time.Now().UTC()
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := attempts.AddFailure("account:jane@example.com", now, now.Add(-time.Minute), 5, now.Add(time.Minute)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	counter, err := attempts.GetCounter("account:jane@example.com")
+	if err != nil || counter.Failures != n || counter.LockedUntil == nil {
+		t.Errorf("counter: got %+v, %v want %d failures and a lockout", counter, err, n)
+	}
+}
+
+func TestLoginAttemptRepository(t *testing.T) {
+	attempts := NewSQLiteLoginAttemptRepository(openTestDB(t))
+
+	if _, err := attempts.GetCounter("account:jane@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("no failures: got %v want %v", err, ErrNotFound)
+	}
+	now := time.Now().UTC()
+	until := now.Add(time.Minute)
+	for i := 1; i <= 3; i++ {
+		counter, err := attempts.AddFailure("account:jane@example.com", now, now.Add(-time.Minute), 3, until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counter.Failures != i || (counter.LockedUntil != nil) != (i == 3) {
+			t.Errorf("failure %d: got %+v", i, counter)
+		}
+	}
+	counter, err := attempts.GetCounter("account:jane@example.com")
+	if err != nil || counter.Failures != 3 || counter.LockedUntil == nil || !counter.LockedUntil.Equal(until) {
+		t.Errorf("locked counter: got %+v, %v", counter, err)
+	}
+	later := until.Add(time.Second)
+	if counter, err := attempts.AddFailure("account:jane@example.com", later, later.Add(-time.Minute), 3, later.Add(time.Minute)); err != nil || counter.Failures != 1 || counter.LockedUntil != nil {
+		t.Errorf("failure after the lockout: got %+v, %v want a fresh count", counter, err)
+	}
+	muchLater := later.Add(2 * time.Minute)
+	if counter, err := attempts.AddFailure("account:jane@example.com", muchLater, muchLater.Add(-time.Minute), 3, muchLater.Add(time.Minute)); err != nil || counter.Failures != 1 {
+		t.Errorf("failure after a quiet period: got %+v, %v want a fresh count", counter, err)
+	}
+	if counter, err := attempts.AddFailure("ip:10.0.0.1", now, now.Add(-time.Minute), 0, until); err != nil || counter.Failures != 1 || counter.LockedUntil != nil {
+		t.Errorf("failure without a limit: got %+v, %v", counter, err)
+	}
+	if err := attempts.ResetCounter("account:jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := attempts.ResetCounter("account:jane@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("reset twice: got %v want %v", err, ErrNotFound)
+	}
+
+	userID := 7
+	for i, failure := range []models.LoginFailure{
+		{Email: "jane@example.com", UserID: &userID, IP: "10.0.0.1", Reason: models.LoginWrongPassword},
+		{Email: "john@example.com", IP: "10.0.0.1", Reason: models.LoginUnknownAccount},
+		{Email: "jane@example.com", IP: "10.0.0.2", Reason: models.LoginLocked},
+	} {
+		failure.CreatedAt = now.Add(time.Duration(i) * time.Second)
+		if err := attempts.RecordFailure(&failure); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failures, err := attempts.ListFailures("jane@example.com", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2 || failures[0].Reason != models.LoginLocked || failures[1].UserID == nil || *failures[1].UserID != userID {
+		t.Errorf("failures for jane: got %+v", failures)
+	}
+	if failures, err := attempts.ListFailures("", 2); err != nil || len(failures) != 2 {
+		t.Errorf("latest failures: got %d, %v want 2", len(failures), err)
+	}
+}
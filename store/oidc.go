@@ -0,0 +1,69 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang_project/models"
+)
+
+// SQLiteOIDCRepository is an OIDCRepository backed by a SQLite database
+type SQLiteOIDCRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteOIDCRepository returns an OIDCRepository using db
+func NewSQLiteOIDCRepository(db *sql.DB) *SQLiteOIDCRepository {
+	return &SQLiteOIDCRepository{db: db}
+}
+
+// CreateLogin stores a login that was sent to the identity provider, clearing
+// out logins that expired without coming back
+func (r *SQLiteOIDCRepository) CreateLogin(login models.OIDCLogin) error {
+	if _, err := r.db.Exec("DELETE FROM oidc_logins WHERE expires_at <= ?", login.CreatedAt); err != nil {
+		return err
+	}
+	_, err := r.db.Exec("INSERT INTO oidc_logins(state_hash, code_verifier, nonce, link_user_id, created_at, expires_at) VALUES(?, ?, ?, ?, ?, ?)",
+		login.StateHash, login.CodeVerifier, login.Nonce, login.LinkUserID, login.CreatedAt, login.ExpiresAt)
+	return err
+}
+
+// ConsumeLogin removes and returns the login with the given state hash. It
+// returns ErrNotFound when the state is unknown, already used or expired at at.
+func (r *SQLiteOIDCRepository) ConsumeLogin(stateHash string, at time.Time) (models.OIDCLogin, error) {
+	var login models.OIDCLogin
+	var linkUserID sql.NullInt64
+	err := r.db.QueryRow("DELETE FROM oidc_logins WHERE state_hash = ? RETURNING state_hash, code_verifier, nonce, link_user_id, created_at, expires_at", stateHash).
+		Scan(&login.StateHash, &login.CodeVerifier, &login.Nonce, &linkUserID, &login.CreatedAt, &login.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !at.Before(login.ExpiresAt)) {
+		return models.OIDCLogin{}, ErrNotFound
+	}
+	if linkUserID.Valid {
+		id := int(linkUserID.Int64)
+		login.LinkUserID = &id
+	}
+	return login, err
+}
+
+// GetIdentity returns the link of the identity subject at issuer to an account
+func (r *SQLiteOIDCRepository) GetIdentity(issuer, subject string) (models.Identity, error) {
+	var identity models.Identity
+	err := r.db.QueryRow("SELECT issuer, subject, user_id, created_at FROM identities WHERE issuer = ? AND subject = ?", issuer, subject).
+		Scan(&identity.Issuer, &identity.Subject, &identity.UserID, &identity.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return identity, ErrNotFound
+	}
+	return identity, err
+}
+
+// LinkIdentity links an identity to an account. It returns ErrConflict when
+// the identity is already linked.
+func (r *SQLiteOIDCRepository) LinkIdentity(identity models.Identity) error {
+	_, err := r.db.Exec("INSERT INTO identities(issuer, subject, user_id, created_at) VALUES(?, ?, ?, ?)",
+		identity.Issuer, identity.Subject, identity.UserID, identity.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	return err
+}
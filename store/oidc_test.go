@@ -0,0 +1,55 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestOIDCRepository(t *testing.T) {
+	db := openTestDB(t)
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Role: "user"}
+	if err := NewSQLiteUserRepository(db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	oidc := NewSQLiteOIDCRepository(db)
+
+	now := time.Now().UTC()
+	for _, login := range []models.OIDCLogin{
+		{StateHash: "stale", CodeVerifier: "v0", Nonce: "n0", CreatedAt: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)},
+		{StateHash: "fresh", CodeVerifier: "v1", Nonce: "n1", CreatedAt: now, ExpiresAt: now.Add(time.Minute)},
+		{StateHash: "link", CodeVerifier: "v2", Nonce: "n2", LinkUserID: &user.ID, CreatedAt: now, ExpiresAt: now.Add(time.Minute)},
+	} {
+		if err := oidc.CreateLogin(login); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := oidc.ConsumeLogin("stale", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired login: got %v want %v", err, ErrNotFound)
+	}
+	if login, err := oidc.ConsumeLogin("fresh", now); err != nil || login.CodeVerifier != "v1" || login.Nonce != "n1" || login.LinkUserID != nil {
+		t.Errorf("pending login: got %+v, %v", login, err)
+	}
+	if login, err := oidc.ConsumeLogin("link", now); err != nil || login.LinkUserID == nil || *login.LinkUserID != user.ID {
+		t.Errorf("pending link: got %+v, %v want user %d", login, err, user.ID)
+	}
+	if _, err := oidc.ConsumeLogin("fresh", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("used login: got %v want %v", err, ErrNotFound)
+	}
+
+	if _, err := oidc.GetIdentity("https://idp.example", "s-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unlinked identity: got %v want %v", err, ErrNotFound)
+	}
+	identity := models.Identity{Issuer: "https://idp.example", Subject: "s-1", UserID: user.ID, CreatedAt: now}
+	if err := oidc.LinkIdentity(identity); err != nil {
+		t.Fatal(err)
+	}
+	if err := oidc.LinkIdentity(identity); !errors.Is(err, ErrConflict) {
+		t.Errorf("link twice: got %v want %v", err, ErrConflict)
+	}
+	if got, err := oidc.GetIdentity("https://idp.example", "s-1"); err != nil || got.UserID != user.ID {
+		t.Errorf("linked identity: got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"golang_project/models"
+)
+
+// SortKey orders a listing by one field
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// Page selects one page of a listing ordered by Sort. Listings are always
+// ordered by ID last so that After identifies a single position.
+type Page struct {
+	// Limit caps the number of rows returned; zero returns every row
+	Limit int
+	// Sort lists the fields to order by, most significant first
+	Sort []SortKey
+	// After holds the values returned by BookCursor for the last row of the
+	// previous page; the page starts right after that row
+	After []interface{}
+}
+
+// bookSortColumns maps the sortable JSON fields of models.Book to their columns
+var bookSortColumns = map[string]string{
+	"id":             "ID",
+	"title":          "Title",
+	"author":         "Author",
+	"isbn":           "ISBN",
+	"published_year": "PublishedYear",
+	"genre":          "Genre",
+}
+
+// IsBookSortField reports whether books can be sorted by field
+func IsBookSortField(field string) bool {
+	_, ok := bookSortColumns[field]
+	return ok
+}
+
+// BookCursor returns the position of book in a listing ordered by sort, for use as Page.After
+func BookCursor(book models.Book, sort []SortKey) []interface{} {
+	var values []interface{}
+	for _, key := range withIDKey(sort) {
+		switch key.Field {
+		case "id":
+			values = append(values, book.ID)
+		case "title":
+			values = append(values, book.Title)
+		case "author":
+			values = append(values, book.Author)
+		case "isbn":
+			values = append(values, book.ISBN)
+		case "published_year":
+			values = append(values, book.PublishedYear)
+		case "genre":
+			values = append(values, book.Genre)
+		}
+	}
+	return values
+}
+
+// withIDKey appends the ID tie-breaker unless sort already orders by ID
+func withIDKey(sort []SortKey) []SortKey {
+	for _, key := range sort {
+		if key.Field == "id" {
+			return sort
+		}
+	}
+	return append(sort[:len(sort):len(sort)], SortKey{Field: "id"})
+}
+
+// clauses returns the keyset condition, ORDER BY and LIMIT clauses selecting
+// page from a listing of books
+func (page Page) clauses() (where string, orderLimit string, args []interface{}, err error) {
+	keys := withIDKey(page.Sort)
+	columns := make([]string, len(keys))
+	order := make([]string, len(keys))
+	for i, key := range keys {
+		column, ok := bookSortColumns[key.Field]
+		if !ok {
+			return "", "", nil, fmt.Errorf("store: cannot sort by %q", key.Field)
+		}
+		columns[i] = column
+		order[i] = column + " ASC"
+		if key.Desc {
+			order[i] = column + " DESC"
+		}
+	}
+
+	if page.After != nil {
+		if len(page.After) != len(keys) {
+			return "", "", nil, fmt.Errorf("store: cursor has %d values, want %d", len(page.After), len(keys))
+		}
+		// (a, b) after (x, y) is a > x OR (a = x AND b > y), with < for descending keys
+		var alternatives []string
+		for i, key := range keys {
+			var terms []string
+			for j := 0; j < i; j++ {
+				terms = append(terms, columns[j]+" = ?")
+				args = append(args, page.After[j])
+			}
+			op := " > ?"
+			if key.Desc {
+				op = " < ?"
+			}
+			terms = append(terms, columns[i]+op)
+			args = append(args, page.After[i])
+			alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+		}
+		where = "(" + strings.Join(alternatives, " OR ") + ")"
+	}
+
+	orderLimit = " ORDER BY " + strings.Join(order, ", ")
+	if page.Limit > 0 {
+		orderLimit += " LIMIT ?"
+		args = append(args, page.Limit)
+	}
+	return where, orderLimit, args, nil
+}
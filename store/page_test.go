@@ -0,0 +1,64 @@
+package store
+
+import (
+	"testing"
+
+	"golang_project/models"
+)
+
+func TestKeysetPagination(t *testing.T) {
+	books := NewSQLiteBookRepository(openTestDB(t))
+
+	seed := []models.Book{
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "1", PublishedYear: 1965, Genre: "Science Fiction"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "2", PublishedYear: 1815, Genre: "Fiction"},
+		{Title: "Beloved", Author: "Toni Morrison", ISBN: "3", PublishedYear: 1987, Genre: "Fiction"},
+		{Title: "Emma", Author: "Jane Austen", ISBN: "4", PublishedYear: 1816, Genre: "Fiction"},
+		{Title: "Neuromancer", Author: "William Gibson", ISBN: "5", PublishedYear: 1984, Genre: "Science Fiction"},
+	}
+	for i := range seed {
+		if err := books.Create(&seed[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sort := []SortKey{{Field: "genre"}, {Field: "title", Desc: true}}
+	want := []int{seed[1].ID, seed[3].ID, seed[2].ID, seed[4].ID, seed[0].ID}
+
+	var got []int
+	page := Page{Limit: 2, Sort: sort}
+	for {
+		batch, err := books.List(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, book := range batch {
+			got = append(got, book.ID)
+		}
+		if len(batch) < page.Limit {
+			break
+		}
+		page.After = BookCursor(batch[len(batch)-1], sort)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	filtered, err := books.Filter(models.Filter{Genre: "Fiction"}, Page{Limit: 1, Sort: sort, After: BookCursor(seed[3], sort)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 1 || filtered[0].ID != seed[2].ID {
+		t.Errorf("filtered page after %d: got %+v, want book %d", seed[3].ID, filtered, seed[2].ID)
+	}
+
+	if _, err := books.List(Page{Sort: []SortKey{{Field: "total_copies"}}}); err == nil {
+		t.Errorf("expected sorting by a column outside the whitelist to fail")
+	}
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"database/sql"
+
+	"golang_project/models"
+)
+
+// SQLiteRenewalPolicyRepository is a RenewalPolicyRepository backed by a SQLite database
+type SQLiteRenewalPolicyRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteRenewalPolicyRepository returns a RenewalPolicyRepository using db
+func NewSQLiteRenewalPolicyRepository(db *sql.DB) *SQLiteRenewalPolicyRepository {
+	return &SQLiteRenewalPolicyRepository{db: db}
+}
+
+// Get returns the current renewal policy
+func (r *SQLiteRenewalPolicyRepository) Get() (models.RenewalPolicy, error) {
+	var policy models.RenewalPolicy
+	err := r.db.QueryRow("SELECT max_renewals, period_days, max_overdue_days, refuse_on_holds FROM renewal_policy WHERE id = 1").
+		Scan(&policy.MaxRenewals, &policy.PeriodDays, &policy.MaxOverdueDays, &policy.RefuseOnHolds)
+	return policy, err
+}
+
+// Update replaces the renewal policy
+func (r *SQLiteRenewalPolicyRepository) Update(policy models.RenewalPolicy) error {
+	_, err := r.db.Exec("UPDATE renewal_policy SET max_renewals = ?, period_days = ?, max_overdue_days = ?, refuse_on_holds = ? WHERE id = 1",
+		policy.MaxRenewals, policy.PeriodDays, policy.MaxOverdueDays, policy.RefuseOnHolds)
+	return err
+}
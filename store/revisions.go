@@ -0,0 +1,84 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"golang_project/models"
+)
+
+const revisionColumns = "book_id, revision, title, author, isbn, published_year, genre, actor_id, actor, created_at"
+
+// SQLiteBookRevisionRepository is a BookRevisionRepository backed by a SQLite database
+type SQLiteBookRevisionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteBookRevisionRepository returns a BookRevisionRepository using db
+func NewSQLiteBookRevisionRepository(db *sql.DB) *SQLiteBookRevisionRepository {
+	return &SQLiteBookRevisionRepository{db: db}
+}
+
+// insertRevision stores revision as the latest revision of its book and sets
+// its number
+func insertRevision(q rowQuerier, revision *models.BookRevision) error {
+	err := q.QueryRow(`INSERT INTO book_revisions(`+revisionColumns+`)
+		SELECT ?, IFNULL(MAX(revision), 0) + 1, ?, ?, ?, ?, ?, ?, ?, ? FROM book_revisions WHERE book_id = ?
+		RETURNING revision`,
+		revision.BookID, revision.Title, revision.Author, revision.ISBN, revision.PublishedYear, revision.Genre,
+		revision.ActorID, revision.Actor, revision.CreatedAt, revision.BookID).Scan(&revision.Revision)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+// setRevisionBook fills revision in with the metadata of book, stored under id
+func setRevisionBook(revision *models.BookRevision, book models.Book, id int) {
+	revision.BookID = id
+	revision.Title = book.Title
+	revision.Author = book.Author
+	revision.ISBN = book.ISBN
+	revision.PublishedYear = book.PublishedYear
+	revision.Genre = book.Genre
+}
+
+// List returns every revision of the book with the given ID, latest first
+func (r *SQLiteBookRevisionRepository) List(bookID int) ([]models.BookRevision, error) {
+	rows, err := r.db.Query("SELECT "+revisionColumns+" FROM book_revisions WHERE book_id = ? ORDER BY revision DESC", bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []models.BookRevision
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// Get returns the given revision of the book with the given ID
+func (r *SQLiteBookRevisionRepository) Get(bookID, revision int) (models.BookRevision, error) {
+	rev, err := scanRevision(r.db.QueryRow("SELECT "+revisionColumns+" FROM book_revisions WHERE book_id = ? AND revision = ?", bookID, revision))
+	if errors.Is(err, sql.ErrNoRows) {
+		return rev, ErrNotFound
+	}
+	return rev, err
+}
+
+func scanRevision(row scanner) (models.BookRevision, error) {
+	var revision models.BookRevision
+	var actorID sql.NullInt64
+	err := row.Scan(&revision.BookID, &revision.Revision, &revision.Title, &revision.Author, &revision.ISBN, &revision.PublishedYear, &revision.Genre,
+		&actorID, &revision.Actor, &revision.CreatedAt)
+	if actorID.Valid {
+		id := int(actorID.Int64)
+		revision.ActorID = &id
+	}
+	return revision, err
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestConcurrentUpdatesRecordRevisionsInOrder(t *testing.T) {
+	db := openTestDB(t)
+	books := NewSQLiteBookRepository(db)
+	book := models.Book{Title: "Dune", Author: "Frank Herbert"}
+	if err := books.CreateWithRevision(&book, &models.BookRevision{CreatedAt: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			update := book
+			update.Version = 0
+			update.Title = fmt.Sprintf("Dune, printing %d", i)
+			if err := books.UpdateWithRevision(update, &models.BookRevision{CreatedAt: time.Now().UTC()}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	current, err := books.Get(book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := NewSQLiteBookRevisionRepository(db).List(book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != n+1 || list[0].Title != current.Title || current.Version != n+1 {
+		t.Errorf("latest revision %+v of %d, want %q of %d", list[0], len(list), current.Title, n+1)
+	}
+}
+
+func TestBookRevisionRepository(t *testing.T) {
+	db := openTestDB(t)
+	books := NewSQLiteBookRepository(db)
+	book := models.Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", PublishedYear: 1965, Genre: "Science Fiction"}
+	now := time.Now().UTC()
+	actor := 3
+	first := models.BookRevision{ActorID: &actor, Actor: "emma@example.com", CreatedAt: now}
+	if err := books.CreateWithRevision(&book, &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Revision != 1 || first.BookID != book.ID || first.Title != "Dune" {
+		t.Errorf("first revision: got %+v", first)
+	}
+	revisions := NewSQLiteBookRevisionRepository(db)
+
+	book.Title = "Dune Messiah"
+	second := models.BookRevision{ActorID: &actor, Actor: "emma@example.com", CreatedAt: now}
+	if err := books.UpdateWithRevision(book, &second); err != nil {
+		t.Fatal(err)
+	}
+	if second.Revision != 2 {
+		t.Errorf("revision number: got %v want %v", second.Revision, 2)
+	}
+	stale := models.BookRevision{CreatedAt: now}
+	if err := books.UpdateWithRevision(book, &stale); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("update of a stale version: got %v want %v", err, ErrVersionMismatch)
+	}
+
+	list, err := revisions.List(book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Revision != 2 || list[0].Title != "Dune Messiah" || list[1].Revision != 1 {
+		t.Fatalf("list: got %+v", list)
+	}
+	if list[0].ActorID == nil || *list[0].ActorID != actor || list[0].Actor != "emma@example.com" {
+		t.Errorf("actor: got %v %q want %v %q", list[0].ActorID, list[0].Actor, actor, "emma@example.com")
+	}
+
+	if got, err := revisions.Get(book.ID, 1); err != nil || got.Title != "Dune" {
+		t.Errorf("get: got %+v, %v want Dune", got, err)
+	}
+	if _, err := revisions.Get(book.ID, 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing revision: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := books.Delete(book.ID, 0, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := books.Purge(now); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := revisions.List(book.ID); err != nil || len(list) != 0 {
+		t.Errorf("purged book: got %d revisions, %v want 0", len(list), err)
+	}
+}
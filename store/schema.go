@@ -0,0 +1,259 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations holds the schema changes in the order they are applied.
+// The index of the last applied migration is tracked in PRAGMA user_version,
+// so new entries must only ever be appended.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS Users (
+		ID INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		email TEXT UNIQUE NOT NULL,
+		membershipdate DATE NOT NULL,
+		is_active BOOLEAN NOT NULL CHECK (is_active IN (0, 1)),
+		password TEXT,
+		role TEXT
+	);
+	CREATE TABLE IF NOT EXISTS Books (
+		ID INTEGER PRIMARY KEY AUTOINCREMENT,
+		Title TEXT,
+		Author TEXT,
+		ISBN TEXT,
+		PublishedYear INTEGER,
+		Genre TEXT
+	);`,
+	`CREATE TABLE loans (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		book_id INTEGER NOT NULL REFERENCES Books(ID) ON DELETE CASCADE,
+		checkout_date DATETIME NOT NULL,
+		due_date DATETIME NOT NULL,
+		return_date DATETIME
+	);
+	CREATE INDEX loans_user ON loans(user_id);
+	CREATE UNIQUE INDEX loans_active_book ON loans(book_id) WHERE return_date IS NULL;`,
+	// Every existing title becomes a single legacy copy so that it stays
+	// lendable, and loans are moved from titles onto those copies.
+	`CREATE TABLE copies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id INTEGER NOT NULL REFERENCES Books(ID) ON DELETE CASCADE,
+		barcode TEXT NOT NULL UNIQUE,
+		condition TEXT NOT NULL DEFAULT 'good',
+		shelf_location TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX copies_book ON copies(book_id);
+	INSERT INTO copies(book_id, barcode) SELECT ID, 'LEGACY-' || ID FROM Books;
+	CREATE TABLE loans_by_copy (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		copy_id INTEGER NOT NULL REFERENCES copies(id) ON DELETE CASCADE,
+		checkout_date DATETIME NOT NULL,
+		due_date DATETIME NOT NULL,
+		return_date DATETIME
+	);
+	INSERT INTO loans_by_copy(id, user_id, copy_id, checkout_date, due_date, return_date)
+		SELECT l.id, l.user_id, c.id, l.checkout_date, l.due_date, l.return_date
+		FROM loans l JOIN copies c ON c.barcode = 'LEGACY-' || l.book_id;
+	DROP TABLE loans;
+	ALTER TABLE loans_by_copy RENAME TO loans;
+	CREATE INDEX loans_user ON loans(user_id);
+	CREATE UNIQUE INDEX loans_active_copy ON loans(copy_id) WHERE return_date IS NULL;`,
+	`CREATE TABLE holds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		book_id INTEGER NOT NULL REFERENCES Books(ID) ON DELETE CASCADE,
+		copy_id INTEGER REFERENCES copies(id) ON DELETE SET NULL,
+		status TEXT NOT NULL DEFAULT 'waiting',
+		created_at DATETIME NOT NULL,
+		ready_at DATETIME,
+		expires_at DATETIME
+	);
+	CREATE INDEX holds_book_status ON holds(book_id, status);
+	CREATE UNIQUE INDEX holds_open_user_book ON holds(user_id, book_id) WHERE status IN ('waiting', 'ready');
+	CREATE UNIQUE INDEX holds_ready_copy ON holds(copy_id) WHERE status = 'ready';`,
+	`CREATE TABLE ledger_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		loan_id INTEGER REFERENCES loans(id) ON DELETE SET NULL,
+		kind TEXT NOT NULL CHECK (kind IN ('charge', 'payment', 'waiver')),
+		amount INTEGER NOT NULL CHECK (amount > 0),
+		note TEXT NOT NULL DEFAULT '',
+		recorded_by TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX ledger_entries_user ON ledger_entries(user_id);`,
+	`ALTER TABLE loans ADD COLUMN renewals INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE renewal_policy (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		max_renewals INTEGER NOT NULL,
+		period_days INTEGER NOT NULL,
+		max_overdue_days INTEGER NOT NULL,
+		refuse_on_holds BOOLEAN NOT NULL
+	);
+	INSERT INTO renewal_policy(id, max_renewals, period_days, max_overdue_days, refuse_on_holds) VALUES(1, 2, 14, 7, 1);`,
+	`CREATE TABLE sessions (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		refresh_hash TEXT NOT NULL UNIQUE,
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+	CREATE INDEX sessions_user ON sessions(user_id);`,
+	`CREATE TABLE password_resets (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME
+	);
+	CREATE INDEX password_resets_user ON password_resets(user_id);`,
+	// Accounts that existed before verification was introduced count as verified
+	`ALTER TABLE Users ADD COLUMN email_verified_at DATETIME;
+	UPDATE Users SET email_verified_at = CURRENT_TIMESTAMP;
+	CREATE TABLE email_verifications (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME
+	);
+	CREATE INDEX email_verifications_user ON email_verifications(user_id);`,
+	`CREATE TABLE two_factor (
+		user_id INTEGER PRIMARY KEY REFERENCES Users(ID) ON DELETE CASCADE,
+		secret TEXT NOT NULL,
+		confirmed_at DATETIME,
+		last_step INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE recovery_codes (
+		code_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		used_at DATETIME
+	);
+	CREATE INDEX recovery_codes_user ON recovery_codes(user_id);
+	ALTER TABLE sessions ADD COLUMN two_factor BOOLEAN NOT NULL DEFAULT 0;`,
+	`CREATE TABLE login_counters (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		last_failure_at DATETIME NOT NULL,
+		locked_until DATETIME
+	);
+	CREATE TABLE login_failures (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		email TEXT NOT NULL,
+		user_id INTEGER,
+		ip TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		request_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX login_failures_email ON login_failures(email, created_at);`,
+	`CREATE TABLE api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL UNIQUE,
+		key_hash TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		created_by INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		revoked_at DATETIME
+	);`,
+	`CREATE TABLE oidc_logins (
+		state_hash TEXT PRIMARY KEY,
+		code_verifier TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+	CREATE TABLE identities (
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES Users(ID) ON DELETE CASCADE,
+		created_at DATETIME NOT NULL,
+		UNIQUE (issuer, subject)
+	);`,
+	`CREATE TABLE audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		actor_id INTEGER,
+		actor TEXT NOT NULL DEFAULT '',
+		api_key_id INTEGER,
+		action TEXT NOT NULL,
+		entity TEXT NOT NULL,
+		entity_id INTEGER NOT NULL,
+		changes TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		request_id TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX audit_log_entity ON audit_log(entity, entity_id);
+	CREATE INDEX audit_log_actor ON audit_log(actor_id);
+	CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+	BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;
+	CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+	BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END;`,
+	`ALTER TABLE Books ADD COLUMN deleted_at DATETIME;
+	ALTER TABLE Users ADD COLUMN deleted_at DATETIME;
+	CREATE INDEX books_deleted ON Books(deleted_at) WHERE deleted_at IS NOT NULL;
+	CREATE INDEX users_deleted ON Users(deleted_at) WHERE deleted_at IS NOT NULL;`,
+	// The current state of every existing book becomes its first revision
+	`CREATE TABLE book_revisions (
+		book_id INTEGER NOT NULL REFERENCES Books(ID) ON DELETE CASCADE,
+		revision INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		author TEXT NOT NULL,
+		isbn TEXT NOT NULL,
+		published_year INTEGER NOT NULL,
+		genre TEXT NOT NULL,
+		actor_id INTEGER,
+		actor TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		UNIQUE (book_id, revision)
+	);
+	INSERT INTO book_revisions(book_id, revision, title, author, isbn, published_year, genre, created_at)
+		SELECT ID, 1, IFNULL(Title, ''), IFNULL(Author, ''), IFNULL(ISBN, ''), IFNULL(PublishedYear, 0), IFNULL(Genre, ''), CURRENT_TIMESTAMP FROM Books;`,
+	`ALTER TABLE Books ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE Users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+	`ALTER TABLE oidc_logins ADD COLUMN link_user_id INTEGER REFERENCES Users(ID) ON DELETE CASCADE;`,
+	// Refresh tokens replaced by rotation, so that replaying one is noticed
+	`CREATE TABLE rotated_refresh_tokens (
+		refresh_hash TEXT PRIMARY KEY,
+		session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE
+	);`,
+}
+
+// Migrate applies every migration the database has not seen yet and sets up
+// the full-text search index when SQLite supports it
+func Migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("store: migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return setupSearch(db)
+}
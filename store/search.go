@@ -0,0 +1,175 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang_project/models"
+)
+
+// ErrSearchUnavailable is returned by Search when SQLite was built without FTS5.
+// Build with -tags sqlite_fts5 to enable full-text search.
+var ErrSearchUnavailable = errors.New("store: full-text search requires SQLite with FTS5")
+
+// SearchColumns are the book fields covered by the full-text index
+var SearchColumns = []string{"title", "author", "genre", "isbn"}
+
+// searchIndex mirrors Books into an external-content FTS5 table. It is not a
+// numbered migration because FTS5 depends on how SQLite was built; it is set
+// up on every migration run when the module is available.
+const searchIndex = `CREATE VIRTUAL TABLE IF NOT EXISTS books_fts USING fts5(
+		title, author, genre, isbn,
+		content = 'Books', content_rowid = 'ID', prefix = '2 3'
+	);
+	CREATE TRIGGER IF NOT EXISTS books_fts_insert AFTER INSERT ON Books BEGIN
+		INSERT INTO books_fts(rowid, title, author, genre, isbn)
+		VALUES (new.ID, new.Title, new.Author, new.Genre, new.ISBN);
+	END;
+	CREATE TRIGGER IF NOT EXISTS books_fts_delete AFTER DELETE ON Books BEGIN
+		INSERT INTO books_fts(books_fts, rowid, title, author, genre, isbn)
+		VALUES ('delete', old.ID, old.Title, old.Author, old.Genre, old.ISBN);
+	END;
+	CREATE TRIGGER IF NOT EXISTS books_fts_update AFTER UPDATE ON Books BEGIN
+		INSERT INTO books_fts(books_fts, rowid, title, author, genre, isbn)
+		VALUES ('delete', old.ID, old.Title, old.Author, old.Genre, old.ISBN);
+		INSERT INTO books_fts(rowid, title, author, genre, isbn)
+		VALUES (new.ID, new.Title, new.Author, new.Genre, new.ISBN);
+	END;`
+
+// searchWeights ranks title matches above author, genre and ISBN matches
+const searchWeights = "10.0, 5.0, 2.0, 1.0"
+
+func searchAvailable(db *sql.DB) (bool, error) {
+	var enabled bool
+	err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled)
+	return enabled, err
+}
+
+// setupSearch creates the search index and its sync triggers, rebuilding the
+// index when the triggers were missing. Without FTS5 the triggers are dropped
+// so that writes to Books keep working on a database indexed by another build.
+func setupSearch(db *sql.DB) error {
+	enabled, err := searchAvailable(db)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		_, err := db.Exec(`DROP TRIGGER IF EXISTS books_fts_insert;
+			DROP TRIGGER IF EXISTS books_fts_delete;
+			DROP TRIGGER IF EXISTS books_fts_update;`)
+		return err
+	}
+
+	var triggers int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'books_fts_%'").Scan(&triggers)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(searchIndex); err != nil {
+		return fmt.Errorf("store: search index: %w", err)
+	}
+	if triggers < 3 {
+		_, err = db.Exec("INSERT INTO books_fts(books_fts) VALUES ('rebuild')")
+	}
+	return err
+}
+
+// SearchAvailable reports whether Search can be used, which needs SQLite
+// built with FTS5
+func (r *SQLiteBookRepository) SearchAvailable() (bool, error) {
+	return searchAvailable(r.db)
+}
+
+// SearchCursor returns the position of result in search results, for use as Page.After
+func SearchCursor(result models.SearchResult) []interface{} {
+	return []interface{}{result.Score, result.ID}
+}
+
+// Search returns the page of books matching every word of query, best match
+// first and then by ID. Each word also matches longer words it is a prefix
+// of. When column is one of SearchColumns only that field is searched. The
+// Sort of page is ignored, and its After holds what SearchCursor returned for
+// the last result of the previous page.
+func (r *SQLiteBookRepository) Search(query, column string, page Page) ([]models.SearchResult, error) {
+	enabled, err := searchAvailable(r.db)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrSearchUnavailable
+	}
+
+	match := matchExpression(query)
+	if match == "" {
+		return nil, nil
+	}
+	if column != "" {
+		if !isSearchColumn(column) {
+			return nil, fmt.Errorf("store: unknown search column %q", column)
+		}
+		match = column + " : (" + match + ")"
+	}
+
+	where := ""
+	args := []interface{}{match}
+	if page.After != nil {
+		if len(page.After) != 2 {
+			return nil, fmt.Errorf("store: cursor has %d values, want 2", len(page.After))
+		}
+		where = " WHERE s.score < ? OR (s.score = ? AND books.ID > ?)"
+		args = append(args, page.After[0], page.After[0], page.After[1])
+	}
+	limit := ""
+	if page.Limit > 0 {
+		limit = " LIMIT ?"
+		args = append(args, page.Limit)
+	}
+
+	rows, err := r.db.Query(`SELECT `+bookColumns+`, s.score, s.snippet FROM books JOIN (
+			SELECT rowid, -bm25(books_fts, `+searchWeights+`) AS score,
+				snippet(books_fts, -1, '<mark>', '</mark>', '…', 12) AS snippet
+			FROM books_fts WHERE books_fts MATCH ?
+				AND rowid NOT IN (SELECT ID FROM Books WHERE deleted_at IS NOT NULL)
+		) s ON s.rowid = books.ID`+where+`
+		ORDER BY s.score DESC, books.ID`+limit, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var result models.SearchResult
+		result.Book, err = scanBook(rows, &result.Score, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// matchExpression turns free text into an FTS5 query that requires every word
+// as a prefix. Words are quoted so user input cannot inject FTS5 operators.
+func matchExpression(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+func isSearchColumn(column string) bool {
+	for _, c := range SearchColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
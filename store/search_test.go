@@ -0,0 +1,198 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+// openSearchDB opens a test database, skipping the test when SQLite was built without FTS5
+func openSearchDB(t *testing.T) *sql.DB {
+	db := openTestDB(t)
+	enabled, err := searchAvailable(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !enabled {
+		t.Skip("SQLite built without FTS5; run with -tags sqlite_fts5")
+	}
+	return db
+}
+
+func searchIDs(t *testing.T, books *SQLiteBookRepository, query, column string) []int {
+	results, err := books.Search(query, column, Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	return ids
+}
+
+func TestSearchIndexFollowsBooks(t *testing.T) {
+	books := NewSQLiteBookRepository(openSearchDB(t))
+
+	book := models.Book{Title: "The Great Gatsby", Author: "F. Scott Fitzgerald", ISBN: "9780743273565", PublishedYear: 1925, Genre: "Fiction"}
+	if err := books.Create(&book); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, books, "gatsby", ""); len(ids) != 1 || ids[0] != book.ID {
+		t.Errorf("after insert: got %v, want [%d]", ids, book.ID)
+	}
+
+	book.Title = "Trimalchio"
+	if err := books.Update(book); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, books, "gatsby", ""); len(ids) != 0 {
+		t.Errorf("old title still indexed after update: got %v", ids)
+	}
+	if ids := searchIDs(t, books, "trimalchio", ""); len(ids) != 1 {
+		t.Errorf("new title not indexed after update: got %v", ids)
+	}
+
+	now := time.Now().UTC()
+	if err := books.Delete(book.ID, 0, now); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, books, "trimalchio", ""); len(ids) != 0 {
+		t.Errorf("found in the trash: got %v", ids)
+	}
+	if err := books.Restore(book.ID); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, books, "trimalchio", ""); len(ids) != 1 {
+		t.Errorf("not found after restore: got %v", ids)
+	}
+
+	if err := books.Delete(book.ID, 0, now); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := books.Purge(now.Add(time.Second)); err != nil || len(ids) != 1 {
+		t.Fatalf("purge: got %v, %v want 1 book", ids, err)
+	}
+	var indexed int
+	if err := books.db.QueryRow("SELECT COUNT(*) FROM books_fts WHERE books_fts MATCH 'trimalchio'").Scan(&indexed); err != nil || indexed != 0 {
+		t.Errorf("still indexed after purge: got %d, %v", indexed, err)
+	}
+}
+
+func TestSearchRanksAndHighlights(t *testing.T) {
+	books := NewSQLiteBookRepository(openSearchDB(t))
+
+	seed := []models.Book{
+		{Title: "A Life of Fitzgerald", Author: "Jane Smith", ISBN: "1111111111", Genre: "Biography"},
+		{Title: "Tender Is the Night", Author: "F. Scott Fitzgerald", ISBN: "9780684801544", Genre: "Fiction"},
+		{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Genre: "Science Fiction"},
+	}
+	for i := range seed {
+		if err := books.Create(&seed[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := books.Search("fitz", "", Page{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].ID != seed[0].ID {
+		t.Fatalf("expected the title match to rank first, got %+v", results)
+	}
+	if !strings.Contains(results[0].Snippet, "<mark>Fitzgerald</mark>") {
+		t.Errorf("snippet %q does not highlight the match", results[0].Snippet)
+	}
+
+	if ids := searchIDs(t, books, "fitz", "author"); len(ids) != 1 || ids[0] != seed[1].ID {
+		t.Errorf("author search: got %v, want [%d]", ids, seed[1].ID)
+	}
+	if ids := searchIDs(t, books, "978044", ""); len(ids) != 1 || ids[0] != seed[2].ID {
+		t.Errorf("ISBN prefix search: got %v, want [%d]", ids, seed[2].ID)
+	}
+	if ids := searchIDs(t, books, `dune" OR "fitz`, ""); len(ids) != 0 {
+		t.Errorf("expected operators in the query to be treated as words, got %v", ids)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	books := NewSQLiteBookRepository(openSearchDB(t))
+
+	// Equal titles tie on score, so the pages also have to follow the IDs
+	titles := []string{"Dune", "Dune", "Dune Messiah", "Children of Dune", "Dune", "Heretics of Dune"}
+	for _, title := range titles {
+		if err := books.Create(&models.Book{Title: title, Author: "Frank Herbert"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := books.Search("dune", "", Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != len(titles) {
+		t.Fatalf("all results: got %d want %d", len(all), len(titles))
+	}
+
+	var paged []models.SearchResult
+	page := Page{Limit: 4}
+	for {
+		results, err := books.Search("dune", "", page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, results...)
+		if len(results) < page.Limit {
+			break
+		}
+		page.After = SearchCursor(results[len(results)-1])
+	}
+	if len(paged) != len(all) {
+		t.Fatalf("paged results: got %d want %d", len(paged), len(all))
+	}
+	for i := range all {
+		if paged[i].ID != all[i].ID {
+			t.Errorf("result %d: got book %d want %d", i, paged[i].ID, all[i].ID)
+		}
+	}
+}
+
+func TestSearchIndexRebuiltForExistingBooks(t *testing.T) {
+	db := openSearchDB(t)
+	books := NewSQLiteBookRepository(db)
+
+	book := models.Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", Genre: "Science Fiction"}
+	if _, err := db.Exec("DROP TRIGGER books_fts_insert"); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.Create(&book); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, books, "dune", ""); len(ids) != 1 {
+		t.Errorf("expected books written without the triggers to be indexed, got %v", ids)
+	}
+}
+
+func TestSearchWithoutFTS5(t *testing.T) {
+	db := openTestDB(t)
+	enabled, err := searchAvailable(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled {
+		t.Skip("SQLite built with FTS5")
+	}
+
+	_, err = NewSQLiteBookRepository(db).Search("dune", "", Page{Limit: 10})
+	if !errors.Is(err, ErrSearchUnavailable) {
+		t.Errorf("got %v, want ErrSearchUnavailable", err)
+	}
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang_project/models"
+)
+
+const sessionColumns = "id, user_id, refresh_hash, user_agent, ip, created_at, last_used_at, expires_at, revoked_at, two_factor"
+
+// SQLiteSessionRepository is a SessionRepository backed by a SQLite database
+type SQLiteSessionRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteSessionRepository returns a SessionRepository using db
+func NewSQLiteSessionRepository(db *sql.DB) *SQLiteSessionRepository {
+	return &SQLiteSessionRepository{db: db}
+}
+
+// Create inserts session. Its ID and refresh token hash must already be set.
+func (r *SQLiteSessionRepository) Create(session *models.Session) error {
+	_, err := r.db.Exec("INSERT INTO sessions(id, user_id, refresh_hash, user_agent, ip, created_at, last_used_at, expires_at, two_factor) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		session.ID, session.UserID, session.RefreshHash, session.UserAgent, session.IP, session.CreatedAt, session.LastUsedAt, session.ExpiresAt, session.TwoFactor)
+	return err
+}
+
+// Get returns the session with the given ID, whether or not it is active
+func (r *SQLiteSessionRepository) Get(id string) (models.Session, error) {
+	return scanSession(r.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id))
+}
+
+// GetByRefreshHash returns the session whose current refresh token hashes to hash
+func (r *SQLiteSessionRepository) GetByRefreshHash(hash string) (models.Session, error) {
+	return scanSession(r.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE refresh_hash = ?", hash))
+}
+
+// Rotate replaces the refresh token of an unrevoked session and extends it,
+// remembering the old token as rotated. It returns ErrNotFound when the
+// session is missing or revoked, or its refresh token no longer hashes to
+// oldHash because a concurrent refresh rotated it first.
+func (r *SQLiteSessionRepository) Rotate(id, oldHash, refreshHash string, usedAt, expiresAt time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE sessions SET refresh_hash = ?, last_used_at = ?, expires_at = ? WHERE id = ? AND refresh_hash = ? AND revoked_at IS NULL",
+		refreshHash, usedAt, expiresAt, id, oldHash)
+	if err := affectedOne(res, err); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO rotated_refresh_tokens(refresh_hash, session_id) VALUES(?, ?)", oldHash, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeRotated ends the session a refresh token hashing to hash belonged to
+// before it was rotated. It returns ErrNotFound when no session's token was
+// ever rotated away from hash.
+func (r *SQLiteSessionRepository) RevokeRotated(hash string, at time.Time) error {
+	res, err := r.db.Exec("UPDATE sessions SET revoked_at = IFNULL(revoked_at, ?) WHERE id = (SELECT session_id FROM rotated_refresh_tokens WHERE refresh_hash = ?)", at, hash)
+	return affectedOne(res, err)
+}
+
+// Revoke ends a session. Revoking a revoked session keeps the first revocation time.
+func (r *SQLiteSessionRepository) Revoke(id string, at time.Time) error {
+	res, err := r.db.Exec("UPDATE sessions SET revoked_at = IFNULL(revoked_at, ?) WHERE id = ?", at, id)
+	return affectedOne(res, err)
+}
+
+// RevokeAllForUser ends every session of a user except the one with ID keepID, if any
+func (r *SQLiteSessionRepository) RevokeAllForUser(userID int, keepID string, at time.Time) error {
+	_, err := r.db.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id != ? AND revoked_at IS NULL", at, userID, keepID)
+	return err
+}
+
+// ListActiveByUser returns the sessions of a user that are neither revoked nor expired at now, most recently used first
+func (r *SQLiteSessionRepository) ListActiveByUser(userID int, now time.Time) ([]models.Session, error) {
+	rows, err := r.db.Query("SELECT "+sessionColumns+" FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_used_at DESC", userID, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func scanSession(row scanner) (models.Session, error) {
+	var session models.Session
+	var revokedAt sql.NullTime
+	err := row.Scan(&session.ID, &session.UserID, &session.RefreshHash, &session.UserAgent, &session.IP,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &revokedAt, &session.TwoFactor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, ErrNotFound
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return session, err
+}
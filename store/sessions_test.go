@@ -0,0 +1,84 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestSessionRepository(t *testing.T) {
+	db := openTestDB(t)
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Role: "user"}
+	if err := NewSQLiteUserRepository(db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewSQLiteSessionRepository(db)
+
+	now := time.Now().UTC()
+	for _, id := range []string{"laptop", "phone", "expired"} {
+		session := models.Session{ID: id, UserID: user.ID, RefreshHash: id + "-hash", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if id == "expired" {
+			session.ExpiresAt = now.Add(-time.Minute)
+		}
+		if err := sessions.Create(&session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sessions.Rotate("laptop", "laptop-hash", "laptop-hash-2", now.Add(time.Minute), now.Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.Rotate("laptop", "laptop-hash", "laptop-hash-3", now.Add(time.Minute), now.Add(2*time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rotate from a rotated hash: got %v want %v", err, ErrNotFound)
+	}
+	if _, err := sessions.GetByRefreshHash("laptop-hash"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("old refresh hash: got %v want %v", err, ErrNotFound)
+	}
+	if session, err := sessions.GetByRefreshHash("laptop-hash-2"); err != nil || session.ID != "laptop" {
+		t.Errorf("rotated refresh hash: got %+v, %v", session, err)
+	}
+
+	if err := sessions.Revoke("phone", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.Rotate("phone", "phone-hash", "phone-hash-2", now, now.Add(time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rotate revoked session: got %v want %v", err, ErrNotFound)
+	}
+	if err := sessions.Revoke("missing", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoke missing session: got %v want %v", err, ErrNotFound)
+	}
+
+	active, err := sessions.ListActiveByUser(user.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(active) != 1 || active[0].ID != "laptop" {
+		t.Errorf("unexpected active sessions: %+v", active)
+	}
+
+	if err := sessions.RevokeAllForUser(user.ID, "", now); err != nil {
+		t.Fatal(err)
+	}
+	if session, err := sessions.Get("laptop"); err != nil || session.RevokedAt == nil {
+		t.Errorf("expected every session to be revoked: %+v, %v", session, err)
+	}
+
+	tablet := models.Session{ID: "tablet", UserID: user.ID, RefreshHash: "tablet-hash", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := sessions.Create(&tablet); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.Rotate("tablet", "tablet-hash", "tablet-hash-2", now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions.RevokeRotated("tablet-hash-2", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("revoke by a current hash: got %v want %v", err, ErrNotFound)
+	}
+	if err := sessions.RevokeRotated("tablet-hash", now); err != nil {
+		t.Fatal(err)
+	}
+	if session, err := sessions.Get("tablet"); err != nil || session.RevokedAt == nil {
+		t.Errorf("expected the session of a rotated hash to be revoked: %+v, %v", session, err)
+	}
+}
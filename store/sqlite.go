@@ -0,0 +1,469 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"golang_project/models"
+)
+
+// bookColumns selects a book together with its copy counts. A copy is
+// available when it has no open loan and is not set aside for a hold.
+const bookColumns = `ID, Title, Author, ISBN, PublishedYear, Genre,
+	(SELECT COUNT(*) FROM copies c WHERE c.book_id = books.ID),
+	(SELECT COUNT(*) FROM copies c WHERE c.book_id = books.ID AND ` + copyAvailable + `),
+	books.deleted_at, books.version`
+
+// scanBook reads a row selected with bookColumns, followed by extra columns
+func scanBook(row scanner, extra ...interface{}) (models.Book, error) {
+	var book models.Book
+	var deletedAt sql.NullTime
+	dest := []interface{}{&book.ID, &book.Title, &book.Author, &book.ISBN, &book.PublishedYear, &book.Genre, &book.TotalCopies, &book.AvailableCopies, &deletedAt, &book.Version}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return book, err
+	}
+	if deletedAt.Valid {
+		book.DeletedAt = &deletedAt.Time
+	}
+	return book, nil
+}
+
+// SQLiteBookRepository is a BookRepository backed by a SQLite database
+type SQLiteBookRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteBookRepository returns a BookRepository using db
+func NewSQLiteBookRepository(db *sql.DB) *SQLiteBookRepository {
+	return &SQLiteBookRepository{db: db}
+}
+
+// List returns one page of the catalog
+func (r *SQLiteBookRepository) List(page Page) ([]models.Book, error) {
+	return r.Filter(models.Filter{}, page)
+}
+
+// Get returns the book with the given ID unless it is in the trash
+func (r *SQLiteBookRepository) Get(id int) (models.Book, error) {
+	return r.queryRow("SELECT "+bookColumns+" FROM books WHERE ID = ? AND deleted_at IS NULL", id)
+}
+
+// GetWithDeleted returns the book with the given ID, even if it is in the trash
+func (r *SQLiteBookRepository) GetWithDeleted(id int) (models.Book, error) {
+	return r.queryRow("SELECT "+bookColumns+" FROM books WHERE ID = ?", id)
+}
+
+// Create inserts book and sets its ID
+func (r *SQLiteBookRepository) Create(book *models.Book) error {
+	return r.CreateWithRevision(book, nil)
+}
+
+// CreateWithRevision inserts book, sets its ID and, when revision is not nil,
+// records the book's metadata as its first revision in the same transaction.
+// The actor and time of revision are kept; the rest is filled in.
+func (r *SQLiteBookRepository) CreateWithRevision(book *models.Book, revision *models.BookRevision) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO books(Title, Author, ISBN, PublishedYear, Genre) VALUES(?, ?, ?, ?, ?)",
+		book.Title, book.Author, book.ISBN, book.PublishedYear, book.Genre)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if revision != nil {
+		setRevisionBook(revision, *book, int(id))
+		if err := insertRevision(tx, revision); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	book.ID = int(id)
+	book.Version = 1
+	return nil
+}
+
+// Update overwrites the stored fields of book and moves it to its next version.
+// When book.Version is set, it returns ErrVersionMismatch unless that is the
+// current version. Books in the trash are left unchanged and give ErrNotFound.
+func (r *SQLiteBookRepository) Update(book models.Book) error {
+	return r.UpdateWithRevision(book, nil)
+}
+
+// UpdateWithRevision is Update that, when revision is not nil, also records
+// the new metadata as the book's latest revision in the same transaction, so
+// that revisions are numbered in the order the updates were made. The actor
+// and time of revision are kept; the rest is filled in.
+func (r *SQLiteBookRepository) UpdateWithRevision(book models.Book, revision *models.BookRevision) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE books SET Title = ?, Author = ?, ISBN = ?, PublishedYear = ?, Genre = ?, version = version + 1 WHERE ID = ? AND deleted_at IS NULL"
+	args := []interface{}{book.Title, book.Author, book.ISBN, book.PublishedYear, book.Genre, book.ID}
+	if book.Version != 0 {
+		query += " AND version = ?"
+		args = append(args, book.Version)
+	}
+	err = affectedOne(tx.Exec(query, args...))
+	if errors.Is(err, ErrNotFound) && book.Version != 0 {
+		return staleVersion(tx, "books", book.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if revision != nil {
+		setRevisionBook(revision, book, book.ID)
+		if err := insertRevision(tx, revision); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete moves the book with the given ID to the trash and bumps its
+// version. It returns ErrNotFound when there is no such book or it is already
+// in the trash, and ErrVersionMismatch when version is set but is not the
+// current one.
+func (r *SQLiteBookRepository) Delete(id, version int, at time.Time) error {
+	query := "UPDATE books SET deleted_at = ?, version = version + 1 WHERE ID = ? AND deleted_at IS NULL"
+	args := []interface{}{at, id}
+	if version != 0 {
+		query += " AND version = ?"
+		args = append(args, version)
+	}
+	err := affectedOne(r.db.Exec(query, args...))
+	if errors.Is(err, ErrNotFound) && version != 0 {
+		return staleVersion(r.db, "books", id)
+	}
+	return err
+}
+
+// ListDeleted returns the books in the trash, most recently deleted first
+func (r *SQLiteBookRepository) ListDeleted() ([]models.Book, error) {
+	return r.query("SELECT " + bookColumns + " FROM books WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, ID DESC")
+}
+
+// Restore takes the book with the given ID out of the trash and bumps its
+// version. It returns ErrNotFound when the book is not in the trash.
+func (r *SQLiteBookRepository) Restore(id int) error {
+	res, err := r.db.Exec("UPDATE books SET deleted_at = NULL, version = version + 1 WHERE ID = ? AND deleted_at IS NOT NULL", id)
+	return affectedOne(res, err)
+}
+
+// Purge permanently removes the books that were moved to the trash before
+// before, together with their copies, loans and holds, and returns their IDs
+func (r *SQLiteBookRepository) Purge(before time.Time) ([]int, error) {
+	return queryIDs(r.db, "DELETE FROM books WHERE deleted_at < ? RETURNING ID", before)
+}
+
+// Filter returns one page of the books matching every non-empty field of
+// filter. filter.SortOrder orders by published year when page has no sort.
+func (r *SQLiteBookRepository) Filter(filter models.Filter, page Page) ([]models.Book, error) {
+	filters := []string{"deleted_at IS NULL"}
+	var args []interface{}
+
+	if filter.Genre != "" {
+		filters = append(filters, "Genre = ?")
+		args = append(args, filter.Genre)
+	}
+	if filter.Author != "" {
+		filters = append(filters, "Author = ?")
+		args = append(args, filter.Author)
+	}
+	if filter.PublishedYear != "" {
+		filters = append(filters, "PublishedYear = ?")
+		args = append(args, filter.PublishedYear)
+	}
+	if filter.Title != "" {
+		filters = append(filters, "Title = ?")
+		args = append(args, filter.Title)
+	}
+
+	// Add sorting order based on published year
+	if len(page.Sort) == 0 && filter.SortOrder == "asc" {
+		page.Sort = []SortKey{{Field: "published_year"}}
+	} else if len(page.Sort) == 0 && filter.SortOrder == "desc" {
+		page.Sort = []SortKey{{Field: "published_year", Desc: true}}
+	}
+
+	keyset, orderLimit, pageArgs, err := page.clauses()
+	if err != nil {
+		return nil, err
+	}
+	if keyset != "" {
+		filters = append(filters, keyset)
+	}
+
+	query := "SELECT " + bookColumns + " FROM books WHERE " + strings.Join(filters, " AND ") + orderLimit
+
+	return r.query(query, append(args, pageArgs...)...)
+}
+
+func (r *SQLiteBookRepository) query(query string, args ...interface{}) ([]models.Book, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var books []models.Book
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+func (r *SQLiteBookRepository) queryRow(query string, args ...interface{}) (models.Book, error) {
+	book, err := scanBook(r.db.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return book, ErrNotFound
+	}
+	return book, err
+}
+
+const userColumns = "ID, name, email, membershipdate, is_active, IFNULL(password, ''), IFNULL(role, ''), email_verified_at, deleted_at, version"
+
+// SQLiteUserRepository is a UserRepository backed by a SQLite database
+type SQLiteUserRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteUserRepository returns a UserRepository using db
+func NewSQLiteUserRepository(db *sql.DB) *SQLiteUserRepository {
+	return &SQLiteUserRepository{db: db}
+}
+
+// Create inserts user and sets its ID. The password must already be hashed.
+// It returns ErrConflict when the email is already registered.
+func (r *SQLiteUserRepository) Create(user *models.User) error {
+	res, err := r.db.Exec("INSERT INTO Users(name, email, membershipdate, is_active, password, role, email_verified_at) VALUES(?, ?, ?, ?, ?, ?, ?)",
+		user.Name, user.Email, user.MembershipDate, user.IsActive, user.Password, user.Role, user.EmailVerifiedAt)
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	user.ID = int(id)
+	user.Version = 1
+	return nil
+}
+
+// Get returns the user with the given ID and role unless it is in the trash
+func (r *SQLiteUserRepository) Get(id int, role string) (models.User, error) {
+	query := "SELECT " + userColumns + " FROM Users WHERE ID = ? AND deleted_at IS NULL"
+	args := []interface{}{id}
+	if role != "" {
+		query += " AND role = ?"
+		args = append(args, role)
+	}
+	return r.queryRow(query, args...)
+}
+
+// GetWithDeleted returns the user with the given ID and role, even if it is in the trash
+func (r *SQLiteUserRepository) GetWithDeleted(id int, role string) (models.User, error) {
+	query := "SELECT " + userColumns + " FROM Users WHERE ID = ?"
+	args := []interface{}{id}
+	if role != "" {
+		query += " AND role = ?"
+		args = append(args, role)
+	}
+	return r.queryRow(query, args...)
+}
+
+// GetByEmail returns the user registered with email unless it is in the trash
+func (r *SQLiteUserRepository) GetByEmail(email string) (models.User, error) {
+	return r.queryRow("SELECT "+userColumns+" FROM Users WHERE email = ? AND deleted_at IS NULL", email)
+}
+
+// GetByUsername returns the user registered with username. Users do not have
+// usernames yet, so it always returns ErrNotFound.
+func (r *SQLiteUserRepository) GetByUsername(username string) (models.User, error) {
+	return models.User{}, ErrNotFound
+}
+
+// Update overwrites the profile fields of user. The password and role are left
+// unchanged, and so are users in the trash. It returns ErrConflict when the new
+// email belongs to another user.
+func (r *SQLiteUserRepository) Update(user models.User, role string) error {
+	query := "UPDATE Users SET name = ?, email = ?, membershipdate = ?, is_active = ?, version = version + 1 WHERE ID = ? AND deleted_at IS NULL"
+	args := []interface{}{user.Name, user.Email, user.MembershipDate, user.IsActive, user.ID}
+	if role != "" {
+		query += " AND role = ?"
+		args = append(args, role)
+	}
+	if user.Version != 0 {
+		query += " AND version = ?"
+		args = append(args, user.Version)
+	}
+	err := affectedOne(r.db.Exec(query, args...))
+	if isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if errors.Is(err, ErrNotFound) && user.Version != 0 {
+		return staleVersion(r.db, "Users", user.ID)
+	}
+	return err
+}
+
+// SetPassword replaces the password hash of the user with the given ID
+func (r *SQLiteUserRepository) SetPassword(id int, hash string) error {
+	res, err := r.db.Exec("UPDATE Users SET password = ? WHERE ID = ?", hash, id)
+	return affectedOne(res, err)
+}
+
+// MarkEmailVerified records that the user with the given ID confirmed their
+// email address and activates the account
+func (r *SQLiteUserRepository) MarkEmailVerified(id int, at time.Time) error {
+	res, err := r.db.Exec("UPDATE Users SET email_verified_at = IFNULL(email_verified_at, ?), is_active = 1, version = version + 1 WHERE ID = ?", at, id)
+	return affectedOne(res, err)
+}
+
+// Delete moves the user with the given ID and role to the trash, bumps their
+// version and revokes their sessions. It returns ErrNotFound when there is no such user or it is
+// already in the trash, and ErrVersionMismatch when version is set but is
+// not the current one.
+func (r *SQLiteUserRepository) Delete(id int, role string, version int, at time.Time) error {
+	query := "UPDATE Users SET deleted_at = ?, version = version + 1 WHERE ID = ? AND deleted_at IS NULL"
+	args := []interface{}{at, id}
+	if role != "" {
+		query += " AND role = ?"
+		args = append(args, role)
+	}
+	if version != 0 {
+		query += " AND version = ?"
+		args = append(args, version)
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := affectedOne(tx.Exec(query, args...)); err != nil {
+		if errors.Is(err, ErrNotFound) && version != 0 {
+			return staleVersion(tx, "Users", id)
+		}
+		return err
+	}
+	if _, err := tx.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", at, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListDeleted returns the users of the given role in the trash, most recently
+// deleted first
+func (r *SQLiteUserRepository) ListDeleted(role string) ([]models.User, error) {
+	query := "SELECT " + userColumns + " FROM Users WHERE deleted_at IS NOT NULL"
+	var args []interface{}
+	if role != "" {
+		query += " AND role = ?"
+		args = append(args, role)
+	}
+	query += " ORDER BY deleted_at DESC, ID DESC"
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// Restore takes the user with the given ID and role out of the trash and
+// bumps their version. Their revoked sessions stay revoked. It returns ErrNotFound when the user is not
+// in the trash.
+func (r *SQLiteUserRepository) Restore(id int, role string) error {
+	query := "UPDATE Users SET deleted_at = NULL, version = version + 1 WHERE ID = ? AND deleted_at IS NOT NULL"
+	args := []interface{}{id}
+	if role != "" {
+		query += " AND role = ?"
+		args = append(args, role)
+	}
+	res, err := r.db.Exec(query, args...)
+	return affectedOne(res, err)
+}
+
+// Purge permanently removes the users that were moved to the trash before
+// before, together with their loans, holds, fines and sessions, and returns
+// their IDs
+func (r *SQLiteUserRepository) Purge(before time.Time) ([]int, error) {
+	return queryIDs(r.db, "DELETE FROM Users WHERE deleted_at < ? RETURNING ID", before)
+}
+
+// queryIDs runs a statement returning a column of IDs, in ascending order
+func queryIDs(db *sql.DB, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, rows.Err()
+}
+
+func (r *SQLiteUserRepository) queryRow(query string, args ...interface{}) (models.User, error) {
+	user, err := scanUser(r.db.QueryRow(query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return user, ErrNotFound
+	}
+	return user, err
+}
+
+// scanUser reads a row selected with userColumns
+func scanUser(row scanner) (models.User, error) {
+	var user models.User
+	var verifiedAt, deletedAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.MembershipDate, &user.IsActive, &user.Password, &user.Role, &verifiedAt, &deletedAt, &user.Version)
+	if err != nil {
+		return user, err
+	}
+	if verifiedAt.Valid {
+		user.EmailVerifiedAt = &verifiedAt.Time
+	}
+	if deletedAt.Valid {
+		user.DeletedAt = &deletedAt.Time
+	}
+	return user, nil
+}
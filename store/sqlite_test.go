@@ -0,0 +1,361 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Migrate(db); err != nil {
+		t.Fatalf("second migration run failed: %v", err)
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+}
+
+func TestBookRepository(t *testing.T) {
+	books := NewSQLiteBookRepository(openTestDB(t))
+
+	book := models.Book{Title: "Dune", Author: "Frank Herbert", ISBN: "9780441013593", PublishedYear: 1965, Genre: "Science Fiction"}
+	if err := books.Create(&book); err != nil {
+		t.Fatal(err)
+	}
+	if book.ID == 0 {
+		t.Fatal("Create did not set the book ID")
+	}
+
+	got, err := books.Get(book.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != book {
+		t.Errorf("Get returned %+v, want %+v", got, book)
+	}
+
+	filtered, err := books.Filter(models.Filter{Author: "Frank Herbert", PublishedYear: "1965"}, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 1 || filtered[0].ID != book.ID {
+		t.Errorf("Filter returned %+v, want only book %d", filtered, book.ID)
+	}
+
+	if err := books.Delete(book.ID, 0, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := books.Get(book.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestUserRepositoryRole(t *testing.T) {
+	users := NewSQLiteUserRepository(openTestDB(t))
+
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Password: "hash", Role: "user"}
+	if err := users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := users.Get(user.ID, "user"); err != nil {
+		t.Errorf("Get with matching role failed: %v", err)
+	}
+	if _, err := users.Get(user.ID, "admin"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get with other role returned %v, want ErrNotFound", err)
+	}
+
+	got, err := users.GetByEmail("jane.doe@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != user.ID || got.Password != "hash" {
+		t.Errorf("GetByEmail returned %+v", got)
+	}
+}
+
+func TestMigrateMovesLoansOntoLegacyCopies(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Bring the database to the schema that tracked loans per title
+	for i, migration := range migrations[:2] {
+		if _, err := db.Exec(migration); err != nil {
+			t.Fatalf("migration %d: %v", i+1, err)
+		}
+	}
+	_, err = db.Exec(`PRAGMA user_version = 2;
+		INSERT INTO Users(name, email, membershipdate, is_active) VALUES('Jane Doe', 'jane.doe@example.com', '2023-10-01', 1);
+		INSERT INTO Books(Title, Author, ISBN, PublishedYear, Genre) VALUES('Dune', 'Frank Herbert', '', 1965, ''), ('Emma', 'Jane Austen', '', 1815, '');
+		INSERT INTO loans(user_id, book_id, checkout_date, due_date) VALUES(1, 2, '2024-01-01 00:00:00', '2024-01-15 00:00:00');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	loans, err := NewSQLiteLoanRepository(db).ListActiveByUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loans) != 1 || loans[0].BookID != 2 {
+		t.Fatalf("loans after migration: %+v", loans)
+	}
+
+	books, err := NewSQLiteBookRepository(db).List(Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, book := range books {
+		wantAvailable := 1
+		if book.ID == 2 {
+			wantAvailable = 0
+		}
+		if book.TotalCopies != 1 || book.AvailableCopies != wantAvailable {
+			t.Errorf("book %d has %d/%d copies available, want %d/1",
+				book.ID, book.AvailableCopies, book.TotalCopies, wantAvailable)
+		}
+	}
+}
+
+func TestMigrateKeepsExistingUsersVerified(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Bring the database to the schema before email verification
+	for i, migration := range migrations[:8] {
+		if _, err := db.Exec(migration); err != nil {
+			t.Fatalf("migration %d: %v", i+1, err)
+		}
+	}
+	_, err = db.Exec(`PRAGMA user_version = 8;
+		INSERT INTO Users(name, email, membershipdate, is_active) VALUES('Jane Doe', 'jane.doe@example.com', '2023-10-01', 1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	users := NewSQLiteUserRepository(db)
+	existing, err := users.GetByEmail("jane.doe@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing.EmailVerifiedAt == nil {
+		t.Error("expected accounts from before verification to count as verified")
+	}
+
+	registered := models.User{Name: "John Doe", Email: "john.doe@example.com", Role: "user"}
+	if err := users.Create(&registered); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.MarkEmailVerified(registered.ID, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := users.Get(registered.ID, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EmailVerifiedAt == nil || !got.IsActive {
+		t.Errorf("expected a verified, active account: %+v", got)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	db := openTestDB(t)
+	books := NewSQLiteBookRepository(db)
+	users := NewSQLiteUserRepository(db)
+
+	dune := models.Book{Title: "Dune", Author: "Frank Herbert"}
+	emma := models.Book{Title: "Emma", Author: "Jane Austen"}
+	for _, book := range []*models.Book{&dune, &emma} {
+		if err := books.Create(book); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deletedAt := time.Now().UTC().Add(-48 * time.Hour)
+	if err := books.Delete(dune.ID, 0, deletedAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.Delete(dune.ID, 0, deletedAt); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete twice: got %v want %v", err, ErrNotFound)
+	}
+	if listed, err := books.List(Page{}); err != nil || len(listed) != 1 || listed[0].ID != emma.ID {
+		t.Errorf("list: got %+v, %v want only book %d", listed, err, emma.ID)
+	}
+	if filtered, err := books.Filter(models.Filter{Author: "Frank Herbert"}, Page{}); err != nil || len(filtered) != 0 {
+		t.Errorf("filter: got %+v, %v want nothing", filtered, err)
+	}
+	if trashed, err := books.GetWithDeleted(dune.ID); err != nil || trashed.DeletedAt == nil || !trashed.DeletedAt.Equal(deletedAt) {
+		t.Errorf("get with deleted: got %+v, %v", trashed, err)
+	}
+	if trash, err := books.ListDeleted(); err != nil || len(trash) != 1 || trash[0].ID != dune.ID {
+		t.Errorf("trash: got %+v, %v want book %d", trash, err, dune.ID)
+	}
+
+	if err := books.Restore(dune.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.Restore(dune.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restore twice: got %v want %v", err, ErrNotFound)
+	}
+	if got, err := books.Get(dune.ID); err != nil || got.DeletedAt != nil {
+		t.Errorf("get after restore: got %+v, %v", got, err)
+	}
+
+	if err := books.Delete(dune.ID, 0, deletedAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.Delete(emma.ID, 0, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := books.Purge(time.Now().UTC().Add(-24 * time.Hour)); err != nil || len(ids) != 1 || ids[0] != dune.ID {
+		t.Errorf("purge: got %v, %v want [%d]", ids, err, dune.ID)
+	}
+	if _, err := books.GetWithDeleted(dune.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get after purge: got %v want %v", err, ErrNotFound)
+	}
+	if _, err := books.GetWithDeleted(emma.ID); err != nil {
+		t.Errorf("recently deleted book purged: %v", err)
+	}
+
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Role: "user"}
+	if err := users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewSQLiteSessionRepository(db)
+	now := time.Now().UTC()
+	session := models.Session{ID: "s1", UserID: user.ID, RefreshHash: "h1", CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if err := sessions.Create(&session); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := users.Delete(user.ID, "admin", 0, now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete with another role: got %v want %v", err, ErrNotFound)
+	}
+	if err := users.Delete(user.ID, "", 0, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.GetByEmail(user.Email); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get by email in the trash: got %v want %v", err, ErrNotFound)
+	}
+	if got, err := sessions.Get(session.ID); err != nil || got.RevokedAt == nil {
+		t.Errorf("session of a deleted user: got %+v, %v", got, err)
+	}
+	if trash, err := users.ListDeleted("user"); err != nil || len(trash) != 1 || trash[0].ID != user.ID {
+		t.Errorf("user trash: got %+v, %v", trash, err)
+	}
+	if err := users.Restore(user.ID, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Get(user.ID, "user"); err != nil {
+		t.Errorf("get after restore: %v", err)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	db := openTestDB(t)
+	books := NewSQLiteBookRepository(db)
+	users := NewSQLiteUserRepository(db)
+
+	book := models.Book{Title: "Dune", Author: "Frank Herbert"}
+	if err := books.Create(&book); err != nil {
+		t.Fatal(err)
+	}
+	if book.Version != 1 {
+		t.Fatalf("created book: got version %d want 1", book.Version)
+	}
+	book.Title = "Dune Messiah"
+	if err := books.Update(book); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := books.Get(book.ID); err != nil || got.Version != 2 || got.Title != "Dune Messiah" {
+		t.Errorf("updated book: got %+v, %v want version 2", got, err)
+	}
+	if err := books.Update(book); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("update of a stale version: got %v want %v", err, ErrVersionMismatch)
+	}
+	if err := books.Delete(book.ID, 1, time.Now().UTC()); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("delete of a stale version: got %v want %v", err, ErrVersionMismatch)
+	}
+	if err := books.Delete(book.ID, 2, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	book.Version = 2
+	if err := books.Update(book); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update of a deleted book: got %v want %v", err, ErrNotFound)
+	}
+	if got, err := books.GetWithDeleted(book.ID); err != nil || got.Version != 3 {
+		t.Errorf("deleted book: got %+v, %v want version 3", got, err)
+	}
+	if err := books.Restore(book.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := books.Update(book); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("update of the version before the delete: got %v want %v", err, ErrVersionMismatch)
+	}
+	if got, err := books.Get(book.ID); err != nil || got.Version != 4 {
+		t.Errorf("restored book: got %+v, %v want version 4", got, err)
+	}
+
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Role: "user"}
+	if err := users.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.MarkEmailVerified(user.ID, time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Update(user, ""); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("update after verification: got %v want %v", err, ErrVersionMismatch)
+	}
+	user.Version = 2
+	if err := users.Update(user, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete(user.ID, "", 2, time.Now().UTC()); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("delete of a stale version: got %v want %v", err, ErrVersionMismatch)
+	}
+	if err := users.Delete(user.ID, "", 3, time.Now().UTC()); err != nil {
+		t.Fatalf("delete of the current version: %v", err)
+	}
+	if got, err := users.GetWithDeleted(user.ID, ""); err != nil || got.Version != 4 {
+		t.Errorf("deleted user: got %+v, %v want version 4", got, err)
+	}
+	if err := users.Restore(user.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := users.Get(user.ID, ""); err != nil || got.Version != 5 {
+		t.Errorf("restored user: got %+v, %v want version 5", got, err)
+	}
+}
@@ -0,0 +1,231 @@
+// Package store provides the persistence layer used by the HTTP handlers.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+
+	"golang_project/models"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// DefaultPath is the database file used when DB_PATH is not set
+const DefaultPath = "test.db"
+
+// ErrNotFound is returned when a requested record does not exist
+var ErrNotFound = errors.New("store: record not found")
+
+// ErrConflict is returned when a write violates a uniqueness rule,
+// such as checking out a copy that is already on loan
+var ErrConflict = errors.New("store: conflicting record")
+
+// ErrVersionMismatch is returned when a write guarded by a version finds the
+// record at another version, because it was changed since it was read
+var ErrVersionMismatch = errors.New("store: record changed since it was read")
+
+// ErrReserved is returned when checking out a copy that is set aside for
+// another user's hold
+var ErrReserved = errors.New("store: copy is reserved for another user")
+
+// BookRepository provides access to the book catalog. Deleted books are kept
+// in a trash, which only GetWithDeleted and ListDeleted look into, until they
+// are restored or purged. Every update moves a book to its next version; a
+// non-zero version passed to Update or Delete must be the current one.
+type BookRepository interface {
+	List(page Page) ([]models.Book, error)
+	Get(id int) (models.Book, error)
+	GetWithDeleted(id int) (models.Book, error)
+	Create(book *models.Book) error
+	CreateWithRevision(book *models.Book, revision *models.BookRevision) error
+	Update(book models.Book) error
+	UpdateWithRevision(book models.Book, revision *models.BookRevision) error
+	Delete(id, version int, at time.Time) error
+	ListDeleted() ([]models.Book, error)
+	Restore(id int) error
+	Purge(before time.Time) ([]int, error)
+	Filter(filter models.Filter, page Page) ([]models.Book, error)
+	SearchAvailable() (bool, error)
+	Search(query, column string, page Page) ([]models.SearchResult, error)
+}
+
+// UserRepository provides access to users and bookkeepers.
+// An empty role matches users of any role. Deleted users are kept in a trash
+// like books, and versioned like them.
+type UserRepository interface {
+	Create(user *models.User) error
+	Get(id int, role string) (models.User, error)
+	GetWithDeleted(id int, role string) (models.User, error)
+	GetByEmail(email string) (models.User, error)
+	GetByUsername(username string) (models.User, error)
+	Update(user models.User, role string) error
+	SetPassword(id int, hash string) error
+	MarkEmailVerified(id int, at time.Time) error
+	Delete(id int, role string, version int, at time.Time) error
+	ListDeleted(role string) ([]models.User, error)
+	Restore(id int, role string) error
+	Purge(before time.Time) ([]int, error)
+}
+
+// CopyRepository provides access to the physical copies of books
+type CopyRepository interface {
+	ListByBook(bookID int) ([]models.Copy, error)
+	Get(id int) (models.Copy, error)
+	Create(c *models.Copy) error
+	Update(c models.Copy) error
+	Delete(id int) error
+}
+
+// LoanRepository provides access to book loans
+type LoanRepository interface {
+	Get(id int) (models.Loan, error)
+	Create(loan *models.Loan) error
+	Checkout(loan *models.Loan, pickupUntil time.Time) (*models.Hold, error)
+	Return(id int, returnedAt time.Time, fine *models.LedgerEntry, maxFine int) error
+	Renew(id int, dueDate time.Time, fine *models.LedgerEntry, maxFine int) error
+	ListActiveByUser(userID int) ([]models.Loan, error)
+}
+
+// RenewalPolicyRepository provides access to the loan renewal policy
+type RenewalPolicyRepository interface {
+	Get() (models.RenewalPolicy, error)
+	Update(policy models.RenewalPolicy) error
+}
+
+// HoldRepository provides access to the hold queues of books
+type HoldRepository interface {
+	Get(id int) (models.Hold, error)
+	Create(hold *models.Hold) error
+	ListOpenByBook(bookID int) ([]models.Hold, error)
+	ListOpenByUser(userID int) ([]models.Hold, error)
+	GetOpenByUserAndBook(userID, bookID int) (models.Hold, error)
+	GetReadyForCopy(copyID int) (models.Hold, error)
+	PromoteNext(bookID, copyID int, readyAt, expiresAt time.Time) (bool, error)
+	Close(id int, status string) error
+	ExpireReady(now time.Time) ([]models.Hold, error)
+}
+
+// LedgerRepository provides access to the fine ledger of each user.
+// Amounts are in cents; the balance is charges minus payments and waivers.
+type LedgerRepository interface {
+	Create(entry *models.LedgerEntry) error
+	Credit(entry *models.LedgerEntry) error
+	ListByUser(userID int) ([]models.LedgerEntry, error)
+	Balance(userID int) (int, error)
+}
+
+// SessionRepository provides access to login sessions. A session is active
+// until it is revoked or its refresh token expires.
+type SessionRepository interface {
+	Create(session *models.Session) error
+	Get(id string) (models.Session, error)
+	GetByRefreshHash(hash string) (models.Session, error)
+	Rotate(id, oldHash, refreshHash string, usedAt, expiresAt time.Time) error
+	RevokeRotated(hash string, at time.Time) error
+	Revoke(id string, at time.Time) error
+	RevokeAllForUser(userID int, keepID string, at time.Time) error
+	ListActiveByUser(userID int, now time.Time) ([]models.Session, error)
+}
+
+// OneTimeTokenRepository provides access to tokens mailed to account holders,
+// such as password reset and email verification tokens. They are stored
+// hashed and can be used once before they expire.
+type OneTimeTokenRepository interface {
+	Create(token *models.OneTimeToken) error
+	Consume(tokenHash string, at time.Time) (int, error)
+}
+
+// TwoFactorRepository provides access to TOTP secrets and recovery codes.
+// Recovery codes are stored hashed and can each be used once.
+type TwoFactorRepository interface {
+	Get(userID int) (models.TwoFactor, error)
+	Begin(userID int, secret string) error
+	Confirm(userID int, step int64, at time.Time, recoveryHashes []string) error
+	UseStep(userID int, step int64) error
+	UseRecoveryCode(userID int, hash string, at time.Time) error
+	Delete(userID int) error
+}
+
+// LoginAttemptRepository provides access to the counters login throttling is
+// based on, keyed by account or IP address, and to the record of failed logins
+type LoginAttemptRepository interface {
+	GetCounter(key string) (models.LoginCounter, error)
+	AddFailure(key string, at, staleBefore time.Time, max int, lockUntil time.Time) (models.LoginCounter, error)
+	ResetCounter(key string) error
+	RecordFailure(failure *models.LoginFailure) error
+	ListFailures(email string, limit int) ([]models.LoginFailure, error)
+}
+
+// APIKeyRepository provides access to API keys. Keys are stored hashed and
+// looked up by their prefix, which is stored in clear.
+type APIKeyRepository interface {
+	Create(key *models.APIKey) error
+	Get(id int) (models.APIKey, error)
+	GetByPrefix(prefix string) (models.APIKey, error)
+	List() ([]models.APIKey, error)
+	Revoke(id int, at time.Time) error
+	Touch(id int, at time.Time) error
+}
+
+// OIDCRepository provides access to OpenID Connect logins in progress, keyed
+// by the hash of their state, and to the links between accounts and the
+// identities they sign in with at an identity provider
+type OIDCRepository interface {
+	CreateLogin(login models.OIDCLogin) error
+	ConsumeLogin(stateHash string, at time.Time) (models.OIDCLogin, error)
+	GetIdentity(issuer, subject string) (models.Identity, error)
+	LinkIdentity(identity models.Identity) error
+}
+
+// BookRevisionRepository provides access to the history of book metadata.
+// Revisions of a book are numbered from 1 in the order they were made, and
+// are recorded by BookRepository together with the change they describe.
+type BookRevisionRepository interface {
+	List(bookID int) ([]models.BookRevision, error)
+	Get(bookID, revision int) (models.BookRevision, error)
+}
+
+// AuditFilter selects entries of the audit log. Zero fields match every entry.
+type AuditFilter struct {
+	ActorID  int
+	Action   string
+	Entity   string
+	EntityID int
+	Since    time.Time
+	Until    time.Time
+	// BeforeID skips entries with this ID or a later one, to page back through the log
+	BeforeID int
+	Limit    int
+}
+
+// AuditRepository provides access to the audit log, which can only be appended to
+type AuditRepository interface {
+	Append(entry *models.AuditEntry) error
+	List(filter AuditFilter) ([]models.AuditEntry, error)
+}
+
+// PathFromEnv returns the database path configured through DB_PATH
+func PathFromEnv() string {
+	if path := os.Getenv("DB_PATH"); path != "" {
+		return path
+	}
+	return DefaultPath
+}
+
+// Open opens the SQLite database at path and brings its schema up to date.
+// Transactions take the write lock when they begin, so that one which reads
+// before it writes waits for other writers instead of failing with
+// "database is locked" when it comes to write.
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
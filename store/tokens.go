@@ -0,0 +1,57 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang_project/models"
+)
+
+// SQLiteOneTimeTokenRepository is a OneTimeTokenRepository backed by a table
+// of a SQLite database
+type SQLiteOneTimeTokenRepository struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLitePasswordResetRepository returns a OneTimeTokenRepository of password reset tokens using db
+func NewSQLitePasswordResetRepository(db *sql.DB) *SQLiteOneTimeTokenRepository {
+	return &SQLiteOneTimeTokenRepository{db: db, table: "password_resets"}
+}
+
+// NewSQLiteEmailVerificationRepository returns a OneTimeTokenRepository of email verification tokens using db
+func NewSQLiteEmailVerificationRepository(db *sql.DB) *SQLiteOneTimeTokenRepository {
+	return &SQLiteOneTimeTokenRepository{db: db, table: "email_verifications"}
+}
+
+// Create stores token, retiring the user's earlier unused tokens so that only
+// the most recently mailed link works
+func (r *SQLiteOneTimeTokenRepository) Create(token *models.OneTimeToken) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE "+r.table+" SET used_at = ? WHERE user_id = ? AND used_at IS NULL", token.CreatedAt, token.UserID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO "+r.table+"(token_hash, user_id, created_at, expires_at) VALUES(?, ?, ?, ?)",
+		token.TokenHash, token.UserID, token.CreatedAt, token.ExpiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Consume marks the token with the given hash as used and returns its user.
+// It returns ErrNotFound when the token is unknown, used or expired at at.
+func (r *SQLiteOneTimeTokenRepository) Consume(tokenHash string, at time.Time) (int, error) {
+	var userID int
+	err := r.db.QueryRow("UPDATE "+r.table+" SET used_at = ? WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? RETURNING user_id",
+		at, tokenHash, at).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
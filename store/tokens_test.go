@@ -0,0 +1,39 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestPasswordResetRepository(t *testing.T) {
+	db := openTestDB(t)
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Role: "user"}
+	if err := NewSQLiteUserRepository(db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	resets := NewSQLitePasswordResetRepository(db)
+
+	now := time.Now().UTC()
+	for _, hash := range []string{"first", "second"} {
+		reset := models.OneTimeToken{TokenHash: hash, UserID: user.ID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		if err := resets.Create(&reset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := resets.Consume("first", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("superseded token: got %v want %v", err, ErrNotFound)
+	}
+	if _, err := resets.Consume("second", now.Add(2*time.Hour)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired token: got %v want %v", err, ErrNotFound)
+	}
+	if userID, err := resets.Consume("second", now); err != nil || userID != user.ID {
+		t.Errorf("valid token: got %d, %v want %d", userID, err, user.ID)
+	}
+	if _, err := resets.Consume("second", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("used token: got %v want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"golang_project/models"
+)
+
+// SQLiteTwoFactorRepository is a TwoFactorRepository backed by a SQLite database
+type SQLiteTwoFactorRepository struct {
+	db *sql.DB
+}
+
+// NewSQLiteTwoFactorRepository returns a TwoFactorRepository using db
+func NewSQLiteTwoFactorRepository(db *sql.DB) *SQLiteTwoFactorRepository {
+	return &SQLiteTwoFactorRepository{db: db}
+}
+
+// Get returns the TOTP enrolment of a user
+func (r *SQLiteTwoFactorRepository) Get(userID int) (models.TwoFactor, error) {
+	var tf models.TwoFactor
+	var confirmedAt sql.NullTime
+	err := r.db.QueryRow("SELECT user_id, secret, confirmed_at, last_step FROM two_factor WHERE user_id = ?", userID).
+		Scan(&tf.UserID, &tf.Secret, &confirmedAt, &tf.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return tf, ErrNotFound
+	}
+	if confirmedAt.Valid {
+		tf.ConfirmedAt = &confirmedAt.Time
+	}
+	return tf, err
+}
+
+// Begin stores a new, unconfirmed secret for a user, replacing an earlier
+// unconfirmed one. It returns ErrConflict when the user already confirmed a secret.
+func (r *SQLiteTwoFactorRepository) Begin(userID int, secret string) error {
+	res, err := r.db.Exec(`INSERT INTO two_factor(user_id, secret) VALUES(?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0 WHERE confirmed_at IS NULL`, userID, secret)
+	err = affectedOne(res, err)
+	if errors.Is(err, ErrNotFound) {
+		return ErrConflict
+	}
+	return err
+}
+
+// Confirm turns on the user's pending secret, recording step as used, and
+// replaces their recovery codes with recoveryHashes. It returns ErrNotFound
+// when there is no pending secret.
+func (r *SQLiteTwoFactorRepository) Confirm(userID int, step int64, at time.Time, recoveryHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE two_factor SET confirmed_at = ?, last_step = ? WHERE user_id = ? AND confirmed_at IS NULL", at, step, userID)
+	if err := affectedOne(res, err); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes(code_hash, user_id) VALUES(?, ?)", hash, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UseStep records that the code of step was accepted. It returns ErrConflict
+// when that step or a later one was already used, so that codes cannot be replayed.
+func (r *SQLiteTwoFactorRepository) UseStep(userID int, step int64) error {
+	res, err := r.db.Exec("UPDATE two_factor SET last_step = ? WHERE user_id = ? AND last_step < ?", step, userID, step)
+	err = affectedOne(res, err)
+	if errors.Is(err, ErrNotFound) {
+		return ErrConflict
+	}
+	return err
+}
+
+// UseRecoveryCode marks the user's recovery code with the given hash as used.
+// It returns ErrNotFound when the code is unknown or already used.
+func (r *SQLiteTwoFactorRepository) UseRecoveryCode(userID int, hash string, at time.Time) error {
+	res, err := r.db.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL", at, userID, hash)
+	return affectedOne(res, err)
+}
+
+// Delete turns off two-factor authentication for a user, removing their
+// secret and recovery codes
+func (r *SQLiteTwoFactorRepository) Delete(userID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	res, err := tx.Exec("DELETE FROM two_factor WHERE user_id = ?", userID)
+	if err := affectedOne(res, err); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
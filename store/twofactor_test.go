@@ -0,0 +1,62 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang_project/models"
+)
+
+func TestTwoFactorRepository(t *testing.T) {
+	db := openTestDB(t)
+	user := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", IsActive: true, Role: "admin"}
+	if err := NewSQLiteUserRepository(db).Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	twoFactor := NewSQLiteTwoFactorRepository(db)
+
+	if _, err := twoFactor.Get(user.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("not enrolled: got %v want %v", err, ErrNotFound)
+	}
+	for _, secret := range []string{"FIRST", "SECOND"} {
+		if err := twoFactor.Begin(user.ID, secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now().UTC()
+	if err := twoFactor.Confirm(user.ID, 100, now, []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	tf, err := twoFactor.Get(user.ID)
+	if err != nil || tf.Secret != "SECOND" || tf.ConfirmedAt == nil || tf.LastStep != 100 {
+		t.Errorf("confirmed enrolment: got %+v, %v", tf, err)
+	}
+	if err := twoFactor.Begin(user.ID, "THIRD"); !errors.Is(err, ErrConflict) {
+		t.Errorf("begin when confirmed: got %v want %v", err, ErrConflict)
+	}
+
+	if err := twoFactor.UseStep(user.ID, 100); !errors.Is(err, ErrConflict) {
+		t.Errorf("replayed step: got %v want %v", err, ErrConflict)
+	}
+	if err := twoFactor.UseStep(user.ID, 101); err != nil {
+		t.Errorf("next step: got %v want nil", err)
+	}
+
+	if err := twoFactor.UseRecoveryCode(user.ID, "a", now); err != nil {
+		t.Errorf("recovery code: got %v want nil", err)
+	}
+	if err := twoFactor.UseRecoveryCode(user.ID, "a", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("used recovery code: got %v want %v", err, ErrNotFound)
+	}
+
+	if err := twoFactor.Delete(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := twoFactor.UseRecoveryCode(user.ID, "b", now); !errors.Is(err, ErrNotFound) {
+		t.Errorf("recovery code after delete: got %v want %v", err, ErrNotFound)
+	}
+	if err := twoFactor.Delete(user.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete twice: got %v want %v", err, ErrNotFound)
+	}
+}